@@ -0,0 +1,50 @@
+// Command enrol issues a two-factor enrolment code for an admin. Admins can
+// only log in once they have enrolled, and normally another admin issues
+// the code; this is how the first admin, or one locked out with nobody left
+// to help, gets theirs. Run it on the server and hand the code over out of
+// band, e.g.
+//
+//	enrol -email admin@example.com
+//
+// The admin then logs in with their password and the code within a day.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"time"
+
+	"github.com/joho/godotenv"
+
+	"backend/internal/controllers"
+	database "backend/internal/mogodb"
+)
+
+func main() {
+	email := flag.String("email", "", "email of the admin to enrol")
+	flag.Parse()
+
+	if *email == "" {
+		log.Fatal("-email is required")
+	}
+
+	if err := godotenv.Load(); err != nil {
+		slog.Error("No .env file found, falling back to system environment")
+	}
+
+	database.Connect()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	code, expiresAt, err := controllers.IssueAdminEnrolment(ctx, *email, "cmd/enrol")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("✅ Enrolment code issued for %s, valid until %s", *email, time.Unix(expiresAt, 0).Format(time.RFC1123))
+	fmt.Println(code)
+}
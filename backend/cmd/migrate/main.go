@@ -0,0 +1,44 @@
+// Command migrate runs the data migrations against the smartcanteen
+// database. Each migration is idempotent, so it can be re-run safely.
+package main
+
+import (
+	"context"
+	"log"
+	"log/slog"
+	"time"
+
+	"github.com/joho/godotenv"
+
+	"backend/internal/migrations"
+	database "backend/internal/mogodb"
+)
+
+func main() {
+	if err := godotenv.Load(); err != nil {
+		slog.Error("No .env file found, falling back to system environment")
+	}
+
+	database.Connect()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	changed, err := migrations.ConvertMoney(ctx, database.Client.Database("smartcanteen"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("✅ Money migration done, %d documents converted", changed)
+
+	changed, err = migrations.NormalizeEmails(ctx, database.Client.Database("smartcanteen"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("✅ Email migration done, %d users normalised", changed)
+
+	changed, err = migrations.RenameWastageValue(ctx, database.Client.Database("smartcanteen"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("✅ Wastage migration done, %d entries renamed", changed)
+}
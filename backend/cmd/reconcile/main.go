@@ -0,0 +1,194 @@
+// Command reconcile compares Razorpay's payments with the orders, split
+// order shares and meal plan subscriptions paid through it for a date range
+// and prints the mismatches as JSON. With -fix it also completes orders
+// whose payment was captured but never verified, the one case that is safe
+// to fix unattended. Run it daily from cron, e.g.
+//
+//	reconcile -from 2026-10-18 -to 2026-10-19 -fix
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"log"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/razorpay/razorpay-go"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"backend/internal/controllers"
+	"backend/internal/model"
+	database "backend/internal/mogodb"
+	"backend/internal/reconcile"
+)
+
+// slack widens the window payments are fetched for, so a payment made just
+// either side of midnight still finds its order.
+const slack = time.Hour
+
+func main() {
+	today := time.Now().Format("2006-01-02")
+	yesterday := time.Now().AddDate(0, 0, -1).Format("2006-01-02")
+
+	fromFlag := flag.String("from", yesterday, "first day to reconcile, YYYY-MM-DD")
+	toFlag := flag.String("to", today, "day to stop before, YYYY-MM-DD")
+	fix := flag.Bool("fix", false, "complete orders whose payment was captured but never verified")
+	flag.Parse()
+
+	from, err := time.ParseInLocation("2006-01-02", *fromFlag, time.Local)
+	if err != nil {
+		log.Fatalf("invalid -from: %v", err)
+	}
+	to, err := time.ParseInLocation("2006-01-02", *toFlag, time.Local)
+	if err != nil {
+		log.Fatalf("invalid -to: %v", err)
+	}
+	if !to.After(from) {
+		log.Fatal("-to must be after -from")
+	}
+
+	if err := godotenv.Load(); err != nil {
+		slog.Error("No .env file found, falling back to system environment")
+	}
+
+	database.Connect()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	gateway := reconcile.Razorpay{
+		Client: razorpay.NewClient(os.Getenv("RAZORPAY_KEY_ID"), os.Getenv("RAZORPAY_KEY_SECRET")),
+	}
+	payments, err := gateway.Payments(ctx, from.Add(-slack), to.Add(slack))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	charges, ignore, err := load(ctx, from, to, payments)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	report := reconcile.ReconcileCharges(payments, charges, ignore)
+	report.From, report.To = from, to
+
+	if *fix {
+		applyFixes(ctx, report)
+	}
+
+	out := json.NewEncoder(os.Stdout)
+	out.SetIndent("", "  ")
+	if err := out.Encode(report); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("%d payments, %d orders, %d matched, %d mismatches", report.Payments, report.Orders, report.Matched, len(report.Mismatches))
+}
+
+// load fetches everything paid through Razorpay in the period, and anything
+// a payment refers to, as charges: orders, the shares of split orders and
+// meal plan subscriptions. Wallet top-ups are returned as gateway order IDs
+// to ignore.
+func load(ctx context.Context, from, to time.Time, payments []reconcile.Payment) ([]reconcile.Charge, map[string]bool, error) {
+	orderColl := database.GetCollection("smartcanteen", "orders")
+	topupColl := database.GetCollection("smartcanteen", "wallet_topups")
+	shareColl := database.GetCollection("smartcanteen", "payment_shares")
+	subColl := database.GetCollection("smartcanteen", "meal_subscriptions")
+
+	var gatewayOrderIDs []string
+	for _, p := range payments {
+		if p.OrderID != "" {
+			gatewayOrderIDs = append(gatewayOrderIDs, p.OrderID)
+		}
+	}
+	period := bson.M{"$gte": from.Unix(), "$lt": to.Unix()}
+
+	cursor, err := orderColl.Find(ctx, bson.M{"$or": bson.A{
+		bson.M{"razorpay_order_id": bson.M{"$in": gatewayOrderIDs}},
+		bson.M{
+			"paymentMethod": "razorpay",
+			"splitMode":     bson.M{"$exists": false},
+			"isPaid":        true,
+			"updatedAt":     period,
+		},
+	}})
+	if err != nil {
+		return nil, nil, err
+	}
+	var orders []model.Order
+	if err := cursor.All(ctx, &orders); err != nil {
+		return nil, nil, err
+	}
+	var charges []reconcile.Charge
+	for _, o := range orders {
+		charges = append(charges, reconcile.OrderCharge(o))
+	}
+
+	cursor, err = shareColl.Find(ctx, bson.M{"$or": bson.A{
+		bson.M{"razorpayOrderId": bson.M{"$in": gatewayOrderIDs}},
+		bson.M{"paidAt": period},
+	}})
+	if err != nil {
+		return nil, nil, err
+	}
+	var shares []model.PaymentShare
+	if err := cursor.All(ctx, &shares); err != nil {
+		return nil, nil, err
+	}
+	for _, s := range shares {
+		charges = append(charges, reconcile.ShareCharge(s))
+	}
+
+	cursor, err = subColl.Find(ctx, bson.M{"$or": bson.A{
+		bson.M{"razorpayOrderId": bson.M{"$in": gatewayOrderIDs}},
+		bson.M{"paidAt": period},
+	}})
+	if err != nil {
+		return nil, nil, err
+	}
+	var subs []model.MealSubscription
+	if err := cursor.All(ctx, &subs); err != nil {
+		return nil, nil, err
+	}
+	for _, s := range subs {
+		charges = append(charges, reconcile.SubscriptionCharge(s))
+	}
+
+	cursor, err = topupColl.Find(ctx, bson.M{"razorpayOrderId": bson.M{"$in": gatewayOrderIDs}})
+	if err != nil {
+		return nil, nil, err
+	}
+	var topups []model.WalletTopup
+	if err := cursor.All(ctx, &topups); err != nil {
+		return nil, nil, err
+	}
+	ignore := map[string]bool{}
+	for _, t := range topups {
+		ignore[t.RazorpayOrderID] = true
+	}
+
+	return charges, ignore, nil
+}
+
+// applyFixes completes the orders the report marks safe to fix.
+func applyFixes(ctx context.Context, report reconcile.Report) {
+	for _, m := range report.Mismatches {
+		if !m.AutoFix {
+			continue
+		}
+		oid, err := primitive.ObjectIDFromHex(m.OrderID)
+		if err != nil {
+			continue
+		}
+		completed, err := controllers.CompleteGatewayPayment(ctx, oid, m.RazorpayOrderID, m.PaymentID)
+		if err != nil || !completed {
+			slog.Error("failed to complete order", "order", m.OrderID, "payment", m.PaymentID, "error", err)
+			continue
+		}
+		log.Printf("✅ Completed order %s with payment %s", m.OrderID, m.PaymentID)
+	}
+}
@@ -0,0 +1,173 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Key is one JWT signing key. Keys loaded from a public key only can verify
+// tokens but never sign them, which is how retired keys are kept around
+// until the tokens they signed have expired.
+type Key struct {
+	ID        string
+	Method    jwt.SigningMethod
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+// KeySet holds every key tokens may be verified with and the one new tokens
+// are signed with.
+type KeySet struct {
+	active string
+	keys   map[string]*Key
+	order  []string
+}
+
+var Keys *KeySet
+
+// LoadKeys reads the signing keys from the environment.
+//
+// JWT_KEYS is a comma separated list of kid:alg:source entries. For HS256
+// the source is the shared secret; for RS256 and EdDSA it is the path of a
+// PEM encoded private key, or of a public key for verify-only keys.
+// JWT_ACTIVE_KID picks the key new tokens are signed with and defaults to
+// the first entry. When JWT_KEYS is unset, JWT_SECRET is used as a single
+// HS256 key.
+func LoadKeys() error {
+	ks := &KeySet{keys: map[string]*Key{}}
+
+	spec := os.Getenv("JWT_KEYS")
+	if spec == "" {
+		if secret := os.Getenv("JWT_SECRET"); secret != "" {
+			spec = "default:HS256:" + secret
+		}
+	}
+
+	if spec == "" {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return err
+		}
+		slog.Warn("no JWT_KEYS or JWT_SECRET configured, using a random key; tokens won't survive a restart")
+		ks.add(&Key{ID: "ephemeral", Method: jwt.SigningMethodHS256, signKey: secret, verifyKey: secret})
+	} else {
+		for _, entry := range strings.Split(spec, ",") {
+			key, err := parseKey(strings.TrimSpace(entry))
+			if err != nil {
+				return err
+			}
+			if _, exists := ks.keys[key.ID]; exists {
+				return fmt.Errorf("duplicate JWT key id %q", key.ID)
+			}
+			ks.add(key)
+		}
+	}
+
+	ks.active = os.Getenv("JWT_ACTIVE_KID")
+	if ks.active == "" {
+		ks.active = ks.order[0]
+	}
+	active, ok := ks.keys[ks.active]
+	if !ok {
+		return fmt.Errorf("JWT_ACTIVE_KID %q is not a configured key", ks.active)
+	}
+	if active.signKey == nil {
+		return fmt.Errorf("JWT key %q has no private key and can't sign", ks.active)
+	}
+
+	Keys = ks
+	return nil
+}
+
+func (ks *KeySet) add(key *Key) {
+	ks.keys[key.ID] = key
+	ks.order = append(ks.order, key.ID)
+}
+
+func parseKey(entry string) (*Key, error) {
+	parts := strings.SplitN(entry, ":", 3)
+	if len(parts) != 3 || parts[0] == "" || parts[2] == "" {
+		return nil, fmt.Errorf("invalid JWT key entry %q, expected kid:alg:source", entry)
+	}
+	kid, alg, source := parts[0], strings.ToUpper(parts[1]), parts[2]
+
+	switch alg {
+	case "HS256":
+		return &Key{ID: kid, Method: jwt.SigningMethodHS256, signKey: []byte(source), verifyKey: []byte(source)}, nil
+	case "RS256", "EDDSA":
+	default:
+		return nil, fmt.Errorf("unsupported JWT algorithm %q for key %q", parts[1], kid)
+	}
+
+	data, err := os.ReadFile(source)
+	if err != nil {
+		return nil, fmt.Errorf("reading JWT key %q: %w", kid, err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("JWT key %q is not PEM encoded", kid)
+	}
+	public := strings.Contains(block.Type, "PUBLIC KEY")
+
+	key := &Key{ID: kid}
+	if alg == "RS256" {
+		key.Method = jwt.SigningMethodRS256
+		if public {
+			key.verifyKey, err = jwt.ParseRSAPublicKeyFromPEM(data)
+		} else {
+			var private *rsa.PrivateKey
+			private, err = jwt.ParseRSAPrivateKeyFromPEM(data)
+			if err == nil {
+				key.signKey, key.verifyKey = private, &private.PublicKey
+			}
+		}
+	} else {
+		key.Method = jwt.SigningMethodEdDSA
+		if public {
+			key.verifyKey, err = jwt.ParseEdPublicKeyFromPEM(data)
+		} else {
+			var private interface{}
+			private, err = jwt.ParseEdPrivateKeyFromPEM(data)
+			if err == nil {
+				edKey := private.(ed25519.PrivateKey)
+				key.signKey, key.verifyKey = edKey, edKey.Public()
+			}
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing JWT key %q: %w", kid, err)
+	}
+	return key, nil
+}
+
+// Sign signs the claims with the active key, naming it in the kid header.
+func (ks *KeySet) Sign(claims jwt.Claims) (string, error) {
+	key := ks.keys[ks.active]
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.signKey)
+}
+
+// Parse verifies a token against the key named in its kid header.
+func (ks *KeySet) Parse(tokenString string) (*jwt.Token, error) {
+	return jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, ok := ks.keys[kid]
+		if !ok {
+			return nil, errors.New("unknown signing key")
+		}
+		if token.Method.Alg() != key.Method.Alg() {
+			return nil, jwt.ErrTokenSignatureInvalid
+		}
+		return key.verifyKey, nil
+	})
+}
@@ -0,0 +1,31 @@
+package controllers
+
+import (
+	"backend/internal/model"
+	mongodb "backend/internal/mogodb"
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// writeAudit records an administrative change made by the caller. Failing
+// to audit is logged but doesn't undo the change.
+func writeAudit(ctx context.Context, c *gin.Context, action, targetType, targetID string, details map[string]interface{}) {
+	auditColl := mongodb.GetCollection("smartcanteen", "audit_logs")
+
+	_, err := auditColl.InsertOne(ctx, model.AuditLog{
+		ActorID:    c.GetString("user_id"),
+		ActorName:  c.GetString("username"),
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		Details:    details,
+		IP:         c.ClientIP(),
+		CreatedAt:  time.Now().Unix(),
+	})
+	if err != nil {
+		slog.Error("failed to write audit log", "action", action, "target", targetID, "error", err)
+	}
+}
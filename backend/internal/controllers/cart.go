@@ -3,7 +3,10 @@ package controllers
 import (
 	"backend/internal/model"
 	mongodb "backend/internal/mogodb"
+	"backend/internal/promo"
 	"context"
+	"errors"
+	"log/slog"
 	"net/http"
 	"time"
 
@@ -12,102 +15,168 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// cartOwner is the authenticated user whose cart a request acts on.
+func cartOwner(c *gin.Context) (string, bool) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return "", false
+	}
+	return userID, true
+}
+
+// legacyCartOwner backs the deprecated routes that carry :user_id in the
+// path. The path must name the authenticated user.
+func legacyCartOwner(c *gin.Context) (string, bool) {
+	c.Header("Deprecation", "true")
+	c.Header("Link", "</user/cart/items/"+c.Param("id")+">; rel=\"successor-version\"")
+
+	userID, ok := cartOwner(c)
+	if !ok {
+		return "", false
+	}
+	if c.Param("user_id") != userID {
+		slog.Error("cart access for another user denied", "user", userID, "target", c.Param("user_id"))
+		c.JSON(http.StatusForbidden, gin.H{"error": "Cannot modify another user's cart"})
+		return "", false
+	}
+	return userID, true
+}
+
+func AddCartItem(c *gin.Context) {
+	if userID, ok := cartOwner(c); ok {
+		addCartItem(c, userID, c.Param("productId"))
+	}
+}
+
+func UpdateCartItem(c *gin.Context) {
+	if userID, ok := cartOwner(c); ok {
+		updateCartItem(c, userID, c.Param("productId"))
+	}
+}
+
+func RemoveCartItem(c *gin.Context) {
+	if userID, ok := cartOwner(c); ok {
+		removeCartItem(c, userID, c.Param("productId"))
+	}
+}
+
+// Deprecated: use AddCartItem.
 func AddtoCart(c *gin.Context) {
-    product_id := c.Param("id")
-    user_id := c.Param("user_id")
-
-    var input model.AddtoCart
-    if err := c.ShouldBindJSON(&input); err != nil {
-        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
-        return
-    }
-
-    role, _ := c.Get("role")
-    if role != "user" {
-        c.JSON(http.StatusForbidden, gin.H{"error": "Only user can add products to cart"})
-        return
-    }
-
-    // Convert IDs
-    oid, err := primitive.ObjectIDFromHex(user_id)
-    if err != nil {
-        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
-        return
-    }
-    pid, err := primitive.ObjectIDFromHex(product_id)
-    if err != nil {
-        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
-        return
-    }
-
-    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-    defer cancel()
-
-    productColl := mongodb.GetCollection("smartcanteen", "products")
-    var product bson.M
-    if err := productColl.FindOne(ctx, bson.M{"_id": pid}).Decode(&product); err != nil {
-        c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
-        return
-    }
-
-    availableQty, ok := product["quantity"].(int32) 
-    if !ok {
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid product quantity"})
-        return
-    }
-
-    if input.Quantity > int(availableQty) {
-        c.JSON(http.StatusBadRequest, gin.H{"error": "Quantity exceeds available stock"})
-        return
-    }
-
-    cartColl := mongodb.GetCollection("smartcanteen", "addtocart")
-    var existingCart bson.M
-    err = cartColl.FindOne(ctx, bson.M{
-        "user_id":    oid,
-        "product_id": pid,
-    }).Decode(&existingCart)
-
-    if err == nil {
-        newQty := existingCart["quantity"].(int32) + int32(input.Quantity)
-        if int(newQty) > int(availableQty) {
-            c.JSON(http.StatusBadRequest, gin.H{"error": "Total quantity exceeds available stock"})
-            return
-        }
-
-        _, err = cartColl.UpdateOne(
-            ctx,
-            bson.M{"_id": existingCart["_id"]},
-            bson.M{"$set": bson.M{"quantity": newQty}},
-        )
-        if err != nil {
-            c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update cart"})
-            return
-        }
-
-        c.JSON(http.StatusOK, gin.H{
-            "message": "Cart updated successfully",
-        })
-        return
-    }
-
-    cart := bson.M{
-        "product_id": pid,
-        "user_id":    oid,
-        "quantity":   input.Quantity,
-    }
-    result, err := cartColl.InsertOne(ctx, cart)
-    if err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add product to cart"})
-        return
-    }
-
-    c.JSON(http.StatusOK, gin.H{
-        "message": "Product added successfully",
-        "id":      result.InsertedID.(primitive.ObjectID).Hex(),
-    })
+	if userID, ok := legacyCartOwner(c); ok {
+		addCartItem(c, userID, c.Param("id"))
+	}
 }
 
+// Deprecated: use UpdateCartItem.
+func UpdateCart(c *gin.Context) {
+	if userID, ok := legacyCartOwner(c); ok {
+		updateCartItem(c, userID, c.Param("id"))
+	}
+}
+
+// Deprecated: use RemoveCartItem.
+func RemoveCart(c *gin.Context) {
+	if userID, ok := legacyCartOwner(c); ok {
+		removeCartItem(c, userID, c.Param("id"))
+	}
+}
+
+func addCartItem(c *gin.Context, user_id string, product_id string) {
+	var input model.AddtoCart
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+	if input.Quantity < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Quantity must be at least 1"})
+		return
+	}
+
+	// Convert IDs
+	oid, err := primitive.ObjectIDFromHex(user_id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	pid, err := primitive.ObjectIDFromHex(product_id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	productColl := mongodb.GetCollection("smartcanteen", "products")
+	var product bson.M
+	if err := productColl.FindOne(ctx, bson.M{"_id": pid}).Decode(&product); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+
+	availableQty, ok := product["quantity"].(int32)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid product quantity"})
+		return
+	}
+
+	// Today's menu, when published, decides how many portions can be ordered
+	if remaining, ok := menuRemaining(ctx, product_id); ok {
+		availableQty = int32(remaining)
+	}
+
+	if input.Quantity > int(availableQty) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Quantity exceeds available stock"})
+		return
+	}
+
+	cartColl := mongodb.GetCollection("smartcanteen", "addtocart")
+	var existingCart bson.M
+	err = cartColl.FindOne(ctx, bson.M{
+		"user_id":    oid,
+		"product_id": pid,
+	}).Decode(&existingCart)
+
+	if err == nil {
+		newQty := existingCart["quantity"].(int32) + int32(input.Quantity)
+		if int(newQty) > int(availableQty) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Total quantity exceeds available stock"})
+			return
+		}
+
+		_, err = cartColl.UpdateOne(
+			ctx,
+			bson.M{"_id": existingCart["_id"]},
+			bson.M{"$set": bson.M{"quantity": newQty}},
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update cart"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Cart updated successfully",
+		})
+		return
+	}
+
+	cart := bson.M{
+		"product_id": pid,
+		"user_id":    oid,
+		"quantity":   input.Quantity,
+	}
+	result, err := cartColl.InsertOne(ctx, cart)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add product to cart"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Product added successfully",
+		"id":      result.InsertedID.(primitive.ObjectID).Hex(),
+	})
+}
 
 func GetCart(c *gin.Context) {
 	userID, exists := c.Get("user_id")
@@ -128,7 +197,6 @@ func GetCart(c *gin.Context) {
 		return
 	}
 
-
 	cartColl := mongodb.GetCollection("smartcanteen", "addtocart")
 	productColl := mongodb.GetCollection("smartcanteen", "products")
 
@@ -149,146 +217,155 @@ func GetCart(c *gin.Context) {
 	}
 
 	var response []gin.H
-	var grandTotal float64 = 0
+	var promoLines []promo.Line
+	grandTotal := model.Paise(0)
 
 	for _, item := range cartItems {
 		pid := item["product_id"].(primitive.ObjectID)
 
-		var product bson.M
+		var product model.Product
 		err := productColl.FindOne(ctx, bson.M{"_id": pid}).Decode(&product)
 		if err != nil {
-			continue 
+			continue
 		}
 
-		quantity := int(item["quantity"].(int32)) 
-		price := product["price"].(float64)       
-		total := float64(quantity) * price
+		quantity := int(item["quantity"].(int32))
+		price := product.Price
+		total := price.Mul(quantity)
 
-		grandTotal += total
+		grandTotal = grandTotal.Add(total)
+		promoLines = append(promoLines, promoLine(product, quantity))
 
 		response = append(response, gin.H{
-			"name":        product["name"],
-			"description": product["description"],
-            "productId":pid,
-			"price":       price,
-			"quantity":    quantity,
-            "totalQuantity":product["quantity"],
-			"total":       total,
+			"name":          product.Name,
+			"description":   product.Description,
+			"productId":     pid,
+			"price":         price,
+			"quantity":      quantity,
+			"totalQuantity": product.Quantity,
+			"total":         total,
 		})
 	}
 
+	// Preview the discounts the order would get; a coupon that no longer
+	// applies is reported rather than failing the cart
+	coupon := cartCoupon(ctx, uidStr)
+	discounts, err := applyPromotions(ctx, uidStr, coupon, promoLines)
+	var invalid couponError
+	couponStatus := gin.H{"code": coupon}
+	if errors.As(err, &invalid) {
+		couponStatus["error"] = invalid.Error()
+		discounts, err = applyPromotions(ctx, uidStr, "", promoLines)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to apply promotions"})
+		return
+	}
+
+	resp := gin.H{
+		"items":         response,
+		"grandTotal":    grandTotal,
+		"discounts":     discounts.Applied,
+		"discountTotal": discounts.Total,
+	}
+	if coupon != "" {
+		resp["coupon"] = couponStatus
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+func updateCartItem(c *gin.Context, user_id string, product_id string) {
+	var input struct {
+		Quantity int `json:"quantity" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	if input.Quantity < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Quantity must be at least 1"})
+		return
+	}
+
+	oid, err := primitive.ObjectIDFromHex(user_id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	pid, err := primitive.ObjectIDFromHex(product_id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	productColl := mongodb.GetCollection("smartcanteen", "products")
+	var product bson.M
+	if err := productColl.FindOne(ctx, bson.M{"_id": pid}).Decode(&product); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+
+	availableQty, ok := product["quantity"].(int32)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid product quantity"})
+		return
+	}
+
+	if remaining, ok := menuRemaining(ctx, product_id); ok {
+		availableQty = int32(remaining)
+	}
+
+	if input.Quantity > int(availableQty) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Quantity exceeds available stock"})
+		return
+	}
+
+	cartColl := mongodb.GetCollection("smartcanteen", "addtocart")
+	result, err := cartColl.UpdateOne(
+		ctx,
+		bson.M{"user_id": oid, "product_id": pid},
+		bson.M{"$set": bson.M{"quantity": input.Quantity}},
+	)
+
+	if err != nil || result.MatchedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Cart item not found"})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
-		"items":      response,
-		"grandTotal": grandTotal,
+		"message": "Cart updated successfully",
 	})
 }
 
-func UpdateCart(c *gin.Context) {
-    user_id := c.Param("user_id")
-    product_id := c.Param("id")
-
-    var input struct {
-        Quantity int `json:"quantity" binding:"required"`
-    }
-    if err := c.ShouldBindJSON(&input); err != nil {
-        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
-        return
-    }
-
-    if input.Quantity < 1 {
-        c.JSON(http.StatusBadRequest, gin.H{"error": "Quantity must be at least 1"})
-        return
-    }
-
-    role, _ := c.Get("role")
-    if role != "user" {
-        c.JSON(http.StatusForbidden, gin.H{"error": "Only user can update cart"})
-        return
-    }
-
-    oid, err := primitive.ObjectIDFromHex(user_id)
-    if err != nil {
-        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
-        return
-    }
-    pid, err := primitive.ObjectIDFromHex(product_id)
-    if err != nil {
-        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
-        return
-    }
-
-    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-    defer cancel()
-
-    productColl := mongodb.GetCollection("smartcanteen", "products")
-    var product bson.M
-    if err := productColl.FindOne(ctx, bson.M{"_id": pid}).Decode(&product); err != nil {
-        c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
-        return
-    }
-
-    availableQty, ok := product["quantity"].(int32)
-    if !ok {
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid product quantity"})
-        return
-    }
-
-    if input.Quantity > int(availableQty) {
-        c.JSON(http.StatusBadRequest, gin.H{"error": "Quantity exceeds available stock"})
-        return
-    }
-
-    cartColl := mongodb.GetCollection("smartcanteen", "addtocart")
-    result, err := cartColl.UpdateOne(
-        ctx,
-        bson.M{"user_id": oid, "product_id": pid},
-        bson.M{"$set": bson.M{"quantity": input.Quantity}},
-    )
-
-    if err != nil || result.MatchedCount == 0 {
-        c.JSON(http.StatusNotFound, gin.H{"error": "Cart item not found"})
-        return
-    }
-
-    c.JSON(http.StatusOK, gin.H{
-        "message": "Cart updated successfully",
-    })
-}
+func removeCartItem(c *gin.Context, user_id string, product_id string) {
+	oid, err := primitive.ObjectIDFromHex(user_id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	pid, err := primitive.ObjectIDFromHex(product_id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+		return
+	}
 
-func RemoveCart(c *gin.Context) {
-    user_id := c.Param("user_id")
-    product_id := c.Param("id")
-
-    role, _ := c.Get("role")
-    if role != "user" {
-        c.JSON(http.StatusForbidden, gin.H{"error": "Only user can remove cart items"})
-        return
-    }
-
-    oid, err := primitive.ObjectIDFromHex(user_id)
-    if err != nil {
-        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
-        return
-    }
-    pid, err := primitive.ObjectIDFromHex(product_id)
-    if err != nil {
-        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
-        return
-    }
-
-    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-    defer cancel()
-
-    cartColl := mongodb.GetCollection("smartcanteen", "addtocart")
-    result, err := cartColl.DeleteOne(ctx, bson.M{"user_id": oid, "product_id": pid})
-    if err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove from cart"})
-        return
-    }
-    if result.DeletedCount == 0 {
-        c.JSON(http.StatusNotFound, gin.H{"error": "Cart item not found"})
-        return
-    }
-
-    c.JSON(http.StatusOK, gin.H{"message": "Product removed from cart"})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cartColl := mongodb.GetCollection("smartcanteen", "addtocart")
+	result, err := cartColl.DeleteOne(ctx, bson.M{"user_id": oid, "product_id": pid})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove from cart"})
+		return
+	}
+	if result.DeletedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Cart item not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Product removed from cart"})
 }
@@ -0,0 +1,91 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	cartTestUser    = "64b7f0c2a1b2c3d4e5f60718"
+	cartTestOther   = "64b7f0c2a1b2c3d4e5f60799"
+	cartTestProduct = "64b7f0c2a1b2c3d4e5f60001"
+)
+
+// cartTestRouter mounts the cart handlers behind a stand-in for
+// AuthMiddleware that authenticates every request as userID.
+func cartTestRouter(userID string) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		if userID != "" {
+			c.Set("user_id", userID)
+		}
+		c.Set("role", "user")
+	})
+
+	r.POST("/user/cart/items/:productId", AddCartItem)
+	r.PATCH("/user/cart/items/:productId", UpdateCartItem)
+	r.DELETE("/user/cart/items/:productId", RemoveCartItem)
+	r.POST("/addtocart/:id/:user_id", AddtoCart)
+	r.PATCH("/user/cart/:user_id/:id", UpdateCart)
+	r.DELETE("/user/cart/:user_id/:id", RemoveCart)
+	return r
+}
+
+func TestLegacyCartRoutesRejectOtherUsers(t *testing.T) {
+	r := cartTestRouter(cartTestUser)
+
+	requests := []struct {
+		method string
+		path   string
+	}{
+		{"POST", "/addtocart/" + cartTestProduct + "/" + cartTestOther},
+		{"PATCH", "/user/cart/" + cartTestOther + "/" + cartTestProduct},
+		{"DELETE", "/user/cart/" + cartTestOther + "/" + cartTestProduct},
+	}
+
+	for _, req := range requests {
+		w := httptest.NewRecorder()
+		body := strings.NewReader(`{"quantity": 1}`)
+		r.ServeHTTP(w, httptest.NewRequest(req.method, req.path, body))
+
+		if w.Code != http.StatusForbidden {
+			t.Errorf("%s %s: got status %d, want %d", req.method, req.path, w.Code, http.StatusForbidden)
+		}
+		if w.Header().Get("Deprecation") != "true" {
+			t.Errorf("%s %s: missing Deprecation header", req.method, req.path)
+		}
+	}
+}
+
+func TestCartRoutesRequireIdentity(t *testing.T) {
+	r := cartTestRouter("")
+
+	for _, method := range []string{"POST", "PATCH", "DELETE"} {
+		w := httptest.NewRecorder()
+		body := strings.NewReader(`{"quantity": 1}`)
+		r.ServeHTTP(w, httptest.NewRequest(method, "/user/cart/items/"+cartTestProduct, body))
+
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%s: got status %d, want %d", method, w.Code, http.StatusUnauthorized)
+		}
+	}
+}
+
+func TestAddCartItemRejectsNonPositiveQuantity(t *testing.T) {
+	r := cartTestRouter(cartTestUser)
+
+	for _, quantity := range []string{"0", "-10"} {
+		w := httptest.NewRecorder()
+		body := strings.NewReader(`{"quantity": ` + quantity + `}`)
+		r.ServeHTTP(w, httptest.NewRequest("POST", "/user/cart/items/"+cartTestProduct, body))
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("quantity %s: got status %d, want %d", quantity, w.Code, http.StatusBadRequest)
+		}
+	}
+}
@@ -0,0 +1,379 @@
+package controllers
+
+import (
+	"backend/internal/model"
+	mongodb "backend/internal/mogodb"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	shiftOpen   = "open"
+	shiftClosed = "closed"
+)
+
+var errNoOpenShift = errors.New("no open cash shift")
+
+// openShift returns the cashier's open shift, or errNoOpenShift.
+func openShift(ctx context.Context, cashierID string) (model.CashShift, error) {
+	shiftColl := mongodb.GetCollection("smartcanteen", "cash_shifts")
+
+	var shift model.CashShift
+	err := shiftColl.FindOne(ctx, bson.M{"cashierId": cashierID, "status": shiftOpen}).Decode(&shift)
+	if err == mongo.ErrNoDocuments {
+		return shift, errNoOpenShift
+	}
+	return shift, err
+}
+
+// addToShift adds amount paise to one of the running totals of a shift,
+// as long as it is still open.
+func addToShift(ctx context.Context, shiftID, field string, amount int64) error {
+	shiftColl := mongodb.GetCollection("smartcanteen", "cash_shifts")
+
+	sid, err := primitive.ObjectIDFromHex(shiftID)
+	if err != nil {
+		return errNoOpenShift
+	}
+	inc := bson.M{field + ".amount": amount}
+	if field == "cashSales" || field == "cardSales" {
+		// A negative sale takes back one added before
+		inc["orders"] = 1
+		if amount < 0 {
+			inc["orders"] = -1
+		}
+	}
+	res, err := shiftColl.UpdateOne(ctx, bson.M{"_id": sid, "status": shiftOpen}, bson.M{"$inc": inc})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return errNoOpenShift
+	}
+	return nil
+}
+
+// expectedCash is what should be in the drawer.
+func expectedCash(shift model.CashShift) model.Money {
+	return shift.OpeningFloat.Add(shift.CashSales).Add(shift.CashTopups).Sub(shift.CashRefunds)
+}
+
+func shiftView(shift model.CashShift) gin.H {
+	view := gin.H{"shift": shift}
+	if shift.Status == shiftOpen {
+		view["expected"] = expectedCash(shift)
+	}
+	return view
+}
+
+// OpenShift starts the caller's shift with the float put in the drawer.
+func OpenShift(c *gin.Context) {
+	var input struct {
+		OpeningFloat model.Money `json:"openingFloat"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil || input.OpeningFloat.Amount < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	shiftColl := mongodb.GetCollection("smartcanteen", "cash_shifts")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	shift := model.CashShift{
+		CashierID:    c.GetString("user_id"),
+		CashierName:  c.GetString("username"),
+		Status:       shiftOpen,
+		OpeningFloat: model.Paise(input.OpeningFloat.Amount),
+		CashSales:    model.Paise(0),
+		CardSales:    model.Paise(0),
+		CashTopups:   model.Paise(0),
+		CashRefunds:  model.Paise(0),
+		Expected:     model.Paise(0),
+		Counted:      model.Paise(0),
+		Variance:     model.Paise(0),
+		OpenedAt:     time.Now().Unix(),
+	}
+
+	// The unique index on open shifts allows one per cashier
+	res, err := shiftColl.InsertOne(ctx, shift)
+	if mongo.IsDuplicateKeyError(err) {
+		c.JSON(http.StatusConflict, gin.H{"error": "You already have an open shift"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open shift"})
+		return
+	}
+	shift.ID = res.InsertedID.(primitive.ObjectID).Hex()
+
+	writeAudit(ctx, c, "shift_open", "cash_shift", shift.ID, map[string]interface{}{
+		"openingFloat": shift.OpeningFloat,
+	})
+
+	c.JSON(http.StatusOK, shiftView(shift))
+}
+
+// GetCurrentShift shows the caller's open shift and what the drawer should
+// hold.
+func GetCurrentShift(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	shift, err := openShift(ctx, c.GetString("user_id"))
+	if err == errNoOpenShift {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No open shift"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch shift"})
+		return
+	}
+	c.JSON(http.StatusOK, shiftView(shift))
+}
+
+// CloseShift ends the caller's shift, recording the cash counted in the
+// drawer against what was expected.
+func CloseShift(c *gin.Context) {
+	var input struct {
+		CountedCash model.Money `json:"countedCash"`
+		Note        string      `json:"note"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil || input.CountedCash.Amount < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	shiftColl := mongodb.GetCollection("smartcanteen", "cash_shifts")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	shift, err := openShift(ctx, c.GetString("user_id"))
+	if err == errNoOpenShift {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No open shift"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch shift"})
+		return
+	}
+	sid, _ := primitive.ObjectIDFromHex(shift.ID)
+
+	// Close against the totals as they stand at the moment of closing, so a
+	// sale finishing concurrently can't slip in unaccounted
+	err = shiftColl.FindOneAndUpdate(
+		ctx,
+		bson.M{"_id": sid, "status": shiftOpen},
+		bson.M{"$set": bson.M{"status": shiftClosed, "closedAt": time.Now().Unix(), "note": input.Note}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&shift)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to close shift"})
+		return
+	}
+
+	shift.Expected = model.Paise(expectedCash(shift).Amount)
+	shift.Counted = model.Paise(input.CountedCash.Amount)
+	shift.Variance = shift.Counted.Sub(shift.Expected)
+	_, err = shiftColl.UpdateByID(ctx, sid, bson.M{"$set": bson.M{
+		"expected": shift.Expected,
+		"counted":  shift.Counted,
+		"variance": shift.Variance,
+	}})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to close shift"})
+		return
+	}
+
+	writeAudit(ctx, c, "shift_close", "cash_shift", shift.ID, map[string]interface{}{
+		"expected": shift.Expected,
+		"counted":  shift.Counted,
+		"variance": shift.Variance,
+	})
+
+	c.JSON(http.StatusOK, gin.H{"shift": shift})
+}
+
+// ListCashShifts lists the shifts opened on a day, with the day's totals,
+// for end-of-day reconciliation.
+func ListCashShifts(c *gin.Context) {
+	start, end, err := dayRange(c.Query("date"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid date, expected YYYY-MM-DD"})
+		return
+	}
+
+	shiftColl := mongodb.GetCollection("smartcanteen", "cash_shifts")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cursor, err := shiftColl.Find(ctx,
+		bson.M{"openedAt": bson.M{"$gte": start, "$lt": end}},
+		options.Find().SetSort(bson.D{{Key: "openedAt", Value: 1}}),
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch shifts"})
+		return
+	}
+	shifts := []model.CashShift{}
+	if err := cursor.All(ctx, &shifts); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch shifts"})
+		return
+	}
+
+	cashSales, cardSales, variance := model.Paise(0), model.Paise(0), model.Paise(0)
+	open := 0
+	for _, shift := range shifts {
+		cashSales = cashSales.Add(shift.CashSales)
+		cardSales = cardSales.Add(shift.CardSales)
+		variance = variance.Add(shift.Variance)
+		if shift.Status == shiftOpen {
+			open++
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"date":       time.Unix(start, 0).Format("2006-01-02"),
+		"shifts":     shifts,
+		"cashSales":  cashSales,
+		"cardSales":  cardSales,
+		"variance":   variance,
+		"openShifts": open,
+	})
+}
+
+// CreateCounterOrder takes an order at the till for a walk-in customer, or
+// for a registered user when userId is given, paid in cash or by card on
+// the terminal.
+func CreateCounterOrder(c *gin.Context) {
+	var input struct {
+		orderItemsInput
+		PaymentMethod string      `json:"paymentMethod" binding:"required"`
+		CustomerName  string      `json:"customerName"`
+		UserID        string      `json:"userId"`
+		CashTendered  model.Money `json:"cashTendered"`
+		CardReference string      `json:"cardReference"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+	if input.PaymentMethod != "cash" && input.PaymentMethod != "card" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Payment method must be cash or card"})
+		return
+	}
+	lines, ok := input.lines(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	shift, err := openShift(ctx, c.GetString("user_id"))
+	if err == errNoOpenShift {
+		c.JSON(http.StatusConflict, gin.H{"error": "Open a shift before taking orders"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch shift"})
+		return
+	}
+
+	order := model.Order{
+		CustomerName:  input.CustomerName,
+		PaymentMethod: input.PaymentMethod,
+		ShiftID:       shift.ID,
+		CashierID:     c.GetString("user_id"),
+		CashierName:   c.GetString("username"),
+		CashTendered:  model.Paise(input.CashTendered.Amount),
+		CardReference: input.CardReference,
+	}
+	if input.UserID != "" {
+		uid, err := primitive.ObjectIDFromHex(input.UserID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			return
+		}
+		var user model.User
+		err = mongodb.GetCollection("smartcanteen", "users").FindOne(ctx, bson.M{"_id": uid}).Decode(&user)
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user details"})
+			return
+		}
+		order.CustomerID = input.UserID
+		order.CustomerName = user.Username
+		order.CustomerEmail = user.Email
+	}
+	if order.CustomerName == "" {
+		order.CustomerName = "Walk-in"
+	}
+
+	placeOrder(c, ctx, order, lines, nil)
+}
+
+// payAtCounter completes a freshly saved counter order and adds it to the
+// cashier's shift. Cash short of the amount due drops the order again.
+func payAtCounter(c *gin.Context, ctx context.Context, oid primitive.ObjectID, order model.Order) {
+	due := amountDue(order)
+	var change model.Money
+	if order.PaymentMethod == "cash" && !order.CashTendered.IsZero() {
+		if order.CashTendered.Amount < due.Amount {
+			dropOrder(ctx, oid, order)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Cash tendered is less than the amount due", "amountDue": due})
+			return
+		}
+		change = order.CashTendered.Sub(due)
+	}
+
+	// The sale goes on the shift the order was taken on, and only while it
+	// is open, so a shift closed meanwhile doesn't take the order
+	field := "cashSales"
+	if order.PaymentMethod == "card" {
+		field = "cardSales"
+	}
+	if err := addToShift(ctx, order.ShiftID, field, due.Amount); err != nil {
+		slog.Error("failed to add order to shift", "order", oid.Hex(), "shift", order.ShiftID, "error", err)
+		dropOrder(ctx, oid, order)
+		if err == errNoOpenShift {
+			c.JSON(http.StatusConflict, gin.H{"error": "Open a shift before taking orders"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update shift"})
+		return
+	}
+
+	if _, err := completeOrder(ctx, oid, nil); err != nil {
+		if undoErr := addToShift(ctx, order.ShiftID, field, -due.Amount); undoErr != nil {
+			slog.Error("failed to take order off shift", "order", oid.Hex(), "shift", order.ShiftID, "error", undoErr)
+		}
+		dropOrder(ctx, oid, order)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update order status"})
+		return
+	}
+	recordDirectPayment(ctx, oid, order)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":   "Order placed and paid",
+		"orderID":   oid.Hex(),
+		"isPaid":    true,
+		"amountDue": due,
+		"change":    change,
+	})
+}
@@ -0,0 +1,142 @@
+package controllers
+
+import (
+	"backend/internal/middleware"
+	"backend/internal/model"
+	mongodb "backend/internal/mogodb"
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const apiKeyPrefix = "sck_"
+
+var deviceKinds = map[string]bool{"kiosk": true, "pos": true}
+
+// CreateDevice issues an API key for a kiosk or POS terminal. The key is
+// only returned here; afterwards just its prefix is shown.
+func CreateDevice(c *gin.Context) {
+	var input struct {
+		Name   string   `json:"name" binding:"required"`
+		Kind   string   `json:"kind" binding:"required"`
+		Scopes []string `json:"scopes" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+	if !deviceKinds[input.Kind] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Device kind must be kiosk or pos"})
+		return
+	}
+	if len(input.Scopes) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "At least one scope is required"})
+		return
+	}
+	for _, scope := range input.Scopes {
+		if !middlewares.IsDeviceScope(scope) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Scope not allowed for devices: " + scope})
+			return
+		}
+	}
+
+	secret, err := randomToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate key"})
+		return
+	}
+	key := apiKeyPrefix + secret
+
+	apiKeyColl := mongodb.GetCollection("smartcanteen", "api_keys")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	device := model.APIKey{
+		Name:      strings.TrimSpace(input.Name),
+		Kind:      input.Kind,
+		Scopes:    input.Scopes,
+		KeyHash:   hashToken(key),
+		Prefix:    key[:len(apiKeyPrefix)+8],
+		CreatedBy: c.GetString("user_id"),
+		CreatedAt: time.Now().Unix(),
+	}
+	res, err := apiKeyColl.InsertOne(ctx, device)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create device"})
+		return
+	}
+	device.ID = res.InsertedID.(primitive.ObjectID).Hex()
+
+	writeAudit(ctx, c, "device.created", "device", device.ID, map[string]interface{}{
+		"name":   device.Name,
+		"kind":   device.Kind,
+		"scopes": device.Scopes,
+	})
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Device created, store the key now as it won't be shown again",
+		"device":  device,
+		"key":     key,
+	})
+}
+
+func ListDevices(c *gin.Context) {
+	apiKeyColl := mongodb.GetCollection("smartcanteen", "api_keys")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cursor, err := apiKeyColl.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}}))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch devices"})
+		return
+	}
+	defer cursor.Close(ctx)
+
+	devices := []model.APIKey{}
+	if err := cursor.All(ctx, &devices); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse devices"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"devices": devices})
+}
+
+// RevokeDevice stops a key from working on its next request.
+func RevokeDevice(c *gin.Context) {
+	oid, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid device ID"})
+		return
+	}
+
+	apiKeyColl := mongodb.GetCollection("smartcanteen", "api_keys")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := apiKeyColl.UpdateOne(
+		ctx,
+		bson.M{"_id": oid, "revoked": false},
+		bson.M{"$set": bson.M{"revoked": true, "revokedAt": time.Now().Unix()}},
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke device"})
+		return
+	}
+	if res.MatchedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Device not found or already revoked"})
+		return
+	}
+
+	writeAudit(ctx, c, "device.revoked", "device", oid.Hex(), nil)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Device key revoked"})
+}
@@ -0,0 +1,155 @@
+package controllers
+
+import (
+	"backend/internal/model"
+	mongodb "backend/internal/mogodb"
+	"context"
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// holdTTL is how long an unpaid order keeps what it holds, from
+// PENDING_ORDER_TTL_MINUTES.
+func holdTTL() time.Duration {
+	minutes, err := strconv.Atoi(os.Getenv("PENDING_ORDER_TTL_MINUTES"))
+	if err != nil || minutes <= 0 {
+		minutes = 60
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+// holdsAnything matches unpaid orders that hold something to give back.
+func holdsAnything() bson.M {
+	return bson.M{"$or": bson.A{
+		bson.M{"pointsRedeemed": bson.M{"$gt": 0}},
+		bson.M{"mealsCovered": bson.M{"$gt": 0}},
+		bson.M{"discounts.0": bson.M{"$exists": true}},
+		bson.M{"menuDate": bson.M{"$exists": true}},
+	}}
+}
+
+// holdOrder takes what a newly saved order was priced with: the loyalty
+// points put towards it, the meals its plan covers, its uses of promotions
+// and its portions of the daily menu. It takes all of it or, giving back
+// what it already took, none. If the order is never paid, what it holds is
+// given back when it is dropped or expires.
+func holdOrder(ctx context.Context, order model.Order) error {
+	if order.PointsRedeemed > 0 {
+		if err := redeemPoints(ctx, order); err != nil {
+			return err
+		}
+	}
+	if order.MealsCovered > 0 {
+		if err := takeMeals(ctx, order); err != nil {
+			restorePoints(ctx, order)
+			return err
+		}
+	}
+	if err := takePromotions(ctx, order); err != nil {
+		restorePoints(ctx, order)
+		releaseMeals(ctx, order)
+		return err
+	}
+	if err := takeMenuPortions(ctx, order); err != nil {
+		restorePoints(ctx, order)
+		releaseMeals(ctx, order)
+		releasePromotions(ctx, order)
+		return err
+	}
+	return nil
+}
+
+// releaseHolds gives back what an unpaid order held.
+func releaseHolds(ctx context.Context, order model.Order) {
+	restorePoints(ctx, order)
+	releaseMeals(ctx, order)
+	releasePromotions(ctx, order)
+	releaseMenuPortions(ctx, order)
+}
+
+// dropOrder deletes an order that couldn't be paid as it was placed and
+// gives back what it held.
+func dropOrder(ctx context.Context, oid primitive.ObjectID, order model.Order) {
+	orderColl := mongodb.GetCollection("smartcanteen", "orders")
+
+	if _, err := orderColl.DeleteOne(ctx, bson.M{"_id": oid}); err != nil {
+		slog.Error("failed to drop order", "order", oid.Hex(), "error", err)
+		return
+	}
+	releaseHolds(ctx, order)
+}
+
+// expireHeldOrders expires unpaid orders that have held something for too
+// long and gives it back. Split orders expire with their shares instead.
+func expireHeldOrders(ctx context.Context) {
+	orderColl := mongodb.GetCollection("smartcanteen", "orders")
+
+	filter := holdsAnything()
+	filter["isPaid"] = false
+	filter["status"] = "Pending"
+	filter["splitMode"] = bson.M{"$exists": false}
+	filter["createdAt"] = bson.M{"$lte": time.Now().Add(-holdTTL()).Unix()}
+
+	for {
+		// Each order is claimed atomically, so it is only released once and
+		// completeOrder won't pay it afterwards
+		var order model.Order
+		err := orderColl.FindOneAndUpdate(ctx, filter, bson.M{"$set": bson.M{
+			"status":    "Expired",
+			"updatedAt": time.Now().Unix(),
+		}}).Decode(&order)
+		if err != nil {
+			if err != mongo.ErrNoDocuments {
+				slog.Error("failed to expire unpaid orders", "error", err)
+			}
+			return
+		}
+		releaseHolds(ctx, order)
+	}
+}
+
+// refundLatePayment gives back a gateway payment that arrived after its
+// order expired.
+func refundLatePayment(ctx context.Context, order model.Order, attempt gatewayAttempt) error {
+	paymentColl := mongodb.GetCollection("smartcanteen", "payments")
+
+	refund, err := razorpayClient().Payment.Refund(attempt.ProviderPaymentID, int(attempt.Amount.Amount), map[string]interface{}{
+		"receipt": order.ID,
+	}, nil)
+	if err != nil {
+		return err
+	}
+	refundID, _ := refund["id"].(string)
+
+	now := time.Now().Unix()
+	_, err = paymentColl.UpdateOne(ctx, bson.M{
+		"orderId":           order.ID,
+		"providerPaymentId": attempt.ProviderPaymentID,
+		"status":            paymentCaptured,
+	}, bson.M{"$set": bson.M{
+		"status":     paymentRefunded,
+		"refundId":   refundID,
+		"refundedAt": now,
+		"updatedAt":  now,
+	}})
+	return err
+}
+
+// ExpireHeldOrders runs expireHeldOrders every interval. It never returns,
+// so start it in its own goroutine.
+func ExpireHeldOrders(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		expireHeldOrders(ctx)
+		cancel()
+	}
+}
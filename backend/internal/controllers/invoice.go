@@ -0,0 +1,97 @@
+package controllers
+
+import (
+	"backend/internal/model"
+	mongodb "backend/internal/mogodb"
+	"backend/internal/tax"
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// orderItemFor prices quantity units of product, less discount, snapshotting
+// its tax class so later changes to the product don't alter the order. Tax is
+// charged on the discounted amount.
+func orderItemFor(product model.Product, quantity int, discount model.Money) model.OrderItem {
+	gross := product.Price.Mul(quantity).Sub(discount)
+	line := tax.Compute(gross, product.Tax.Rate, product.Tax.Inclusive)
+
+	return model.OrderItem{
+		ProductID:    product.ID,
+		Name:         product.Name,
+		Price:        product.Price,
+		Quantity:     quantity,
+		Total:        line.Total,
+		HSN:          product.Tax.HSN,
+		TaxRate:      product.Tax.Rate,
+		TaxInclusive: product.Tax.Inclusive,
+		Taxable:      line.Taxable,
+		CGST:         line.CGST,
+		SGST:         line.SGST,
+		Discount:     discount,
+		LoyaltyBonus: product.LoyaltyBonus,
+	}
+}
+
+// sumOrderTotals adds up the order's lines into its totals.
+func sumOrderTotals(order *model.Order) {
+	order.Total = model.Paise(0)
+	order.Taxable = model.Paise(0)
+	order.CGST = model.Paise(0)
+	order.SGST = model.Paise(0)
+	order.DiscountTotal = model.Paise(0)
+
+	for _, item := range order.Items {
+		order.Total = order.Total.Add(item.Total)
+		order.Taxable = order.Taxable.Add(item.Taxable)
+		order.CGST = order.CGST.Add(item.CGST)
+		order.SGST = order.SGST.Add(item.SGST)
+		order.DiscountTotal = order.DiscountTotal.Add(item.Discount)
+	}
+	order.TaxTotal = order.CGST.Add(order.SGST)
+}
+
+// invoicePrefix starts every invoice number; INVOICE_PREFIX overrides it.
+func invoicePrefix() string {
+	if prefix := os.Getenv("INVOICE_PREFIX"); prefix != "" {
+		return prefix
+	}
+	return "SC"
+}
+
+// financialYear names the Indian financial year (April to March) t falls in,
+// e.g. "2026-27".
+func financialYear(t time.Time) string {
+	year := t.Year()
+	if t.Month() < time.April {
+		year--
+	}
+	return fmt.Sprintf("%d-%02d", year, (year+1)%100)
+}
+
+// nextInvoiceNumber hands out the next number in the current financial
+// year's series, e.g. "SC/2026-27/000042". GST requires the series to be
+// consecutive, so numbers come from an atomic counter.
+func nextInvoiceNumber(ctx context.Context, t time.Time) (string, error) {
+	counterColl := mongodb.GetCollection("smartcanteen", "counters")
+	fy := financialYear(t)
+
+	var counter struct {
+		Seq int64 `bson:"seq"`
+	}
+	err := counterColl.FindOneAndUpdate(
+		ctx,
+		bson.M{"_id": "invoice:" + fy},
+		bson.M{"$inc": bson.M{"seq": 1}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&counter)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s/%s/%06d", invoicePrefix(), fy, counter.Seq), nil
+}
@@ -0,0 +1,14 @@
+package controllers
+
+import (
+	"backend/internal/auth"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// JWKS publishes the public signing keys so other services can verify
+// session tokens without sharing a secret.
+func JWKS(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"keys": auth.Keys.JWKS()})
+}
@@ -0,0 +1,159 @@
+package controllers
+
+import (
+	"backend/internal/model"
+	mongodb "backend/internal/mogodb"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Ledger accounts other than the per-user wallets. Money held for users sits
+// in their wallet accounts; the counter accounts below record where it came
+// from and went.
+const (
+	accountCash     = "cash"
+	accountRazorpay = "gateway:razorpay"
+	accountSales    = "sales"
+)
+
+var errInsufficientFunds = errors.New("insufficient wallet balance")
+
+// errInvalidAmount is a wallet movement of zero or less, which would run the
+// other way round.
+var errInvalidAmount = errors.New("wallet amount must be positive")
+
+func walletAccount(userID string) string {
+	return "wallet:" + userID
+}
+
+// postJournal records a balanced set of postings as one journal entry.
+func postJournal(ctx context.Context, kind, reference string, postings map[string]int64) error {
+	var sum int64
+	for _, amount := range postings {
+		sum += amount
+	}
+	if sum != 0 {
+		return fmt.Errorf("unbalanced journal %s/%s: postings sum to %d", kind, reference, sum)
+	}
+
+	ledgerColl := mongodb.GetCollection("smartcanteen", "ledger_entries")
+
+	journalID := primitive.NewObjectID().Hex()
+	now := time.Now().Unix()
+
+	var entries []interface{}
+	for account, amount := range postings {
+		entries = append(entries, model.LedgerEntry{
+			JournalID: journalID,
+			Account:   account,
+			Amount:    amount,
+			Kind:      kind,
+			Reference: reference,
+			CreatedAt: now,
+		})
+	}
+
+	_, err := ledgerColl.InsertMany(ctx, entries)
+	return err
+}
+
+// creditWallet adds amount paise to a user's wallet, taken from the counter
+// account.
+func creditWallet(ctx context.Context, userID string, amount int64, counter, kind, reference string) error {
+	if amount <= 0 {
+		return errInvalidAmount
+	}
+	walletColl := mongodb.GetCollection("smartcanteen", "wallets")
+
+	_, err := walletColl.UpdateOne(
+		ctx,
+		bson.M{"userId": userID},
+		bson.M{
+			"$inc": bson.M{"balance": amount},
+			"$set": bson.M{"updatedAt": time.Now().Unix()},
+		},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		return err
+	}
+
+	err = postJournal(ctx, kind, reference, map[string]int64{
+		walletAccount(userID): amount,
+		counter:               -amount,
+	})
+	if err != nil {
+		slog.Error("failed to post wallet credit, reverting", "user", userID, "error", err)
+		walletColl.UpdateOne(ctx, bson.M{"userId": userID}, bson.M{"$inc": bson.M{"balance": -amount}})
+		return err
+	}
+	return nil
+}
+
+// debitWallet moves amount paise out of a user's wallet into the counter
+// account. The balance check and the deduction are a single update, so
+// concurrent orders can never take the wallet below zero.
+func debitWallet(ctx context.Context, userID string, amount int64, counter, kind, reference string) error {
+	if amount <= 0 {
+		return errInvalidAmount
+	}
+	walletColl := mongodb.GetCollection("smartcanteen", "wallets")
+
+	res, err := walletColl.UpdateOne(
+		ctx,
+		bson.M{"userId": userID, "balance": bson.M{"$gte": amount}},
+		bson.M{
+			"$inc": bson.M{"balance": -amount},
+			"$set": bson.M{"updatedAt": time.Now().Unix()},
+		},
+	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return errInsufficientFunds
+	}
+
+	err = postJournal(ctx, kind, reference, map[string]int64{
+		walletAccount(userID): -amount,
+		counter:               amount,
+	})
+	if err != nil {
+		slog.Error("failed to post wallet debit, reverting", "user", userID, "error", err)
+		walletColl.UpdateOne(ctx, bson.M{"userId": userID}, bson.M{"$inc": bson.M{"balance": amount}})
+		return err
+	}
+	return nil
+}
+
+// ledgerBalance sums an account's postings.
+func ledgerBalance(ctx context.Context, account string) (int64, error) {
+	ledgerColl := mongodb.GetCollection("smartcanteen", "ledger_entries")
+
+	cursor, err := ledgerColl.Aggregate(ctx, bson.A{
+		bson.M{"$match": bson.M{"account": account}},
+		bson.M{"$group": bson.M{"_id": nil, "balance": bson.M{"$sum": "$amount"}}},
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var result []struct {
+		Balance int64 `bson:"balance"`
+	}
+	if err := cursor.All(ctx, &result); err != nil {
+		return 0, err
+	}
+	if len(result) == 0 {
+		return 0, nil
+	}
+	return result[0].Balance, nil
+}
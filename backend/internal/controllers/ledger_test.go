@@ -0,0 +1,18 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+)
+
+func TestWalletRefusesNonPositiveAmounts(t *testing.T) {
+	ctx := context.Background()
+	for _, amount := range []int64{0, -1, -1000} {
+		if err := debitWallet(ctx, cartTestUser, amount, accountSales, "order", "o1"); err != errInvalidAmount {
+			t.Errorf("debitWallet(%d) = %v, want errInvalidAmount", amount, err)
+		}
+		if err := creditWallet(ctx, cartTestUser, amount, accountSales, "order_refund", "o1"); err != errInvalidAmount {
+			t.Errorf("creditWallet(%d) = %v, want errInvalidAmount", amount, err)
+		}
+	}
+}
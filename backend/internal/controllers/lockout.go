@@ -0,0 +1,175 @@
+package controllers
+
+import (
+	"backend/internal/model"
+	mongodb "backend/internal/mogodb"
+	"context"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// Failures older than this no longer count
+	loginFailureWindow = time.Hour
+	// From this many failures on, each attempt has to wait a growing delay.
+	// An address is shared by everyone behind the same NAT, so it gets far
+	// more before anyone is slowed down
+	accountDelayAfter = 3
+	ipDelayAfter      = 25
+	loginMaxDelay     = time.Minute
+	// Failures that lock an account or an IP address
+	accountLockThreshold = 10
+	ipLockThreshold      = 50
+	loginLockDuration    = 15 * time.Minute
+)
+
+type loginAttempts struct {
+	Key           string `bson:"key"`
+	Failures      int    `bson:"failures"`
+	LastFailureAt int64  `bson:"lastFailureAt"`
+	LockedUntil   int64  `bson:"lockedUntil"`
+}
+
+func accountAttemptKey(email string) string {
+	return "account:" + normalizeEmail(email)
+}
+
+func ipAttemptKey(ip string) string {
+	return "ip:" + ip
+}
+
+// loginDelay is how long after the last failure the next attempt under key
+// is allowed: 1s, 2s, 4s, ... once enough failures have piled up.
+func loginDelay(key string, failures int) time.Duration {
+	delayAfter := accountDelayAfter
+	if strings.HasPrefix(key, "ip:") {
+		delayAfter = ipDelayAfter
+	}
+	if failures < delayAfter {
+		return 0
+	}
+	delay := time.Second << (failures - delayAfter)
+	if delay <= 0 || delay > loginMaxDelay {
+		return loginMaxDelay
+	}
+	return delay
+}
+
+// loginRetryAfter returns how long the caller must wait before trying again
+// under any of the keys, and whether that is because of a lockout.
+func loginRetryAfter(ctx context.Context, keys ...string) (time.Duration, bool) {
+	attemptColl := mongodb.GetCollection("smartcanteen", "login_attempts")
+
+	now := time.Now()
+	var wait time.Duration
+	locked := false
+
+	cursor, err := attemptColl.Find(ctx, bson.M{"key": bson.M{"$in": keys}})
+	if err != nil {
+		slog.Error("failed to load login attempts", "error", err)
+		return 0, false
+	}
+	defer cursor.Close(ctx)
+
+	var attempts []loginAttempts
+	if err := cursor.All(ctx, &attempts); err != nil {
+		slog.Error("failed to parse login attempts", "error", err)
+		return 0, false
+	}
+
+	for _, a := range attempts {
+		if until := time.Unix(a.LockedUntil, 0); until.After(now) {
+			locked = true
+			if d := until.Sub(now); d > wait {
+				wait = d
+			}
+			continue
+		}
+		last := time.Unix(a.LastFailureAt, 0)
+		if now.Sub(last) > loginFailureWindow {
+			continue
+		}
+		if d := last.Add(loginDelay(a.Key, a.Failures)).Sub(now); d > wait {
+			wait = d
+		}
+	}
+	return wait, locked
+}
+
+// recordLoginFailure counts a failed attempt against key and locks it once
+// threshold is reached. It reports whether this failure caused a lockout.
+func recordLoginFailure(ctx context.Context, key string, threshold int) bool {
+	attemptColl := mongodb.GetCollection("smartcanteen", "login_attempts")
+	now := time.Now()
+
+	// Start counting afresh once the previous failures are stale
+	attemptColl.UpdateOne(
+		ctx,
+		bson.M{"key": key, "lastFailureAt": bson.M{"$lt": now.Add(-loginFailureWindow).Unix()}},
+		bson.M{"$set": bson.M{"failures": 0}},
+	)
+
+	var attempts loginAttempts
+	err := attemptColl.FindOneAndUpdate(
+		ctx,
+		bson.M{"key": key},
+		bson.M{
+			"$inc": bson.M{"failures": 1},
+			"$set": bson.M{"lastFailureAt": now.Unix()},
+		},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&attempts)
+	if err != nil {
+		slog.Error("failed to record login failure", "error", err)
+		return false
+	}
+
+	if attempts.Failures < threshold {
+		return false
+	}
+
+	_, err = attemptColl.UpdateOne(
+		ctx,
+		bson.M{"key": key},
+		bson.M{"$set": bson.M{
+			"failures":    0,
+			"lockedUntil": now.Add(loginLockDuration).Unix(),
+		}},
+	)
+	if err != nil {
+		slog.Error("failed to lock login", "error", err)
+		return false
+	}
+	return true
+}
+
+func clearLoginFailures(ctx context.Context, key string) {
+	attemptColl := mongodb.GetCollection("smartcanteen", "login_attempts")
+	if _, err := attemptColl.DeleteOne(ctx, bson.M{"key": key}); err != nil {
+		slog.Error("failed to clear login failures", "error", err)
+	}
+}
+
+// writeAuthEvent appends to the auth audit log: logins, failures, lockouts
+// and unlocks.
+func writeAuthEvent(ctx context.Context, c *gin.Context, eventType, userID, email, reason string) {
+	eventColl := mongodb.GetCollection("smartcanteen", "auth_events")
+
+	_, err := eventColl.InsertOne(ctx, model.AuthEvent{
+		Type:      eventType,
+		UserID:    userID,
+		Email:     email,
+		Reason:    reason,
+		IP:        c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+		CreatedAt: time.Now().Unix(),
+	})
+	if err != nil {
+		slog.Error("failed to write auth event", "type", eventType, "error", err)
+	}
+}
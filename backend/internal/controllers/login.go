@@ -2,11 +2,12 @@ package controllers
 
 import (
 	"context"
+	"fmt"
+	"math"
 	"net/http"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
 	"go.mongodb.org/mongo-driver/bson"
 	"golang.org/x/crypto/bcrypt"
 
@@ -14,8 +15,6 @@ import (
 	mongodb "backend/internal/mogodb"
 )
 
-var jwtSecret = []byte("supersecretkey")
-
 // Login handler
 func Login(c *gin.Context) {
 	var input model.LoginInput
@@ -24,45 +23,115 @@ func Login(c *gin.Context) {
 		return
 	}
 
+	input.Email = normalizeEmail(input.Email)
+
 	userCollection := mongodb.GetCollection("smartcanteen", "users")
 
-	// Find user by username
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
+	accountKey := accountAttemptKey(input.Email)
+	ipKey := ipAttemptKey(c.ClientIP())
+
+	if wait, locked := loginRetryAfter(ctx, accountKey, ipKey); wait > 0 {
+		reason := "delayed"
+		message := "Too many failed attempts, please wait before trying again"
+		if locked {
+			reason = "locked"
+			message = "Too many failed attempts, login is temporarily locked"
+		}
+		writeAuthEvent(ctx, c, "login_blocked", "", input.Email, reason)
+		c.Header("Retry-After", fmt.Sprint(int(math.Ceil(wait.Seconds()))))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": message})
+		return
+	}
+
+	// Find user by email
 	var user model.User
 	err := userCollection.FindOne(ctx, bson.M{"email": input.Email}).Decode(&user)
+	if err == nil {
+		// Compare hashed password
+		err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(input.Password))
+	}
 	if err != nil {
+		// Unknown emails count too, so lockouts don't reveal which accounts exist
+		userID := ""
+		if !user.ID.IsZero() {
+			userID = user.ID.Hex()
+		}
+		writeAuthEvent(ctx, c, "login_failure", userID, input.Email, "invalid_credentials")
+		if recordLoginFailure(ctx, accountKey, accountLockThreshold) {
+			writeAuthEvent(ctx, c, "lockout", userID, input.Email, "account")
+		}
+		if recordLoginFailure(ctx, ipKey, ipLockThreshold) {
+			writeAuthEvent(ctx, c, "lockout", "", input.Email, "ip")
+		}
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid username or password"})
 		return
 	}
 
-	// Compare hashed password
-	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(input.Password))
-	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid username or password"})
+	if user.Disabled {
+		writeAuthEvent(ctx, c, "login_failure", user.ID.Hex(), input.Email, "account_disabled")
+		c.JSON(http.StatusForbidden, gin.H{"error": "Account disabled"})
+		return
+	}
+	if user.PasswordResetRequired {
+		writeAuthEvent(ctx, c, "login_failure", user.ID.Hex(), input.Email, "password_reset_required")
+		c.JSON(http.StatusForbidden, gin.H{"error": "Password reset required, please check your email"})
 		return
 	}
 
-	// Create JWT token
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"username": user.Username,
-		"role":     user.Role,
-		"user_id":  user.ID.Hex(),
-		"exp":      time.Now().Add(24 * time.Hour).Unix(), // 1 day expiry
-	})
+	// Only the account is cleared: the address may be shared with whoever
+	// is guessing passwords for other accounts
+	clearLoginFailures(ctx, accountKey)
 
-	tokenString, err := token.SignedString(jwtSecret)
+	// Accounts with 2FA, and every admin, finish logging in at /login/mfa.
+	// An admin without 2FA can only log in to enrol, with a code another
+	// admin issued
+	if user.Role == "admin" && !user.TOTPEnabled {
+		if !validEnrolmentCode(user, input.EnrolmentCode) {
+			writeAuthEvent(ctx, c, "login_failure", user.ID.Hex(), input.Email, "mfa_enrolment_required")
+			c.JSON(http.StatusForbidden, gin.H{
+				"error":              "Two-factor authentication is required for admins; ask another admin for an enrolment code",
+				"enrolment_required": true,
+			})
+			return
+		}
+		startMFAChallenge(c, ctx, user, hashToken(input.EnrolmentCode))
+		return
+	}
+	if user.TOTPEnabled {
+		startMFAChallenge(c, ctx, user, "")
+		return
+	}
+
+	completeLogin(c, ctx, user, nil)
+}
+
+// completeLogin starts the session and answers with the tokens. extra adds
+// fields to the response.
+func completeLogin(c *gin.Context, ctx context.Context, user model.User, extra gin.H) {
+	// Create JWT access token backed by a server-side session
+	tokenString, refreshToken, err := startSession(ctx, c, user)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"message": "Login successful",
-		"token":   tokenString,
-		"role":    user.Role,
-		"username":user.Username,
-		"user_id": user.ID.Hex(),
-	})
+	writeAuthEvent(ctx, c, "login_success", user.ID.Hex(), user.Email, "")
+
+	response := gin.H{
+		"message":       "Login successful",
+		"token":         tokenString,
+		"refresh_token": refreshToken,
+		"expires_in":    int(accessTokenTTL.Seconds()),
+		"role":          user.Role,
+		"username":      user.Username,
+		"user_id":       user.ID.Hex(),
+	}
+	for k, v := range extra {
+		response[k] = v
+	}
+
+	c.JSON(http.StatusOK, response)
 }
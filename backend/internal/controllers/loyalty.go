@@ -0,0 +1,352 @@
+package controllers
+
+import (
+	"backend/internal/model"
+	mongodb "backend/internal/mogodb"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Kinds of points movement
+const (
+	pointsEarn    = "earn"
+	pointsRedeem  = "redeem"
+	pointsExpire  = "expire"
+	pointsReverse = "reverse"
+	pointsRestore = "restore"
+)
+
+// pointsPerRupee is how many points each rupee paid earns, from
+// LOYALTY_POINTS_PER_RUPEE. It defaults to one point per ten rupees.
+func pointsPerRupee() float64 {
+	rate, err := strconv.ParseFloat(os.Getenv("LOYALTY_POINTS_PER_RUPEE"), 64)
+	if err != nil || rate < 0 {
+		return 0.1
+	}
+	return rate
+}
+
+// pointValue is what one point is worth at checkout, in paise, from
+// LOYALTY_POINT_VALUE.
+func pointValue() int64 {
+	value, err := strconv.ParseInt(os.Getenv("LOYALTY_POINT_VALUE"), 10, 64)
+	if err != nil || value <= 0 {
+		return 25
+	}
+	return value
+}
+
+// pointsLifetime is how long earned points last, from
+// LOYALTY_POINTS_EXPIRY_DAYS.
+func pointsLifetime() time.Duration {
+	days, err := strconv.Atoi(os.Getenv("LOYALTY_POINTS_EXPIRY_DAYS"))
+	if err != nil || days <= 0 {
+		days = 365
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// pointsFor is what paying for an order earns: the configured rate on the
+// money paid, which excludes any points put towards it, plus each product's
+// bonus.
+func pointsFor(order model.Order) int64 {
+	paid := amountDue(order).Amount
+	points := int64(float64(paid) / 100 * pointsPerRupee())
+	for _, item := range order.Items {
+		points += item.LoyaltyBonus * int64(item.Quantity)
+	}
+	return points
+}
+
+// amountDue is what is left to pay on an order once points are taken off.
+func amountDue(order model.Order) model.Money {
+	return order.Total.Sub(order.PointsValue)
+}
+
+func writePointEntry(ctx context.Context, userID, kind string, points int64, orderID string) {
+	entryColl := mongodb.GetCollection("smartcanteen", "loyalty_entries")
+
+	_, err := entryColl.InsertOne(ctx, model.PointEntry{
+		UserID:    userID,
+		Kind:      kind,
+		Points:    points,
+		OrderID:   orderID,
+		CreatedAt: time.Now().Unix(),
+	})
+	if err != nil {
+		slog.Error("failed to record points entry", "user", userID, "kind", kind, "error", err)
+	}
+}
+
+// addPoints gives a user a new lot of points.
+func addPoints(ctx context.Context, userID string, points int64, kind, orderID string) error {
+	lotColl := mongodb.GetCollection("smartcanteen", "loyalty_lots")
+
+	now := time.Now()
+	_, err := lotColl.InsertOne(ctx, model.PointLot{
+		UserID:    userID,
+		OrderID:   orderID,
+		Points:    points,
+		Remaining: points,
+		EarnedAt:  now.Unix(),
+		ExpiresAt: now.Add(pointsLifetime()).Unix(),
+	})
+	if err != nil {
+		return err
+	}
+	writePointEntry(ctx, userID, kind, points, orderID)
+	return nil
+}
+
+// expirePoints writes off what is left in a user's expired lots.
+func expirePoints(ctx context.Context, userID string) {
+	lotColl := mongodb.GetCollection("smartcanteen", "loyalty_lots")
+
+	for {
+		// Each lot is emptied atomically, so concurrent requests can't
+		// expire the same points twice
+		var lot model.PointLot
+		err := lotColl.FindOneAndUpdate(
+			ctx,
+			bson.M{"userId": userID, "remaining": bson.M{"$gt": 0}, "expiresAt": bson.M{"$lte": time.Now().Unix()}},
+			bson.M{"$set": bson.M{"remaining": 0}},
+		).Decode(&lot)
+		if err != nil {
+			return
+		}
+		writePointEntry(ctx, userID, pointsExpire, -lot.Remaining, lot.OrderID)
+	}
+}
+
+// pointsBalance is the user's unexpired points.
+func pointsBalance(ctx context.Context, userID string) (int64, error) {
+	lotColl := mongodb.GetCollection("smartcanteen", "loyalty_lots")
+
+	expirePoints(ctx, userID)
+
+	cursor, err := lotColl.Aggregate(ctx, bson.A{
+		bson.M{"$match": bson.M{"userId": userID, "remaining": bson.M{"$gt": 0}}},
+		bson.M{"$group": bson.M{"_id": nil, "points": bson.M{"$sum": "$remaining"}}},
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var result []struct {
+		Points int64 `bson:"points"`
+	}
+	if err := cursor.All(ctx, &result); err != nil {
+		return 0, err
+	}
+	if len(result) == 0 {
+		return 0, nil
+	}
+	return result[0].Points, nil
+}
+
+// takePoints removes up to points from a user's unexpired lots, the ones
+// expiring soonest first, or the lot earned by firstOrderID before any
+// other. It returns how many it could take.
+func takePoints(ctx context.Context, userID string, points int64, firstOrderID string) (int64, error) {
+	lotColl := mongodb.GetCollection("smartcanteen", "loyalty_lots")
+
+	expirePoints(ctx, userID)
+
+	opts := options.Find().SetSort(bson.D{{Key: "expiresAt", Value: 1}})
+	cursor, err := lotColl.Find(ctx, bson.M{"userId": userID, "remaining": bson.M{"$gt": 0}}, opts)
+	if err != nil {
+		return 0, err
+	}
+	var lots []model.PointLot
+	if err := cursor.All(ctx, &lots); err != nil {
+		return 0, err
+	}
+	if firstOrderID != "" {
+		for i, lot := range lots {
+			if lot.OrderID == firstOrderID {
+				lots = append([]model.PointLot{lot}, append(lots[:i:i], lots[i+1:]...)...)
+				break
+			}
+		}
+	}
+
+	var taken int64
+	for _, lot := range lots {
+		if taken == points {
+			break
+		}
+		take := lot.Remaining
+		if take > points-taken {
+			take = points - taken
+		}
+
+		lid, err := primitive.ObjectIDFromHex(lot.ID)
+		if err != nil {
+			continue
+		}
+		// Only take what is still there if another request got to the
+		// lot first
+		res, err := lotColl.UpdateOne(
+			ctx,
+			bson.M{"_id": lid, "remaining": bson.M{"$gte": take}},
+			bson.M{"$inc": bson.M{"remaining": -take}},
+		)
+		if err != nil {
+			return taken, err
+		}
+		if res.ModifiedCount == 1 {
+			taken += take
+		}
+	}
+	return taken, nil
+}
+
+// errNotEnoughPoints means the points put towards an order are no longer
+// there, e.g. because another order spent them first.
+var errNotEnoughPoints = errors.New("not enough loyalty points")
+
+// redeemPoints takes the points put towards a newly saved order, so pending
+// orders can't spend the same balance twice. It takes all of them or none.
+func redeemPoints(ctx context.Context, order model.Order) error {
+	taken, err := takePoints(ctx, order.CustomerID, order.PointsRedeemed, "")
+	if err == nil && taken == order.PointsRedeemed {
+		writePointEntry(ctx, order.CustomerID, pointsRedeem, -taken, order.ID)
+		return nil
+	}
+	if taken > 0 {
+		if err := addPoints(ctx, order.CustomerID, taken, pointsRestore, order.ID); err != nil {
+			slog.Error("failed to give back points", "order", order.ID, "error", err)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return errNotEnoughPoints
+}
+
+// restorePoints gives back the points put towards an order that was
+// dropped or expired before being paid.
+func restorePoints(ctx context.Context, order model.Order) {
+	if order.PointsRedeemed == 0 {
+		return
+	}
+	if err := addPoints(ctx, order.CustomerID, order.PointsRedeemed, pointsRestore, order.ID); err != nil {
+		slog.Error("failed to restore redeemed points", "order", order.ID, "error", err)
+	}
+}
+
+// settleLoyalty awards the points a newly paid order earns. The points put
+// towards it were already taken when it was placed.
+func settleLoyalty(ctx context.Context, order model.Order) {
+	if order.CustomerID == "" {
+		return
+	}
+	orderColl := mongodb.GetCollection("smartcanteen", "orders")
+
+	earned := pointsFor(order)
+	if earned <= 0 {
+		return
+	}
+	if err := addPoints(ctx, order.CustomerID, earned, pointsEarn, order.ID); err != nil {
+		slog.Error("failed to award points", "order", order.ID, "error", err)
+		return
+	}
+
+	oid, err := primitive.ObjectIDFromHex(order.ID)
+	if err == nil {
+		_, err = orderColl.UpdateByID(ctx, oid, bson.M{"$set": bson.M{"pointsEarned": earned}})
+	}
+	if err != nil {
+		slog.Error("failed to record points earned", "order", order.ID, "error", err)
+	}
+}
+
+// reverseLoyalty undoes settleLoyalty for a refunded order: the points it
+// earned are taken back, as far as they haven't been spent, and the points
+// put towards it are given back.
+func reverseLoyalty(ctx context.Context, order model.Order) {
+	if order.CustomerID == "" {
+		return
+	}
+
+	if order.PointsEarned > 0 {
+		taken, err := takePoints(ctx, order.CustomerID, order.PointsEarned, order.ID)
+		if err != nil {
+			slog.Error("failed to reverse points earned", "order", order.ID, "error", err)
+		}
+		if taken > 0 {
+			writePointEntry(ctx, order.CustomerID, pointsReverse, -taken, order.ID)
+		}
+	}
+
+	if order.PointsRedeemed > 0 {
+		if err := addPoints(ctx, order.CustomerID, order.PointsRedeemed, pointsRestore, order.ID); err != nil {
+			slog.Error("failed to restore redeemed points", "order", order.ID, "error", err)
+		}
+	}
+}
+
+// GetLoyalty shows the caller's points balance, the lots it is made of and
+// their points history.
+func GetLoyalty(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	lotColl := mongodb.GetCollection("smartcanteen", "loyalty_lots")
+	entryColl := mongodb.GetCollection("smartcanteen", "loyalty_entries")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	balance, err := pointsBalance(ctx, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch points"})
+		return
+	}
+
+	cursor, err := lotColl.Find(ctx,
+		bson.M{"userId": userID, "remaining": bson.M{"$gt": 0}},
+		options.Find().SetSort(bson.D{{Key: "expiresAt", Value: 1}}),
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch points"})
+		return
+	}
+	lots := []model.PointLot{}
+	if err := cursor.All(ctx, &lots); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch points"})
+		return
+	}
+
+	cursor, err = entryColl.Find(ctx,
+		bson.M{"userId": userID},
+		options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}}).SetLimit(50),
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch points history"})
+		return
+	}
+	history := []model.PointEntry{}
+	if err := cursor.All(ctx, &history); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch points history"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"balance":    balance,
+		"value":      model.Paise(balance * pointValue()),
+		"pointValue": model.Paise(pointValue()),
+		"lots":       lots,
+		"history":    history,
+	})
+}
@@ -0,0 +1,53 @@
+package controllers
+
+import (
+	"testing"
+
+	"backend/internal/model"
+)
+
+func TestPointsFor(t *testing.T) {
+	t.Setenv("LOYALTY_POINTS_PER_RUPEE", "0.1")
+
+	plain := model.Product{ID: "plain", Price: model.Paise(10000)}
+	bonus := model.Product{ID: "bonus", Price: model.Paise(10000), LoyaltyBonus: 5}
+
+	tests := []struct {
+		name   string
+		items  []model.OrderItem
+		points int64
+		want   int64
+	}{
+		{
+			name:  "rate on the amount paid",
+			items: []model.OrderItem{orderItemFor(plain, 2, model.Paise(0))},
+			want:  20,
+		},
+		{
+			name:  "bonus per unit of a bonus product",
+			items: []model.OrderItem{orderItemFor(bonus, 2, model.Paise(0))},
+			want:  30,
+		},
+		{
+			name:  "bonus only on the bonus product",
+			items: []model.OrderItem{orderItemFor(plain, 1, model.Paise(0)), orderItemFor(bonus, 3, model.Paise(0))},
+			want:  55,
+		},
+		{
+			name:   "points put towards the order earn nothing",
+			items:  []model.OrderItem{orderItemFor(bonus, 1, model.Paise(0))},
+			points: 5000,
+			want:   10,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			order := model.Order{Items: tt.items, PointsValue: model.Paise(tt.points)}
+			sumOrderTotals(&order)
+			if got := pointsFor(order); got != tt.want {
+				t.Fatalf("pointsFor = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,753 @@
+package controllers
+
+import (
+	"backend/internal/model"
+	mongodb "backend/internal/mogodb"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Meal subscription statuses. A subscription is active from payment until it
+// ends; rolled_over ones handed their unused meals to the next subscription.
+const (
+	subscriptionCreated    = "created"
+	subscriptionActive     = "active"
+	subscriptionExpired    = "expired"
+	subscriptionRolledOver = "rolled_over"
+)
+
+// What happens to a subscription's unused meals when it ends
+const (
+	unusedMealsExpire   = "expire"
+	unusedMealsRollover = "rollover"
+)
+
+// errNoMealsLeft means no subscription of the customer's covers anything in
+// the order today.
+var errNoMealsLeft = errors.New("no meal plan covers these items today")
+
+// defaultUnusedMeals is the policy for plans that don't set one, from
+// MEAL_PLAN_UNUSED_MEALS.
+func defaultUnusedMeals() string {
+	if os.Getenv("MEAL_PLAN_UNUSED_MEALS") == unusedMealsRollover {
+		return unusedMealsRollover
+	}
+	return unusedMealsExpire
+}
+
+// rolloverWindow is how long after a subscription ends its unused meals can
+// still roll over into a renewal, from MEAL_PLAN_ROLLOVER_DAYS.
+func rolloverWindow() time.Duration {
+	days, err := strconv.Atoi(os.Getenv("MEAL_PLAN_ROLLOVER_DAYS"))
+	if err != nil || days < 0 {
+		days = 7
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// mealDay names the local day meals are counted against.
+func mealDay(t time.Time) string {
+	return t.Format("2006-01-02")
+}
+
+func validateMealPlan(plan model.MealPlan) error {
+	if plan.Price.Amount < minShareAmount {
+		return errors.New("price must be at least ₹1")
+	}
+	if plan.Meals <= 0 || plan.MealsPerDay <= 0 || plan.DurationDays <= 0 {
+		return errors.New("meals, mealsPerDay and durationDays must be positive")
+	}
+	if plan.MealsPerDay > plan.Meals {
+		return errors.New("mealsPerDay cannot be more than meals")
+	}
+	if len(plan.ProductIDs) == 0 {
+		return errors.New("a plan needs at least one eligible product")
+	}
+	for _, id := range plan.ProductIDs {
+		if _, err := primitive.ObjectIDFromHex(id); err != nil {
+			return errors.New("invalid product ID " + id)
+		}
+	}
+	if plan.UnusedMeals != unusedMealsExpire && plan.UnusedMeals != unusedMealsRollover {
+		return errors.New("unusedMeals must be expire or rollover")
+	}
+	return nil
+}
+
+// expireMealSubscriptions ends the user's subscriptions that have run out of
+// time, recording the meals left unused. An empty userID expires everyone's.
+func expireMealSubscriptions(ctx context.Context, userID string) {
+	subColl := mongodb.GetCollection("smartcanteen", "meal_subscriptions")
+
+	filter := bson.M{"status": subscriptionActive, "endsAt": bson.M{"$lte": time.Now().Unix()}}
+	if userID != "" {
+		filter["userId"] = userID
+	}
+	for {
+		// Each subscription is claimed atomically, and meals can only be
+		// taken from active ones, so what is left can't change afterwards
+		var sub model.MealSubscription
+		err := subColl.FindOneAndUpdate(ctx, filter, bson.M{"$set": bson.M{"status": subscriptionExpired}}).Decode(&sub)
+		if err != nil {
+			if err != mongo.ErrNoDocuments {
+				slog.Error("failed to expire meal subscriptions", "error", err)
+			}
+			return
+		}
+		sid, _ := primitive.ObjectIDFromHex(sub.ID)
+		_, err = subColl.UpdateByID(ctx, sid, bson.M{"$set": bson.M{"mealsForfeited": sub.MealsTotal - sub.MealsUsed}})
+		if err != nil {
+			slog.Error("failed to record forfeited meals", "subscription", sub.ID, "error", err)
+		}
+	}
+}
+
+// mealsLeftToday is how many meals the subscription can still cover today.
+func mealsLeftToday(sub model.MealSubscription) int {
+	left := sub.MealsPerDay
+	if sub.UsedDay == mealDay(time.Now()) {
+		left -= sub.UsedToday
+	}
+	if remaining := sub.MealsTotal - sub.MealsUsed; remaining < left {
+		left = remaining
+	}
+	if left < 0 {
+		left = 0
+	}
+	return left
+}
+
+// mealSubscriptionFor picks the user's subscription to cover some of lines:
+// the one ending soonest that has meals left today for any of the products.
+func mealSubscriptionFor(ctx context.Context, userID string, lines []orderLine) (model.MealSubscription, error) {
+	subColl := mongodb.GetCollection("smartcanteen", "meal_subscriptions")
+
+	expireMealSubscriptions(ctx, userID)
+
+	var productIDs []string
+	for _, line := range lines {
+		productIDs = append(productIDs, line.productID.Hex())
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "endsAt", Value: 1}})
+	cursor, err := subColl.Find(ctx, bson.M{
+		"userId":     userID,
+		"status":     subscriptionActive,
+		"productIds": bson.M{"$in": productIDs},
+	}, opts)
+	if err != nil {
+		return model.MealSubscription{}, err
+	}
+	var subs []model.MealSubscription
+	if err := cursor.All(ctx, &subs); err != nil {
+		return model.MealSubscription{}, err
+	}
+
+	for _, sub := range subs {
+		if mealsLeftToday(sub) > 0 {
+			return sub, nil
+		}
+	}
+	return model.MealSubscription{}, errNoMealsLeft
+}
+
+// coverMeals works out how many units of each line the subscription pays for,
+// taking eligible units in cart order up to what is left today.
+func coverMeals(ctx context.Context, subscriptionID string, lines []orderLine) ([]int, error) {
+	subColl := mongodb.GetCollection("smartcanteen", "meal_subscriptions")
+
+	sid, err := primitive.ObjectIDFromHex(subscriptionID)
+	if err != nil {
+		return nil, errNoMealsLeft
+	}
+	var sub model.MealSubscription
+	err = subColl.FindOne(ctx, bson.M{"_id": sid, "status": subscriptionActive}).Decode(&sub)
+	if err == mongo.ErrNoDocuments {
+		return nil, errNoMealsLeft
+	}
+	if err != nil {
+		return nil, err
+	}
+	left := mealsLeftToday(sub)
+
+	eligible := map[string]bool{}
+	for _, id := range sub.ProductIDs {
+		eligible[id] = true
+	}
+
+	covered := make([]int, len(lines))
+	for i, line := range lines {
+		if left == 0 {
+			break
+		}
+		if !eligible[line.productID.Hex()] {
+			continue
+		}
+		covered[i] = line.quantity
+		if covered[i] > left {
+			covered[i] = left
+		}
+		left -= covered[i]
+	}
+	return covered, nil
+}
+
+// takeMeals takes the meals an order covers from its subscription when the
+// order is placed, checking the plan's total and daily limits in the same
+// update so concurrent orders can't both have the last meal.
+func takeMeals(ctx context.Context, order model.Order) error {
+	subColl := mongodb.GetCollection("smartcanteen", "meal_subscriptions")
+
+	n := order.MealsCovered
+	sid, _ := primitive.ObjectIDFromHex(order.MealSubscriptionID)
+	usedToday := bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{"$usedDay", order.MealDay}}, "$usedToday", 0}}
+	res, err := subColl.UpdateOne(ctx, bson.M{
+		"_id":    sid,
+		"status": subscriptionActive,
+		"$expr": bson.M{"$and": bson.A{
+			bson.M{"$lte": bson.A{bson.M{"$add": bson.A{"$mealsUsed", n}}, "$mealsTotal"}},
+			bson.M{"$lte": bson.A{bson.M{"$add": bson.A{usedToday, n}}, "$mealsPerDay"}},
+		}},
+	}, mongo.Pipeline{{{Key: "$set", Value: bson.M{
+		"mealsUsed": bson.M{"$add": bson.A{"$mealsUsed", n}},
+		"usedToday": bson.M{"$add": bson.A{usedToday, n}},
+		"usedDay":   order.MealDay,
+	}}}})
+	if err != nil {
+		return err
+	}
+	if res.ModifiedCount == 0 {
+		return errNoMealsLeft
+	}
+	return nil
+}
+
+// releaseMeals gives an order's meals back to its subscription, including
+// to that day's allowance.
+func releaseMeals(ctx context.Context, order model.Order) {
+	if order.MealsCovered == 0 {
+		return
+	}
+	subColl := mongodb.GetCollection("smartcanteen", "meal_subscriptions")
+
+	n := order.MealsCovered
+	sid, _ := primitive.ObjectIDFromHex(order.MealSubscriptionID)
+	_, err := subColl.UpdateByID(ctx, sid, mongo.Pipeline{{{Key: "$set", Value: bson.M{
+		"mealsUsed": bson.M{"$subtract": bson.A{"$mealsUsed", n}},
+		"usedToday": bson.M{"$cond": bson.A{
+			bson.M{"$eq": bson.A{"$usedDay", order.MealDay}},
+			bson.M{"$subtract": bson.A{"$usedToday", n}},
+			"$usedToday",
+		}},
+	}}}})
+	if err != nil {
+		slog.Error("failed to restore meals", "order", order.ID, "subscription", order.MealSubscriptionID, "error", err)
+	}
+}
+
+// redeemMeals records the meals a newly paid order took from its
+// subscription. They were taken when the order was placed.
+func redeemMeals(ctx context.Context, order model.Order) {
+	if order.MealsCovered == 0 {
+		return
+	}
+	subColl := mongodb.GetCollection("smartcanteen", "meal_subscriptions")
+	redemptionColl := mongodb.GetCollection("smartcanteen", "meal_redemptions")
+
+	sid, _ := primitive.ObjectIDFromHex(order.MealSubscriptionID)
+	var sub model.MealSubscription
+	if err := subColl.FindOne(ctx, bson.M{"_id": sid}).Decode(&sub); err != nil {
+		slog.Error("failed to fetch meal subscription", "order", order.ID, "subscription", order.MealSubscriptionID, "error", err)
+	}
+
+	_, err := redemptionColl.InsertOne(ctx, model.MealRedemption{
+		SubscriptionID: order.MealSubscriptionID,
+		PlanID:         sub.PlanID,
+		UserID:         order.CustomerID,
+		OrderID:        order.ID,
+		Meals:          order.MealsCovered,
+		Value:          order.MealValue,
+		Day:            order.MealDay,
+		CreatedAt:      time.Now().Unix(),
+	})
+	if err != nil {
+		slog.Error("failed to record meal redemption", "order", order.ID, "error", err)
+	}
+}
+
+// reverseMeals gives a refunded order's meals back to its subscription.
+func reverseMeals(ctx context.Context, order model.Order) {
+	if order.MealsCovered == 0 {
+		return
+	}
+	redemptionColl := mongodb.GetCollection("smartcanteen", "meal_redemptions")
+
+	if _, err := redemptionColl.DeleteOne(ctx, bson.M{"orderId": order.ID}); err != nil {
+		slog.Error("failed to reverse meal redemption", "order", order.ID, "error", err)
+	}
+	releaseMeals(ctx, order)
+}
+
+// rollOverMeals moves what is left of the user's previous subscription to the
+// same plan into a newly paid one, if the plan allows it. A previous
+// subscription still running ends now.
+func rollOverMeals(ctx context.Context, sub model.MealSubscription) int {
+	if sub.UnusedMeals != unusedMealsRollover {
+		return 0
+	}
+	subColl := mongodb.GetCollection("smartcanteen", "meal_subscriptions")
+
+	sid, _ := primitive.ObjectIDFromHex(sub.ID)
+	var previous model.MealSubscription
+	err := subColl.FindOneAndUpdate(ctx, bson.M{
+		"userId": sub.UserID,
+		"planId": sub.PlanID,
+		"_id":    bson.M{"$ne": sid},
+		"$or": bson.A{
+			bson.M{"status": subscriptionActive},
+			bson.M{"status": subscriptionExpired, "endsAt": bson.M{"$gte": time.Now().Add(-rolloverWindow()).Unix()}},
+		},
+	}, bson.M{"$set": bson.M{"status": subscriptionRolledOver, "mealsForfeited": 0}},
+		options.FindOneAndUpdate().SetSort(bson.D{{Key: "endsAt", Value: -1}}),
+	).Decode(&previous)
+	if err != nil {
+		if err != mongo.ErrNoDocuments {
+			slog.Error("failed to roll over meals", "subscription", sub.ID, "error", err)
+		}
+		return 0
+	}
+
+	left := previous.MealsTotal - previous.MealsUsed
+	if left <= 0 {
+		return 0
+	}
+	_, err = subColl.UpdateByID(ctx, sid, bson.M{"$inc": bson.M{"mealsTotal": left, "rolledOver": left}})
+	if err != nil {
+		slog.Error("failed to roll over meals", "subscription", sub.ID, "from", previous.ID, "error", err)
+		return 0
+	}
+	return left
+}
+
+// CreateMealPlan adds a plan customers can subscribe to.
+func CreateMealPlan(c *gin.Context) {
+	var input model.MealPlan
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+	if input.MealsPerDay == 0 {
+		input.MealsPerDay = 1
+	}
+	if input.UnusedMeals == "" {
+		input.UnusedMeals = defaultUnusedMeals()
+	}
+	if err := validateMealPlan(input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	input.ID = ""
+	input.Active = true
+	input.CreatedBy = c.GetString("username")
+	input.CreatedAt = time.Now().Unix()
+
+	planColl := mongodb.GetCollection("smartcanteen", "meal_plans")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := planColl.InsertOne(ctx, input)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create meal plan"})
+		return
+	}
+	input.ID = res.InsertedID.(primitive.ObjectID).Hex()
+
+	writeAudit(ctx, c, "meal_plan_create", "meal_plan", input.ID, map[string]interface{}{
+		"name":  input.Name,
+		"price": input.Price,
+		"meals": input.Meals,
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Meal plan created", "plan": input})
+}
+
+// ListMealPlans lists every meal plan, newest first.
+func ListMealPlans(c *gin.Context) {
+	planColl := mongodb.GetCollection("smartcanteen", "meal_plans")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cursor, err := planColl.Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"createdAt": -1}))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch meal plans"})
+		return
+	}
+	plans := []model.MealPlan{}
+	if err := cursor.All(ctx, &plans); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse meal plans"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"plans": plans})
+}
+
+// DeactivateMealPlan stops new subscriptions to a plan. Existing ones run
+// their course.
+func DeactivateMealPlan(c *gin.Context) {
+	pid, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid meal plan ID"})
+		return
+	}
+
+	planColl := mongodb.GetCollection("smartcanteen", "meal_plans")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := planColl.UpdateByID(ctx, pid, bson.M{"$set": bson.M{"active": false}})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to deactivate meal plan"})
+		return
+	}
+	if res.MatchedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Meal plan not found"})
+		return
+	}
+
+	writeAudit(ctx, c, "meal_plan_deactivate", "meal_plan", pid.Hex(), nil)
+	c.JSON(http.StatusOK, gin.H{"message": "Meal plan deactivated"})
+}
+
+// GetMealPlans shows the plans on offer and the caller's subscriptions, with
+// the meals left on each.
+func GetMealPlans(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	planColl := mongodb.GetCollection("smartcanteen", "meal_plans")
+	subColl := mongodb.GetCollection("smartcanteen", "meal_subscriptions")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cursor, err := planColl.Find(ctx, bson.M{"active": true}, options.Find().SetSort(bson.M{"price.amount": 1}))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch meal plans"})
+		return
+	}
+	plans := []model.MealPlan{}
+	if err := cursor.All(ctx, &plans); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse meal plans"})
+		return
+	}
+
+	expireMealSubscriptions(ctx, userID)
+	cursor, err = subColl.Find(ctx,
+		bson.M{"userId": userID, "status": bson.M{"$ne": subscriptionCreated}},
+		options.Find().SetSort(bson.M{"paidAt": -1}).SetLimit(12),
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch subscriptions"})
+		return
+	}
+	var subs []model.MealSubscription
+	if err := cursor.All(ctx, &subs); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse subscriptions"})
+		return
+	}
+
+	subscriptions := []gin.H{}
+	for _, sub := range subs {
+		today := 0
+		if sub.Status == subscriptionActive {
+			today = mealsLeftToday(sub)
+		}
+		subscriptions = append(subscriptions, gin.H{
+			"subscription":   sub,
+			"mealsLeft":      sub.MealsTotal - sub.MealsUsed,
+			"mealsLeftToday": today,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"plans":         plans,
+		"subscriptions": subscriptions,
+	})
+}
+
+// SubscribeMealPlan opens a Razorpay order for a plan. The subscription
+// starts once VerifyMealSubscription confirms the payment.
+func SubscribeMealPlan(c *gin.Context) {
+	pid, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid meal plan ID"})
+		return
+	}
+
+	userID := c.GetString("user_id")
+	planColl := mongodb.GetCollection("smartcanteen", "meal_plans")
+	subColl := mongodb.GetCollection("smartcanteen", "meal_subscriptions")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var plan model.MealPlan
+	err = planColl.FindOne(ctx, bson.M{"_id": pid, "active": true}).Decode(&plan)
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Meal plan not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch meal plan"})
+		return
+	}
+
+	razorOrder, err := razorpayClient().Order.Create(map[string]interface{}{
+		"amount":          plan.Price.Amount,
+		"currency":        "INR",
+		"receipt":         "mealplan-" + userID,
+		"payment_capture": 1,
+	}, nil)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create Razorpay order"})
+		return
+	}
+	razorpayOrderID, _ := razorOrder["id"].(string)
+
+	_, err = subColl.InsertOne(ctx, model.MealSubscription{
+		UserID:          userID,
+		PlanID:          plan.ID,
+		PlanName:        plan.Name,
+		Price:           plan.Price,
+		MealsPerDay:     plan.MealsPerDay,
+		DurationDays:    plan.DurationDays,
+		ProductIDs:      plan.ProductIDs,
+		UnusedMeals:     plan.UnusedMeals,
+		MealsTotal:      plan.Meals,
+		Status:          subscriptionCreated,
+		RazorpayOrderID: razorpayOrderID,
+		CreatedAt:       time.Now().Unix(),
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create subscription"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":         "Subscription created",
+		"razorpayOrderID": razorpayOrderID,
+		"amount":          razorOrder["amount"],
+		"currency":        razorOrder["currency"],
+		"key":             os.Getenv("RAZORPAY_KEY_ID"),
+	})
+}
+
+// VerifyMealSubscription starts a subscription once its payment is
+// confirmed, rolling over meals from the last one where the plan allows.
+func VerifyMealSubscription(c *gin.Context) {
+	var body struct {
+		RazorpayPaymentID string `json:"razorpay_payment_id" binding:"required"`
+		RazorpayOrderID   string `json:"razorpay_order_id" binding:"required"`
+		RazorpaySignature string `json:"razorpay_signature" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	if !validRazorpaySignature(body.RazorpayOrderID, body.RazorpayPaymentID, body.RazorpaySignature) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payment signature"})
+		return
+	}
+
+	userID := c.GetString("user_id")
+	subColl := mongodb.GetCollection("smartcanteen", "meal_subscriptions")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var sub model.MealSubscription
+	err := subColl.FindOne(ctx, bson.M{"razorpayOrderId": body.RazorpayOrderID, "userId": userID, "status": subscriptionCreated}).Decode(&sub)
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Subscription not found or already started"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify subscription"})
+		return
+	}
+
+	// Claiming the subscription first means a payment only ever starts one
+	now := time.Now()
+	sid, _ := primitive.ObjectIDFromHex(sub.ID)
+	res, err := subColl.UpdateOne(ctx, bson.M{"_id": sid, "status": subscriptionCreated}, bson.M{"$set": bson.M{
+		"status":            subscriptionActive,
+		"razorpayPaymentId": body.RazorpayPaymentID,
+		"paidAt":            now.Unix(),
+		"startsAt":          now.Unix(),
+		"endsAt":            now.AddDate(0, 0, sub.DurationDays).Unix(),
+	}})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify subscription"})
+		return
+	}
+	if res.ModifiedCount == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Subscription not found or already started"})
+		return
+	}
+
+	rolledOver := rollOverMeals(ctx, sub)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "Subscription started",
+		"plan":       sub.PlanName,
+		"meals":      sub.MealsTotal + rolledOver,
+		"rolledOver": rolledOver,
+		"endsAt":     now.AddDate(0, 0, sub.DurationDays).Unix(),
+	})
+}
+
+// GetMealPlanReport summarises each plan's sales and usage between from and
+// to (YYYY-MM-DD, both included), by default the month so far. Outstanding
+// meals are those left on subscriptions still running.
+func GetMealPlanReport(c *gin.Context) {
+	now := time.Now()
+	from := c.DefaultQuery("from", time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.Local).Format("2006-01-02"))
+	start, _, err := dayRange(from)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid date, expected YYYY-MM-DD"})
+		return
+	}
+	_, end, err := dayRange(c.Query("to"))
+	if err != nil || end <= start {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid date, expected YYYY-MM-DD"})
+		return
+	}
+
+	planColl := mongodb.GetCollection("smartcanteen", "meal_plans")
+	subColl := mongodb.GetCollection("smartcanteen", "meal_subscriptions")
+	redemptionColl := mongodb.GetCollection("smartcanteen", "meal_redemptions")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	expireMealSubscriptions(ctx, "")
+
+	cursor, err := planColl.Find(ctx, bson.M{})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch meal plans"})
+		return
+	}
+	var plans []model.MealPlan
+	if err := cursor.All(ctx, &plans); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse meal plans"})
+		return
+	}
+
+	period := bson.M{"$gte": start, "$lt": end}
+	cursor, err = subColl.Find(ctx, bson.M{"$or": bson.A{
+		bson.M{"paidAt": period},
+		bson.M{"endsAt": period},
+		bson.M{"status": subscriptionActive},
+	}})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch subscriptions"})
+		return
+	}
+	var subs []model.MealSubscription
+	if err := cursor.All(ctx, &subs); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse subscriptions"})
+		return
+	}
+
+	cursor, err = redemptionColl.Find(ctx, bson.M{"createdAt": period})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch meal redemptions"})
+		return
+	}
+	var redemptions []model.MealRedemption
+	if err := cursor.All(ctx, &redemptions); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse meal redemptions"})
+		return
+	}
+
+	type planUsage struct {
+		PlanID              string      `json:"planId"`
+		Name                string      `json:"name"`
+		Active              bool        `json:"active"`
+		SubscriptionsSold   int         `json:"subscriptionsSold"`
+		Revenue             model.Money `json:"revenue"`
+		ActiveSubscriptions int         `json:"activeSubscriptions"`
+		MealsRedeemed       int         `json:"mealsRedeemed"`
+		ValueRedeemed       model.Money `json:"valueRedeemed"`
+		MealsRolledOver     int         `json:"mealsRolledOver"`
+		MealsForfeited      int         `json:"mealsForfeited"`
+		MealsOutstanding    int         `json:"mealsOutstanding"`
+	}
+
+	usage := map[string]*planUsage{}
+	var planIDs []string
+	for _, plan := range plans {
+		usage[plan.ID] = &planUsage{
+			PlanID:        plan.ID,
+			Name:          plan.Name,
+			Active:        plan.Active,
+			Revenue:       model.Paise(0),
+			ValueRedeemed: model.Paise(0),
+		}
+		planIDs = append(planIDs, plan.ID)
+	}
+
+	for _, sub := range subs {
+		u, ok := usage[sub.PlanID]
+		if !ok {
+			continue
+		}
+		if sub.PaidAt >= start && sub.PaidAt < end {
+			u.SubscriptionsSold++
+			u.Revenue = u.Revenue.Add(sub.Price)
+			u.MealsRolledOver += sub.RolledOver
+		}
+		switch sub.Status {
+		case subscriptionActive:
+			u.ActiveSubscriptions++
+			u.MealsOutstanding += sub.MealsTotal - sub.MealsUsed
+		case subscriptionExpired:
+			if sub.EndsAt >= start && sub.EndsAt < end {
+				u.MealsForfeited += sub.MealsForfeited
+			}
+		}
+	}
+
+	for _, r := range redemptions {
+		if u, ok := usage[r.PlanID]; ok {
+			u.MealsRedeemed += r.Meals
+			u.ValueRedeemed = u.ValueRedeemed.Add(r.Value)
+		}
+	}
+
+	report := []*planUsage{}
+	for _, id := range planIDs {
+		report = append(report, usage[id])
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"from":  time.Unix(start, 0).Format("2006-01-02"),
+		"to":    time.Unix(end, 0).AddDate(0, 0, -1).Format("2006-01-02"),
+		"plans": report,
+	})
+}
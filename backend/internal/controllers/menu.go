@@ -0,0 +1,242 @@
+package controllers
+
+import (
+	"backend/internal/model"
+	mongodb "backend/internal/mogodb"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// menuDate is the key a daily menu is stored under. A new key each day is
+// what makes the remaining portions reset at day rollover.
+func menuDate(t time.Time) string {
+	return t.Format("2006-01-02")
+}
+
+// findMenu loads the menu for a date, returning mongo.ErrNoDocuments when
+// none has been published.
+func findMenu(ctx context.Context, date string) (model.DailyMenu, error) {
+	menuColl := mongodb.GetCollection("smartcanteen", "menus")
+
+	var menu model.DailyMenu
+	err := menuColl.FindOne(ctx, bson.M{"date": date}).Decode(&menu)
+	return menu, err
+}
+
+// menuRemaining reports today's remaining portions of a product. The second
+// result is false when no menu is published for today, in which case the
+// product's own quantity applies.
+func menuRemaining(ctx context.Context, productID string) (int, bool) {
+	menu, err := findMenu(ctx, menuDate(time.Now()))
+	if err != nil {
+		return 0, false
+	}
+
+	for _, item := range menu.Items {
+		if item.ProductID == productID {
+			return item.Remaining, true
+		}
+	}
+	return 0, true
+}
+
+// errSoldOut is an order for more portions than are left on the menu.
+var errSoldOut = errors.New("sold out on the menu")
+
+// takeMenuPortions takes an order's portions off the menu it was placed
+// against, as long as enough of each are left. It takes all of them or none.
+func takeMenuPortions(ctx context.Context, order model.Order) error {
+	if order.MenuDate == "" {
+		return nil
+	}
+	menuColl := mongodb.GetCollection("smartcanteen", "menus")
+
+	for i, item := range order.Items {
+		res, err := menuColl.UpdateOne(
+			ctx,
+			bson.M{"date": order.MenuDate, "items": bson.M{"$elemMatch": bson.M{
+				"productId": item.ProductID,
+				"remaining": bson.M{"$gte": item.Quantity},
+			}}},
+			bson.M{"$inc": bson.M{"items.$.remaining": -item.Quantity}},
+		)
+		if err == nil && res.ModifiedCount == 0 {
+			err = errSoldOut
+		}
+		if err != nil {
+			taken := order
+			taken.Items = order.Items[:i]
+			releaseMenuPortions(ctx, taken)
+			return err
+		}
+	}
+	return nil
+}
+
+// releaseMenuPortions puts an order's portions back on its menu.
+func releaseMenuPortions(ctx context.Context, order model.Order) {
+	if order.MenuDate == "" {
+		return
+	}
+	menuColl := mongodb.GetCollection("smartcanteen", "menus")
+
+	for _, item := range order.Items {
+		_, err := menuColl.UpdateOne(
+			ctx,
+			bson.M{"date": order.MenuDate, "items.productId": item.ProductID},
+			bson.M{"$inc": bson.M{"items.$.remaining": item.Quantity}},
+		)
+		if err != nil {
+			slog.Error("failed to restore menu portions", "order", order.ID, "product", item.ProductID, "error", err)
+		}
+	}
+}
+
+// wasteMenuPortions takes wasted portions of a product off today's menu, if
+// it is on it. Portions already held for orders are left alone, so at most
+// what is left is taken.
+func wasteMenuPortions(ctx context.Context, productID string, quantity int) error {
+	menuColl := mongodb.GetCollection("smartcanteen", "menus")
+	date := menuDate(time.Now())
+
+	res, err := menuColl.UpdateOne(
+		ctx,
+		bson.M{"date": date, "items": bson.M{"$elemMatch": bson.M{
+			"productId": productID,
+			"remaining": bson.M{"$gte": quantity},
+		}}},
+		bson.M{"$inc": bson.M{"items.$.remaining": -quantity}},
+	)
+	if err != nil || res.MatchedCount == 1 {
+		return err
+	}
+	_, err = menuColl.UpdateOne(
+		ctx,
+		bson.M{"date": date, "items.productId": productID},
+		bson.M{"$set": bson.M{"items.$.remaining": 0}},
+	)
+	return err
+}
+
+func SetDailyMenu(c *gin.Context) {
+	date := c.Param("date")
+	if _, err := time.Parse("2006-01-02", date); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid date, expected YYYY-MM-DD"})
+		return
+	}
+
+	var input model.DailyMenu
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	productColl := mongodb.GetCollection("smartcanteen", "products")
+	menuColl := mongodb.GetCollection("smartcanteen", "menus")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Portions already sold from an existing menu stay sold when it is edited
+	sold := map[string]int{}
+	if existing, err := findMenu(ctx, date); err == nil {
+		for _, item := range existing.Items {
+			sold[item.ProductID] = item.Prepared - item.Remaining
+		}
+	}
+
+	seen := map[string]bool{}
+	items := []model.MenuItem{}
+	for _, item := range input.Items {
+		if item.Prepared < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Prepared quantity cannot be negative"})
+			return
+		}
+		if seen[item.ProductID] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Product listed more than once"})
+			return
+		}
+		seen[item.ProductID] = true
+
+		pid, err := primitive.ObjectIDFromHex(item.ProductID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+			return
+		}
+
+		var product model.Product
+		if err := productColl.FindOne(ctx, bson.M{"_id": pid}).Decode(&product); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+			return
+		}
+
+		remaining := item.Prepared - sold[item.ProductID]
+		if remaining < 0 {
+			remaining = 0
+		}
+
+		items = append(items, model.MenuItem{
+			ProductID: item.ProductID,
+			Name:      product.Name,
+			Prepared:  item.Prepared,
+			Remaining: remaining,
+		})
+	}
+
+	updatedBy, _ := c.Get("username")
+	updatedByStr, _ := updatedBy.(string)
+
+	_, err := menuColl.UpdateOne(
+		ctx,
+		bson.M{"date": date},
+		bson.M{"$set": bson.M{
+			"date":      date,
+			"items":     items,
+			"updatedBy": updatedByStr,
+			"updatedAt": time.Now().Unix(),
+		}},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save menu"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Menu saved successfully",
+		"date":    date,
+		"items":   items,
+	})
+}
+
+func GetDailyMenu(c *gin.Context) {
+	date := c.Param("date")
+	if _, err := time.Parse("2006-01-02", date); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid date, expected YYYY-MM-DD"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	menu, err := findMenu(ctx, date)
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No menu planned for this date"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch menu"})
+		return
+	}
+
+	c.JSON(http.StatusOK, menu)
+}
@@ -0,0 +1,541 @@
+package controllers
+
+import (
+	"backend/internal/model"
+	mongodb "backend/internal/mogodb"
+	"backend/internal/totp"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	mfaChallengeTTL   = 5 * time.Minute
+	mfaMaxAttempts    = 5
+	recoveryCodeCount = 10
+	totpIssuer        = "Smart Canteen"
+	enrolmentCodeTTL  = 24 * time.Hour
+)
+
+// validEnrolmentCode reports whether code is the unexpired enrolment code
+// issued for user.
+func validEnrolmentCode(user model.User, code string) bool {
+	return code != "" && user.MFAEnrolmentHash != "" &&
+		hashToken(code) == user.MFAEnrolmentHash &&
+		time.Now().Unix() < user.MFAEnrolmentExpiresAt
+}
+
+// useEnrolmentCode removes an unexpired enrolment code, so each enrols once.
+func useEnrolmentCode(ctx context.Context, userID primitive.ObjectID, hash string) bool {
+	userColl := mongodb.GetCollection("smartcanteen", "users")
+
+	res, err := userColl.UpdateOne(
+		ctx,
+		bson.M{"_id": userID, "mfaEnrolmentHash": hash, "mfaEnrolmentExpiresAt": bson.M{"$gt": time.Now().Unix()}},
+		bson.M{"$unset": bson.M{"mfaEnrolmentHash": "", "mfaEnrolmentExpiresAt": ""}},
+	)
+	if err != nil {
+		slog.Error("failed to use enrolment code", "error", err)
+		return false
+	}
+	return res.ModifiedCount > 0
+}
+
+// generateRecoveryCodes returns fresh one-time codes in xxxx-xxxx form along
+// with the hashes that get stored.
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 4)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		code := hex.EncodeToString(b)
+		codes = append(codes, code[:4]+"-"+code[4:])
+		hashes = append(hashes, hashToken(code))
+	}
+	return codes, hashes, nil
+}
+
+// normalizeRecoveryCode accepts codes typed with or without the dash and in
+// any case.
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	code = strings.ReplaceAll(code, "-", "")
+	return strings.ReplaceAll(code, " ", "")
+}
+
+// enableTOTP switches 2FA on with secret, remembering step so the code that
+// confirmed it can't be used again. It returns the new recovery codes.
+func enableTOTP(ctx context.Context, userID primitive.ObjectID, secret string, step int64) ([]string, error) {
+	userColl := mongodb.GetCollection("smartcanteen", "users")
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = userColl.UpdateByID(ctx, userID, bson.M{
+		"$set": bson.M{
+			"totpEnabled":   true,
+			"totpSecret":    secret,
+			"totpLastStep":  step,
+			"recoveryCodes": hashes,
+		},
+		"$unset": bson.M{"totpPendingSecret": ""},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// claimTOTPStep records step as used. It fails if the same or a later step
+// was redeemed in the meantime, so concurrent requests can't share a code.
+func claimTOTPStep(ctx context.Context, userID primitive.ObjectID, step int64) bool {
+	userColl := mongodb.GetCollection("smartcanteen", "users")
+
+	res, err := userColl.UpdateOne(
+		ctx,
+		bson.M{"_id": userID, "totpLastStep": bson.M{"$not": bson.M{"$gte": step}}},
+		bson.M{"$set": bson.M{"totpLastStep": step}},
+	)
+	if err != nil {
+		slog.Error("failed to record TOTP step", "error", err)
+		return false
+	}
+	return res.ModifiedCount > 0
+}
+
+// useRecoveryCode removes a matching recovery code, so each works only once.
+func useRecoveryCode(ctx context.Context, userID primitive.ObjectID, code string) bool {
+	userColl := mongodb.GetCollection("smartcanteen", "users")
+
+	hash := hashToken(normalizeRecoveryCode(code))
+	res, err := userColl.UpdateOne(
+		ctx,
+		bson.M{"_id": userID, "recoveryCodes": hash},
+		bson.M{"$pull": bson.M{"recoveryCodes": hash}},
+	)
+	if err != nil {
+		slog.Error("failed to use recovery code", "error", err)
+		return false
+	}
+	return res.ModifiedCount > 0
+}
+
+// startMFAChallenge answers a correct password with a short-lived challenge
+// instead of tokens. An admin enrolling with the enrolment code hashed as
+// enrolmentHash also gets a secret to enrol with; their first code both
+// enables it and completes the login.
+func startMFAChallenge(c *gin.Context, ctx context.Context, user model.User, enrolmentHash string) {
+	challengeColl := mongodb.GetCollection("smartcanteen", "mfa_challenges")
+	userColl := mongodb.GetCollection("smartcanteen", "users")
+
+	token, err := randomToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login"})
+		return
+	}
+
+	response := gin.H{
+		"message":      "Two-factor code required",
+		"mfa_required": true,
+		"challenge":    token,
+		"expires_in":   int(mfaChallengeTTL.Seconds()),
+	}
+
+	if enrolmentHash != "" {
+		secret, err := totp.GenerateSecret()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login"})
+			return
+		}
+		_, err = userColl.UpdateByID(ctx, user.ID, bson.M{"$set": bson.M{"totpPendingSecret": secret}})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login"})
+			return
+		}
+		response["setup_required"] = true
+		response["secret"] = secret
+		response["otpauth_url"] = totp.ProvisioningURI(totpIssuer, user.Email, secret)
+	}
+
+	now := time.Now()
+	_, err = challengeColl.InsertOne(ctx, model.MFAChallenge{
+		UserID:        user.ID.Hex(),
+		TokenHash:     hashToken(token),
+		EnrolmentHash: enrolmentHash,
+		CreatedAt:     now.Unix(),
+		ExpiresAt:     now.Add(mfaChallengeTTL).Unix(),
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login"})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// LoginMFA finishes a login started with a password, given the challenge
+// and either a TOTP code or a recovery code.
+func LoginMFA(c *gin.Context) {
+	var input struct {
+		Challenge    string `json:"challenge" binding:"required"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil || (input.Code == "" && input.RecoveryCode == "") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	challengeColl := mongodb.GetCollection("smartcanteen", "mfa_challenges")
+	userColl := mongodb.GetCollection("smartcanteen", "users")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	now := time.Now()
+
+	// Every try uses up one attempt, so codes can't be guessed per challenge
+	var challenge model.MFAChallenge
+	err := challengeColl.FindOneAndUpdate(
+		ctx,
+		bson.M{
+			"tokenHash": hashToken(input.Challenge),
+			"usedAt":    0,
+			"expiresAt": bson.M{"$gt": now.Unix()},
+			"attempts":  bson.M{"$lt": mfaMaxAttempts},
+		},
+		bson.M{"$inc": bson.M{"attempts": 1}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&challenge)
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Login challenge is invalid or has expired"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify code"})
+		return
+	}
+
+	uid, err := primitive.ObjectIDFromHex(challenge.UserID)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Login challenge is invalid or has expired"})
+		return
+	}
+	var user model.User
+	if err := userColl.FindOne(ctx, bson.M{"_id": uid}).Decode(&user); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Login challenge is invalid or has expired"})
+		return
+	}
+	if user.Disabled {
+		writeAuthEvent(ctx, c, "login_failure", user.ID.Hex(), user.Email, "account_disabled")
+		c.JSON(http.StatusForbidden, gin.H{"error": "Account disabled"})
+		return
+	}
+
+	verified := false
+	var recoveryCodes []string
+	if input.Code != "" {
+		// Only a login started with an enrolment code can enrol
+		secret := user.TOTPSecret
+		if !user.TOTPEnabled && challenge.EnrolmentHash != "" {
+			secret = user.TOTPPendingSecret
+		}
+		if secret != "" {
+			if step, ok := totp.Validate(secret, input.Code, now, user.TOTPLastStep); ok {
+				if user.TOTPEnabled {
+					verified = claimTOTPStep(ctx, user.ID, step)
+				} else if useEnrolmentCode(ctx, user.ID, challenge.EnrolmentHash) {
+					recoveryCodes, err = enableTOTP(ctx, user.ID, secret, step)
+					if err != nil {
+						c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enable two-factor authentication"})
+						return
+					}
+					verified = true
+					writeAuthEvent(ctx, c, "mfa_enabled", user.ID.Hex(), user.Email, "")
+				}
+			}
+		}
+	} else if user.TOTPEnabled {
+		verified = useRecoveryCode(ctx, user.ID, input.RecoveryCode)
+		if verified {
+			writeAuthEvent(ctx, c, "recovery_code_used", user.ID.Hex(), user.Email, "")
+		}
+	}
+
+	if !verified {
+		writeAuthEvent(ctx, c, "login_failure", user.ID.Hex(), user.Email, "invalid_mfa_code")
+		if recordLoginFailure(ctx, accountAttemptKey(user.Email), accountLockThreshold) {
+			writeAuthEvent(ctx, c, "lockout", user.ID.Hex(), user.Email, "account")
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid two-factor code"})
+		return
+	}
+
+	// Only one request gets to redeem the challenge
+	res, err := challengeColl.UpdateOne(
+		ctx,
+		bson.M{"tokenHash": challenge.TokenHash, "usedAt": 0},
+		bson.M{"$set": bson.M{"usedAt": now.Unix()}},
+	)
+	if err != nil || res.ModifiedCount == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Login challenge is invalid or has expired"})
+		return
+	}
+
+	var extra gin.H
+	if recoveryCodes != nil {
+		extra = gin.H{"recovery_codes": recoveryCodes}
+	}
+	completeLogin(c, ctx, user, extra)
+}
+
+// issueEnrolmentCode stores a new enrolment code for user, replacing any
+// earlier one, and returns it with when it expires.
+func issueEnrolmentCode(ctx context.Context, user model.User) (string, int64, error) {
+	userColl := mongodb.GetCollection("smartcanteen", "users")
+
+	code, err := randomToken()
+	if err != nil {
+		return "", 0, err
+	}
+	expiresAt := time.Now().Add(enrolmentCodeTTL).Unix()
+	_, err = userColl.UpdateByID(ctx, user.ID, bson.M{"$set": bson.M{
+		"mfaEnrolmentHash":      hashToken(code),
+		"mfaEnrolmentExpiresAt": expiresAt,
+	}})
+	if err != nil {
+		return "", 0, err
+	}
+	return code, expiresAt, nil
+}
+
+// IssueMFAEnrolment gives an admin without 2FA a one-time code to log in
+// with and enrol. It is handed over out of band and expires after a day.
+// The first admin, with nobody to issue them one, gets theirs from
+// cmd/enrol.
+func IssueMFAEnrolment(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	user, ok := targetUser(c, ctx)
+	if !ok {
+		return
+	}
+	if user.TOTPEnabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is already enabled"})
+		return
+	}
+
+	code, expiresAt, err := issueEnrolmentCode(ctx, user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to issue enrolment code"})
+		return
+	}
+
+	writeAudit(ctx, c, "user.mfa_enrolment_issued", "user", user.ID.Hex(), nil)
+
+	c.JSON(http.StatusOK, gin.H{
+		"enrolment_code": code,
+		"expires_at":     expiresAt,
+	})
+}
+
+// Errors IssueAdminEnrolment reports for an account it won't issue a code to
+var (
+	ErrNotAdmin          = errors.New("no admin account with that email")
+	ErrMFAAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+)
+
+// IssueAdminEnrolment issues an enrolment code for the admin with the given
+// email. It is for commands run on the server, such as bootstrapping the
+// first admin, so the audit log records the command rather than a user.
+func IssueAdminEnrolment(ctx context.Context, email, issuedBy string) (string, int64, error) {
+	userColl := mongodb.GetCollection("smartcanteen", "users")
+	auditColl := mongodb.GetCollection("smartcanteen", "audit_logs")
+
+	var user model.User
+	err := userColl.FindOne(ctx, bson.M{"email": normalizeEmail(email), "role": "admin"}).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return "", 0, ErrNotAdmin
+	}
+	if err != nil {
+		return "", 0, err
+	}
+	if user.TOTPEnabled {
+		return "", 0, ErrMFAAlreadyEnabled
+	}
+
+	code, expiresAt, err := issueEnrolmentCode(ctx, user)
+	if err != nil {
+		return "", 0, err
+	}
+
+	_, err = auditColl.InsertOne(ctx, model.AuditLog{
+		ActorName:  issuedBy,
+		Action:     "user.mfa_enrolment_issued",
+		TargetType: "user",
+		TargetID:   user.ID.Hex(),
+		CreatedAt:  time.Now().Unix(),
+	})
+	if err != nil {
+		slog.Error("failed to write audit log", "action", "user.mfa_enrolment_issued", "target", user.ID.Hex(), "error", err)
+	}
+	return code, expiresAt, nil
+}
+
+// SetupTOTP starts enrolment for the caller. 2FA stays off until a code from
+// the new secret is confirmed with EnableTOTP.
+func SetupTOTP(c *gin.Context) {
+	userColl := mongodb.GetCollection("smartcanteen", "users")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	user, ok := currentUser(c, ctx)
+	if !ok {
+		return
+	}
+	if user.TOTPEnabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is already enabled"})
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start setup"})
+		return
+	}
+	_, err = userColl.UpdateByID(ctx, user.ID, bson.M{"$set": bson.M{"totpPendingSecret": secret}})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start setup"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"secret":      secret,
+		"otpauth_url": totp.ProvisioningURI(totpIssuer, user.Email, secret),
+	})
+}
+
+// EnableTOTP confirms enrolment with a code from the pending secret and hands
+// out the recovery codes. They are only ever shown this once.
+func EnableTOTP(c *gin.Context) {
+	var input struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	user, ok := currentUser(c, ctx)
+	if !ok {
+		return
+	}
+	if user.TOTPEnabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is already enabled"})
+		return
+	}
+	if user.TOTPPendingSecret == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Start two-factor setup first"})
+		return
+	}
+
+	step, valid := totp.Validate(user.TOTPPendingSecret, input.Code, time.Now(), user.TOTPLastStep)
+	if !valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid two-factor code"})
+		return
+	}
+
+	codes, err := enableTOTP(ctx, user.ID, user.TOTPPendingSecret, step)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enable two-factor authentication"})
+		return
+	}
+
+	writeAuthEvent(ctx, c, "mfa_enabled", user.ID.Hex(), user.Email, "")
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "Two-factor authentication enabled",
+		"recovery_codes": codes,
+	})
+}
+
+// DisableTOTP turns 2FA off after checking both the password and a current
+// code. Admins can't opt out.
+func DisableTOTP(c *gin.Context) {
+	var input struct {
+		Password string `json:"password" binding:"required"`
+		Code     string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	userColl := mongodb.GetCollection("smartcanteen", "users")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	user, ok := currentUser(c, ctx)
+	if !ok {
+		return
+	}
+	if user.Role == "admin" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Two-factor authentication is mandatory for admins"})
+		return
+	}
+	if !user.TOTPEnabled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Two-factor authentication is not enabled"})
+		return
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(input.Password)); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid password"})
+		return
+	}
+	step, valid := totp.Validate(user.TOTPSecret, input.Code, time.Now(), user.TOTPLastStep)
+	if !valid || !claimTOTPStep(ctx, user.ID, step) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid two-factor code"})
+		return
+	}
+
+	_, err := userColl.UpdateByID(ctx, user.ID, bson.M{
+		"$set": bson.M{"totpEnabled": false},
+		"$unset": bson.M{
+			"totpSecret":        "",
+			"totpPendingSecret": "",
+			"totpLastStep":      "",
+			"recoveryCodes":     "",
+		},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to disable two-factor authentication"})
+		return
+	}
+
+	writeAuthEvent(ctx, c, "mfa_disabled", user.ID.Hex(), user.Email, "")
+
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication disabled"})
+}
@@ -1,25 +1,35 @@
 package controllers
 
 import (
+	"backend/internal/middleware"
 	"backend/internal/model"
 	mongodb "backend/internal/mogodb"
+	"backend/internal/promo"
 	"context"
+	"errors"
 	"log/slog"
 	"net/http"
 	"os"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/joho/godotenv"
-	"github.com/razorpay/razorpay-go"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
+// orderLine is one product and quantity to be ordered, from a cart or from a
+// device's request.
+type orderLine struct {
+	productID primitive.ObjectID
+	quantity  int
+}
+
 func CreateOrder(c *gin.Context) {
-	err := godotenv.Load()
-	if err != nil {
-		slog.Error("No .env file found, falling back to system environment")
+	// Kiosks and POS terminals order for walk-up customers, without a cart
+	if c.GetString("role") == middlewares.RoleDevice {
+		createDeviceOrder(c)
+		return
 	}
 
 	userID, exists := c.Get("user_id")
@@ -41,8 +51,6 @@ func CreateOrder(c *gin.Context) {
 	}
 
 	cartColl := mongodb.GetCollection("smartcanteen", "addtocart")
-	orderColl := mongodb.GetCollection("smartcanteen", "orders")
-	productColl := mongodb.GetCollection("smartcanteen", "products")
 	userColl := mongodb.GetCollection("smartcanteen", "users")
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -54,6 +62,48 @@ func CreateOrder(c *gin.Context) {
 		return
 	}
 
+	// The body is optional; without one the order is paid through Razorpay
+	var input struct {
+		PaymentMethod string `json:"paymentMethod"`
+		// Loyalty points to put towards the order
+		RedeemPoints int64 `json:"redeemPoints"`
+		// Split the payment between several payers
+		Split *splitRequest `json:"split"`
+		// Let the caller's meal plan cover eligible items
+		UseMealPlan bool `json:"useMealPlan"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+			return
+		}
+	}
+	if input.PaymentMethod == "" {
+		input.PaymentMethod = "razorpay"
+	}
+	if input.PaymentMethod != "razorpay" && input.PaymentMethod != "wallet" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Payment method must be razorpay or wallet"})
+		return
+	}
+	if input.RedeemPoints < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Points to redeem cannot be negative"})
+		return
+	}
+	if input.Split != nil && (input.PaymentMethod != "razorpay" || input.RedeemPoints > 0 || input.UseMealPlan) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Only Razorpay payments without points or a meal plan can be split"})
+		return
+	}
+
+	unverified, err := emailUnverified(ctx, uid)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user details"})
+		return
+	}
+	if unverified {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Please verify your email before ordering"})
+		return
+	}
+
 	cursor, err := cartColl.Find(ctx, bson.M{"user_id": uid})
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch cart"})
@@ -72,44 +122,234 @@ func CreateOrder(c *gin.Context) {
 		return
 	}
 
-	var orderItems []model.OrderItem
-	var subTotal float64 = 0
-
+	var lines []orderLine
 	for _, item := range cartItems {
 		productIDHex, _ := item["product_id"].(primitive.ObjectID)
-		quantity := int(item["quantity"].(int32))
+		lines = append(lines, orderLine{productID: productIDHex, quantity: int(item["quantity"].(int32))})
+	}
+	if input.Split != nil {
+		if err := input.Split.validate(len(lines)); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	var mealSubscriptionID string
+	if input.UseMealPlan {
+		sub, err := mealSubscriptionFor(ctx, uidStr, lines)
+		if err == errNoMealsLeft {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No meal plan covers these items today"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch meal plan"})
+			return
+		}
+		mealSubscriptionID = sub.ID
+	}
+
+	placeOrder(c, ctx, model.Order{
+		CustomerID:         uidStr,
+		CustomerName:       user.Username,
+		CustomerEmail:      user.Email,
+		PaymentMethod:      input.PaymentMethod,
+		PointsRedeemed:     input.RedeemPoints,
+		MealSubscriptionID: mealSubscriptionID,
+	}, lines, input.Split)
+}
+
+// orderItemsInput is a list of items sent in the request body, by a device
+// or at the counter, rather than taken from a cart.
+type orderItemsInput struct {
+	Items []struct {
+		ProductID string `json:"productId" binding:"required"`
+		Quantity  int    `json:"quantity" binding:"required"`
+	} `json:"items" binding:"required"`
+}
+
+// lines validates the items, responding with 400 when they are not usable.
+func (in orderItemsInput) lines(c *gin.Context) ([]orderLine, bool) {
+	if len(in.Items) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Order has no items"})
+		return nil, false
+	}
+
+	var lines []orderLine
+	for _, item := range in.Items {
+		pid, err := primitive.ObjectIDFromHex(item.ProductID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+			return nil, false
+		}
+		if item.Quantity <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Quantity must be positive"})
+			return nil, false
+		}
+		lines = append(lines, orderLine{productID: pid, quantity: item.Quantity})
+	}
+	return lines, true
+}
+
+// createDeviceOrder takes the items from the request body and records which
+// device placed the order.
+func createDeviceOrder(c *gin.Context) {
+	var input struct {
+		orderItemsInput
+		CustomerName string `json:"customerName"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+	lines, ok := input.lines(c)
+	if !ok {
+		return
+	}
+
+	customerName := input.CustomerName
+	if customerName == "" {
+		customerName = c.GetString("device_name")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	placeOrder(c, ctx, model.Order{
+		CustomerName:  customerName,
+		DeviceID:      c.GetString("device_id"),
+		DeviceName:    c.GetString("device_name"),
+		PaymentMethod: "razorpay",
+	}, lines, nil)
+}
+
+// placeOrder prices the lines and saves the order for the given customer.
+// Wallet orders are paid on the spot; otherwise the matching Razorpay order
+// is opened, or one per share when the payment is split.
+func placeOrder(c *gin.Context, ctx context.Context, order model.Order, lines []orderLine, split *splitRequest) {
+	orderColl := mongodb.GetCollection("smartcanteen", "orders")
+	productColl := mongodb.GetCollection("smartcanteen", "products")
+
+	var products []model.Product
+	var promoLines []promo.Line
+
+	for _, line := range lines {
+		if line.quantity < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Quantity must be at least 1"})
+			return
+		}
 
 		var product model.Product
-		err := productColl.FindOne(ctx, bson.M{"_id": productIDHex}).Decode(&product)
+		err := productColl.FindOne(ctx, bson.M{"_id": line.productID}).Decode(&product)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch product"})
 			return
 		}
 
-		total := product.Price * float64(quantity)
+		products = append(products, product)
+		promoLines = append(promoLines, promoLine(product, line.quantity))
+	}
 
-		orderItem := model.OrderItem{
-			ProductID: product.ID,
-			Name:      product.Name,
-			Price:     product.Price,
-			Quantity:  quantity,
-			Total:     total,
+	// When a menu is published for today only what is left on it can be
+	// ordered, and the order takes its portions from that menu
+	menu, err := findMenu(ctx, menuDate(time.Now()))
+	if err == nil {
+		order.MenuDate = menu.Date
+		for i, line := range lines {
+			remaining := 0
+			for _, item := range menu.Items {
+				if item.ProductID == line.productID.Hex() {
+					remaining = item.Remaining
+				}
+			}
+			if line.quantity > remaining {
+				c.JSON(http.StatusBadRequest, gin.H{"error": products[i].Name + " is sold out for today"})
+				return
+			}
 		}
+	} else if err != mongo.ErrNoDocuments {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch today's menu"})
+		return
+	}
 
-		orderItems = append(orderItems, orderItem)
-		subTotal += total
+	// Meals a plan covers are free, so promotions only see the units paid for
+	covered := make([]int, len(lines))
+	if order.MealSubscriptionID != "" {
+		var err error
+		covered, err = coverMeals(ctx, order.MealSubscriptionID, lines)
+		if err == errNoMealsLeft {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No meal plan covers these items today"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch meal plan"})
+			return
+		}
+		for i := range promoLines {
+			promoLines[i].Quantity -= covered[i]
+		}
 	}
 
-	order := model.Order{
-		CustomerID:    uidStr,
-		CustomerName:  user.Username,
-		CustomerEmail: user.Email,
-		Items:         orderItems,
-		Total:         subTotal,
-		Status:        "Pending",
-		PaymentMethod: "razorpay",
-		IsPaid:        false,
-		CreatedAt:     time.Now().Unix(),
+	// Discounts come off before tax, so the amount charged is what is left
+	// after them
+	var coupon string
+	if order.DeviceID == "" && order.ShiftID == "" {
+		coupon = cartCoupon(ctx, order.CustomerID)
+	}
+	discounts, err := applyPromotions(ctx, order.CustomerID, coupon, promoLines)
+	var invalid couponError
+	if errors.As(err, &invalid) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Coupon " + coupon + ": " + invalid.Error()})
+		return
+	}
+	if err != nil {
+		slog.Error("failed to apply promotions", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to apply promotions"})
+		return
+	}
+
+	var orderItems []model.OrderItem
+	order.MealValue = model.Paise(0)
+	for i, product := range products {
+		mealValue := product.Price.Mul(covered[i])
+		order.MealsCovered += covered[i]
+		order.MealValue = order.MealValue.Add(mealValue)
+		orderItems = append(orderItems, orderItemFor(product, lines[i].quantity, discounts.LineDiscounts[i].Add(mealValue)))
+	}
+	if order.MealsCovered == 0 {
+		order.MealSubscriptionID = ""
+	} else {
+		order.MealDay = mealDay(time.Now())
+	}
+
+	order.Items = orderItems
+	order.Discounts = discounts.Applied
+	sumOrderTotals(&order)
+	if order.Total.Amount < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Order total cannot be negative"})
+		return
+	}
+	order.Status = "Pending"
+	order.IsPaid = false
+	order.CreatedAt = time.Now().Unix()
+
+	if order.PointsRedeemed > 0 {
+		order.PointsValue = model.Paise(order.PointsRedeemed * pointValue())
+		if order.PointsValue.Amount > order.Total.Amount {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Points redeemed are worth more than the order"})
+			return
+		}
+	}
+	// Nothing is left to pay online when points, a meal plan or discounts
+	// cover the whole order, so it is completed without a gateway
+	if amountDue(order).IsZero() && (order.PaymentMethod == "razorpay" || order.PaymentMethod == "wallet") {
+		switch {
+		case order.PointsRedeemed > 0:
+			order.PaymentMethod = "points"
+		case order.MealsCovered > 0:
+			order.PaymentMethod = "meal_plan"
+		default:
+			order.PaymentMethod = "promotion"
+		}
 	}
 
 	res, err := orderColl.InsertOne(ctx, order)
@@ -118,23 +358,97 @@ func CreateOrder(c *gin.Context) {
 		return
 	}
 
-	insertedID := res.InsertedID.(primitive.ObjectID).Hex()
+	oid := res.InsertedID.(primitive.ObjectID)
+	insertedID := oid.Hex()
+	order.ID = insertedID
+
+	// Points and meals are taken now rather than on payment, so two pending
+	// orders can't spend the same ones
+	if err := holdOrder(ctx, order); err != nil {
+		orderColl.DeleteOne(ctx, bson.M{"_id": oid})
+		switch err {
+		case errNotEnoughPoints:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Not enough loyalty points"})
+		case errNoMealsLeft:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No meal plan covers these items today"})
+		case errPromotionUsedUp:
+			c.JSON(http.StatusConflict, gin.H{"error": "A discount on this order is no longer available"})
+		case errSoldOut:
+			c.JSON(http.StatusConflict, gin.H{"error": "Some items sold out while the order was placed"})
+		default:
+			slog.Error("failed to place order", "order", insertedID, "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create order"})
+		}
+		return
+	}
 
-	client := razorpay.NewClient(os.Getenv("RAZORPAY_KEY_ID"), os.Getenv("RAZORPAY_KEY_SECRET"))
+	if order.PaymentMethod == "wallet" {
+		payFromWallet(c, ctx, oid, order)
+		return
+	}
+	if order.PaymentMethod == "cash" || order.PaymentMethod == "card" {
+		payAtCounter(c, ctx, oid, order)
+		return
+	}
+	if order.PaymentMethod == "points" || order.PaymentMethod == "meal_plan" || order.PaymentMethod == "promotion" {
+		if _, err := completeOrder(ctx, oid, nil); err != nil {
+			dropOrder(ctx, oid, order)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update order status"})
+			return
+		}
+		// No money changed hands for a meal plan or discounts, so there is
+		// no payment to record
+		message := "Order placed and paid with points"
+		switch order.PaymentMethod {
+		case "meal_plan":
+			message = "Order placed and covered by your meal plan"
+		case "promotion":
+			message = "Order placed and covered by your discounts"
+		default:
+			recordDirectPayment(ctx, oid, order)
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"message": message,
+			"orderID": insertedID,
+			"isPaid":  true,
+		})
+		return
+	}
+
+	if split != nil {
+		openSplit(c, ctx, oid, order, split)
+		return
+	}
 
 	data := map[string]interface{}{
-		"amount":          int(subTotal * 100),
+		"amount":          amountDue(order).Amount,
 		"currency":        "INR",
 		"receipt":         insertedID,
 		"payment_capture": 1,
 	}
 
-	razorOrder, err := client.Order.Create(data, nil)
+	razorOrder, err := razorpayClient().Order.Create(data, nil)
 	if err != nil {
+		dropOrder(ctx, oid, order)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create Razorpay order"})
 		return
 	}
 
+	// Payments are only accepted for the gateway order stored here, and
+	// reconciliation matches them to orders by it, so an order without one
+	// is dropped
+	razorOrderID, _ := razorOrder["id"].(string)
+	if razorOrderID != "" {
+		_, err = orderColl.UpdateByID(ctx, oid, bson.M{"$set": bson.M{"razorpay_order_id": razorOrderID}})
+	}
+	if razorOrderID == "" || err != nil {
+		slog.Error("failed to store Razorpay order ID", "order", insertedID, "error", err)
+		dropOrder(ctx, oid, order)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create Razorpay order"})
+		return
+	}
+	openGatewayPayment(ctx, order, razorOrderID, amountDue(order))
+
 	c.JSON(http.StatusOK, gin.H{
 		"message":         "Order created successfully",
 		"orderID":         insertedID,
@@ -143,12 +457,47 @@ func CreateOrder(c *gin.Context) {
 		"currency":        razorOrder["currency"],
 		"key":             os.Getenv("RAZORPAY_KEY_ID"),
 		"user": gin.H{
-			"name":  user.Username,
-			"email": user.Email,
+			"name":  order.CustomerName,
+			"email": order.CustomerEmail,
 		},
 	})
 }
 
+// payFromWallet charges a freshly saved order to the customer's wallet and
+// completes it. Without enough balance the order is dropped again.
+func payFromWallet(c *gin.Context, ctx context.Context, oid primitive.ObjectID, order model.Order) {
+	amount := amountDue(order).Amount
+	err := debitWallet(ctx, order.CustomerID, amount, accountSales, "order", oid.Hex())
+	if err != nil {
+		dropOrder(ctx, oid, order)
+		if err == errInsufficientFunds {
+			c.JSON(http.StatusPaymentRequired, gin.H{"error": "Insufficient wallet balance"})
+			return
+		}
+		slog.Error("failed to charge wallet", "order", oid.Hex(), "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to charge wallet"})
+		return
+	}
+
+	if _, err := completeOrder(ctx, oid, nil); err != nil {
+		slog.Error("failed to complete wallet order, refunding", "order", oid.Hex(), "error", err)
+		if err := creditWallet(ctx, order.CustomerID, amount, accountSales, "order_refund", oid.Hex()); err != nil {
+			slog.Error("failed to refund wallet", "order", oid.Hex(), "error", err)
+		}
+		dropOrder(ctx, oid, order)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update order status"})
+		return
+	}
+	recordDirectPayment(ctx, oid, order)
+
+	balance, _ := walletBalance(ctx, order.CustomerID)
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Order placed and paid from wallet",
+		"orderID": oid.Hex(),
+		"isPaid":  true,
+		"balance": model.Paise(balance),
+	})
+}
 
 func GetOrder(c *gin.Context) {
 	userID, exists := c.Get("user_id")
@@ -189,7 +538,7 @@ func GetOrder(c *gin.Context) {
 			if err == nil {
 				orders[i].Items[j].Name = product.Name
 				orders[i].Items[j].Price = product.Price
-				orders[i].Items[j].Total = product.Price * float64(item.Quantity)
+				orders[i].Items[j].Total = product.Price.Mul(item.Quantity)
 			}
 		}
 	}
@@ -200,19 +549,13 @@ func GetOrder(c *gin.Context) {
 }
 
 func GetAllOrders(c *gin.Context) {
-	role, _ := c.Get("role")
-	if role != "admin" {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
-		return
-	}
-
 	orderColl := mongodb.GetCollection("smartcanteen", "orders")
 	productColl := mongodb.GetCollection("smartcanteen", "products")
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	cursor, err := orderColl.Find(ctx, bson.M{}) 
+	cursor, err := orderColl.Find(ctx, bson.M{})
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch orders"})
 		return
@@ -232,24 +575,17 @@ func GetAllOrders(c *gin.Context) {
 			if err == nil {
 				orders[i].Items[j].Name = product.Name
 				orders[i].Items[j].Price = product.Price
-				orders[i].Items[j].Total = product.Price * float64(item.Quantity)
+				orders[i].Items[j].Total = product.Price.Mul(item.Quantity)
 			}
 		}
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"orders": orders, 
+		"orders": orders,
 	})
 }
 
-
 func MarkOrderDelivered(c *gin.Context) {
-	role, _ := c.Get("role")
-	if role != "admin" {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
-		return
-	}
-
 	orderID := c.Param("id")
 	if orderID == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Order ID is required"})
@@ -0,0 +1,184 @@
+package controllers
+
+import (
+	"backend/internal/mail"
+	"backend/internal/model"
+	mongodb "backend/internal/mogodb"
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	passwordResetTTL  = 30 * time.Minute
+	minPasswordLength = 8
+)
+
+// appBaseURL is where links in outgoing mail point to.
+func appBaseURL() string {
+	if base := os.Getenv("APP_BASE_URL"); base != "" {
+		return base
+	}
+	return "http://localhost:5173"
+}
+
+// issuePasswordReset mails the user a fresh single-use reset link. Links sent
+// earlier stop working.
+func issuePasswordReset(ctx context.Context, user model.User) error {
+	resetColl := mongodb.GetCollection("smartcanteen", "password_resets")
+
+	token, err := randomToken()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	userID := user.ID.Hex()
+
+	_, err = resetColl.UpdateMany(
+		ctx,
+		bson.M{"userId": userID, "usedAt": 0},
+		bson.M{"$set": bson.M{"usedAt": now.Unix()}},
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = resetColl.InsertOne(ctx, model.PasswordReset{
+		UserID:    userID,
+		TokenHash: hashToken(token),
+		CreatedAt: now.Unix(),
+		ExpiresAt: now.Add(passwordResetTTL).Unix(),
+	})
+	if err != nil {
+		return err
+	}
+
+	link := appBaseURL() + "/reset-password?token=" + url.QueryEscape(token)
+	return mail.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: "Reset your Smart Canteen password",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nUse the link below to choose a new password. It expires in %d minutes and can only be used once.\n\n%s\n\nIf you didn't ask for this, you can ignore this email.\n",
+			user.Username, int(passwordResetTTL.Minutes()), link,
+		),
+	})
+}
+
+func ForgotPassword(c *gin.Context) {
+	var input struct {
+		Email string `json:"email" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	input.Email = normalizeEmail(input.Email)
+
+	userColl := mongodb.GetCollection("smartcanteen", "users")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// The response is the same whether or not the account exists
+	var user model.User
+	err := userColl.FindOne(ctx, bson.M{"email": input.Email}).Decode(&user)
+	if err == nil {
+		// A failure to send would otherwise show the account exists
+		if err := issuePasswordReset(ctx, user); err != nil {
+			slog.Error("failed to issue password reset", "error", err)
+		}
+	} else if err != mongo.ErrNoDocuments {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to look up account"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "If that email is registered, a reset link has been sent"})
+}
+
+func ResetPassword(c *gin.Context) {
+	var input struct {
+		Token    string `json:"token" binding:"required"`
+		Password string `json:"password" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	if len(input.Password) < minPasswordLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Password must be at least %d characters", minPasswordLength)})
+		return
+	}
+
+	resetColl := mongodb.GetCollection("smartcanteen", "password_resets")
+	userColl := mongodb.GetCollection("smartcanteen", "users")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	now := time.Now().Unix()
+
+	// Claiming the token and marking it used is one step, so it works once
+	var reset model.PasswordReset
+	err := resetColl.FindOneAndUpdate(
+		ctx,
+		bson.M{"tokenHash": hashToken(input.Token), "usedAt": 0, "expiresAt": bson.M{"$gt": now}},
+		bson.M{"$set": bson.M{"usedAt": now}},
+	).Decode(&reset)
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Reset link is invalid or has expired"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset password"})
+		return
+	}
+
+	uid, err := primitive.ObjectIDFromHex(reset.UserID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Reset link is invalid or has expired"})
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(input.Password), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset password"})
+		return
+	}
+
+	res, err := userColl.UpdateByID(ctx, uid, bson.M{"$set": bson.M{
+		"password":              string(hashedPassword),
+		"passwordResetRequired": false,
+	}})
+	if err != nil || res.MatchedCount == 0 {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset password"})
+		return
+	}
+
+	// Anyone holding an old session or reset link is locked out from here on
+	if err := revokeUserSessions(ctx, reset.UserID, "password_reset"); err != nil {
+		slog.Error("failed to revoke sessions after password reset", "error", err)
+	}
+	_, err = resetColl.UpdateMany(
+		ctx,
+		bson.M{"userId": reset.UserID, "usedAt": 0},
+		bson.M{"$set": bson.M{"usedAt": now}},
+	)
+	if err != nil {
+		slog.Error("failed to invalidate reset tokens", "error", err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password has been reset, please log in"})
+}
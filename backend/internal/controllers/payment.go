@@ -13,16 +13,113 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/razorpay/razorpay-go"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
+func razorpayClient() *razorpay.Client {
+	return razorpay.NewClient(os.Getenv("RAZORPAY_KEY_ID"), os.Getenv("RAZORPAY_KEY_SECRET"))
+}
+
+// validRazorpaySignature checks the signature Razorpay's checkout hands back
+// for a payment against our key secret.
+func validRazorpaySignature(razorpayOrderID, razorpayPaymentID, signature string) bool {
+	h := hmac.New(sha256.New, []byte(os.Getenv("RAZORPAY_KEY_SECRET")))
+	h.Write([]byte(razorpayOrderID + "|" + razorpayPaymentID))
+	generatedSignature := hex.EncodeToString(h.Sum(nil))
+
+	return hmac.Equal([]byte(generatedSignature), []byte(signature))
+}
+
+// completeOrder marks a pending order paid, gives it an invoice number,
+// takes its items out of stock and empties the customer's cart. Its menu
+// portions were already taken when it was placed. Only the first call for
+// an order does anything, so a payment confirmed twice is counted once. It
+// reports whether this call was the one that completed the order.
+func completeOrder(ctx context.Context, oid primitive.ObjectID, payment bson.M) (bool, error) {
+	orderColl := mongodb.GetCollection("smartcanteen", "orders")
+	cartColl := mongodb.GetCollection("smartcanteen", "addtocart")
+	productColl := mongodb.GetCollection("smartcanteen", "products")
+	couponColl := mongodb.GetCollection("smartcanteen", "cart_coupons")
+
+	set := bson.M{
+		"status":    "Paid",
+		"isPaid":    true,
+		"updatedAt": time.Now().Unix(),
+	}
+	for k, v := range payment {
+		set[k] = v
+	}
+
+	var order model.Order
+	err := orderColl.FindOneAndUpdate(
+		ctx,
+		// A split order that ran out of time stays unpaid
+		bson.M{"_id": oid, "isPaid": false, "status": bson.M{"$ne": "Expired"}},
+		bson.M{"$set": set},
+	).Decode(&order)
+	if err != nil {
+		return false, err
+	}
+
+	// Invoices are only numbered once paid, so the series has no gaps for
+	// abandoned orders
+	now := time.Now()
+	invoiceNumber, err := nextInvoiceNumber(ctx, now)
+	if err == nil {
+		_, err = orderColl.UpdateByID(ctx, oid, bson.M{"$set": bson.M{
+			"invoiceNumber": invoiceNumber,
+			"invoicedAt":    now.Unix(),
+		}})
+	}
+	if err != nil {
+		slog.Error("failed to issue invoice number", "order", oid.Hex(), "error", err)
+	}
+
+	for _, item := range order.Items {
+		pid, err := primitive.ObjectIDFromHex(item.ProductID)
+		if err != nil {
+			slog.Error("invalid product in paid order", "order", oid.Hex(), "product", item.ProductID)
+			continue
+		}
+
+		_, err = productColl.UpdateOne(
+			ctx,
+			bson.M{"_id": pid},
+			bson.M{"$inc": bson.M{"quantity": -item.Quantity}},
+		)
+		if err != nil {
+			slog.Error("failed to update product stock", "order", oid.Hex(), "product", item.ProductID, "error", err)
+		}
+	}
+
+	settleLoyalty(ctx, order)
+	redeemMeals(ctx, order)
+
+	// Orders taken at the counter or on a device didn't come from the cart
+	uid, err := primitive.ObjectIDFromHex(order.CustomerID)
+	if err == nil && order.ShiftID == "" {
+		if _, err := cartColl.DeleteMany(ctx, bson.M{"user_id": uid}); err != nil {
+			slog.Error("failed to clear cart", "user", order.CustomerID, "error", err)
+		}
+		if _, err := couponColl.DeleteOne(ctx, bson.M{"_id": order.CustomerID}); err != nil {
+			slog.Error("failed to clear cart coupon", "user", order.CustomerID, "error", err)
+		}
+	}
+
+	return true, nil
+}
+
 func VerifyPayment(c *gin.Context) {
 	var body struct {
 		RazorpayPaymentID string `json:"razorpay_payment_id"`
 		RazorpayOrderID   string `json:"razorpay_order_id"`
 		RazorpaySignature string `json:"razorpay_signature"`
 		OrderID           string `json:"orderID"`
+		// ShareID is set when paying one share of a split order
+		ShareID string `json:"shareID"`
 	}
 
 	if err := c.BindJSON(&body); err != nil {
@@ -30,18 +127,6 @@ func VerifyPayment(c *gin.Context) {
 		return
 	}
 
-	keySecret := os.Getenv("RAZORPAY_KEY_SECRET")
-	data := body.RazorpayOrderID + "|" + body.RazorpayPaymentID
-
-	h := hmac.New(sha256.New, []byte(keySecret))
-	h.Write([]byte(data))
-	generatedSignature := hex.EncodeToString(h.Sum(nil))
-
-	if generatedSignature != body.RazorpaySignature {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payment signature"})
-		return
-	}
-
 	oid, err := primitive.ObjectIDFromHex(body.OrderID)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Mongo order ID"})
@@ -49,8 +134,6 @@ func VerifyPayment(c *gin.Context) {
 	}
 
 	orderColl := mongodb.GetCollection("smartcanteen", "orders")
-	cartColl := mongodb.GetCollection("smartcanteen", "addtocart")
-	productColl := mongodb.GetCollection("smartcanteen", "products")
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
@@ -62,51 +145,62 @@ func VerifyPayment(c *gin.Context) {
 		return
 	}
 
-	for _, item := range order.Items {
-		pid, err := primitive.ObjectIDFromHex(item.ProductID)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
-			return
-		}
-
-		res, err := productColl.UpdateOne(
-			ctx,
-			bson.M{"_id": pid},
-			bson.M{"$inc": bson.M{"quantity": -item.Quantity}},
-		)
-		if err != nil || res.MatchedCount == 0 {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update product stock"})
-			return
-		}
+	attempt := gatewayAttempt{
+		ProviderOrderID:   body.RazorpayOrderID,
+		ProviderPaymentID: body.RazorpayPaymentID,
+		Signature:         body.RazorpaySignature,
+		SignatureValid:    validRazorpaySignature(body.RazorpayOrderID, body.RazorpayPaymentID, body.RazorpaySignature),
+		Amount:            amountDue(order),
+	}
+	// Anyone may pay a share of a split order; other orders only by whoever
+	// placed them
+	if order.SplitMode != "" {
+		verifyShare(c, ctx, order, body.ShareID, attempt)
+		return
+	}
+	if !callerOwnsOrder(c, order) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		return
+	}
+	if !attempt.SignatureValid {
+		failPayment(ctx, order, attempt, "signature_invalid", "Payment signature did not match")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payment signature"})
+		return
+	}
+	// A genuine payment for a different order can't be used to pay this one,
+	// and an order without a gateway order of its own can't be paid here
+	if order.RazorpayOrderID == "" || order.RazorpayOrderID != body.RazorpayOrderID {
+		slog.Error("payment for another gateway order", "order", body.OrderID, "razorpayOrder", body.RazorpayOrderID, "payment", body.RazorpayPaymentID)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Payment does not match this order"})
+		return
 	}
 
-
-	_, err = orderColl.UpdateOne(
-		ctx,
-		bson.M{"_id": oid},
-		bson.M{
-			"$set": bson.M{
-				"status":              "Paid",
-				"isPaid":              true,
-				"razorpay_payment_id": body.RazorpayPaymentID,
-				"razorpay_order_id":   body.RazorpayOrderID,
-				"updatedAt":           time.Now().Unix(),
-			},
-		},
-	)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update order status"})
+	capturePayment(ctx, order, attempt)
+	if order.IsPaid {
+		c.JSON(http.StatusOK, gin.H{"message": "Payment verified, order completed"})
 		return
 	}
 
-	uid, err := primitive.ObjectIDFromHex(order.CustomerID)
-	if err == nil {
-		_, err = cartColl.DeleteMany(ctx, bson.M{"user_id": uid})
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to clear cart"})
+	_, err = completeOrder(ctx, oid, bson.M{
+		"razorpay_payment_id": body.RazorpayPaymentID,
+		"razorpay_order_id":   body.RazorpayOrderID,
+	})
+	if err == mongo.ErrNoDocuments {
+		// Paid after it expired, so what it held may already be spent
+		var current model.Order
+		if orderColl.FindOne(ctx, bson.M{"_id": oid}).Decode(&current) == nil && current.Status == "Expired" {
+			if err := refundLatePayment(ctx, current, attempt); err != nil {
+				slog.Error("failed to refund payment for expired order", "order", body.OrderID, "payment", body.RazorpayPaymentID, "error", err)
+			}
+			c.JSON(http.StatusGone, gin.H{"error": "This order has expired; the payment will be refunded"})
 			return
 		}
 	}
+	if err != nil {
+		slog.Error("failed to complete order", "order", body.OrderID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update order status"})
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Payment verified, order completed"})
 }
@@ -0,0 +1,351 @@
+package controllers
+
+import (
+	"backend/internal/middleware"
+	"backend/internal/model"
+	mongodb "backend/internal/mogodb"
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Payment attempt statuses
+const (
+	paymentCreated  = "created"
+	paymentCaptured = "captured"
+	paymentFailed   = "failed"
+	paymentRefunded = "refunded"
+)
+
+// gatewayAttempt is what the checkout reports back about one gateway payment.
+type gatewayAttempt struct {
+	ProviderOrderID   string
+	ProviderPaymentID string
+	Signature         string
+	SignatureValid    bool
+	// Amount is what the gateway order was for
+	Amount model.Money
+}
+
+// openGatewayPayment records the gateway order opened for an order, before
+// the customer has tried to pay.
+func openGatewayPayment(ctx context.Context, order model.Order, providerOrderID string, amount model.Money) {
+	paymentColl := mongodb.GetCollection("smartcanteen", "payments")
+
+	now := time.Now().Unix()
+	_, err := paymentColl.InsertOne(ctx, model.Payment{
+		OrderID:         order.ID,
+		UserID:          order.CustomerID,
+		Provider:        "razorpay",
+		ProviderOrderID: providerOrderID,
+		Amount:          amount,
+		Status:          paymentCreated,
+		CreatedAt:       now,
+		UpdatedAt:       now,
+	})
+	if err != nil {
+		slog.Error("failed to record payment", "order", order.ID, "error", err)
+	}
+}
+
+// capturePayment records a gateway payment as captured. The attempt opened
+// for the gateway order becomes the captured one; a payment we have no
+// attempt for, such as a second payment on the same gateway order, gets its
+// own record.
+func capturePayment(ctx context.Context, order model.Order, attempt gatewayAttempt) {
+	paymentColl := mongodb.GetCollection("smartcanteen", "payments")
+
+	now := time.Now().Unix()
+	set := bson.M{
+		"providerPaymentId": attempt.ProviderPaymentID,
+		"status":            paymentCaptured,
+		"signature":         attempt.Signature,
+		"signatureValid":    attempt.SignatureValid,
+		"capturedAt":        now,
+		"updatedAt":         now,
+	}
+
+	// Captured or reported failed before for this order, e.g. verified twice.
+	// A row for the same payment on another order is never taken over: it
+	// was reported by someone who couldn't prove the payment
+	res, err := paymentColl.UpdateOne(ctx, bson.M{
+		"orderId":           order.ID,
+		"providerOrderId":   attempt.ProviderOrderID,
+		"providerPaymentId": attempt.ProviderPaymentID,
+	}, bson.M{"$set": set})
+	if err == nil && res.MatchedCount == 0 {
+		res, err = paymentColl.UpdateOne(ctx, bson.M{
+			"orderId":         order.ID,
+			"providerOrderId": attempt.ProviderOrderID,
+			"status":          paymentCreated,
+		}, bson.M{"$set": set})
+	}
+	if err == nil && res.MatchedCount == 0 {
+		_, err = paymentColl.InsertOne(ctx, model.Payment{
+			OrderID:           order.ID,
+			UserID:            order.CustomerID,
+			Provider:          "razorpay",
+			ProviderOrderID:   attempt.ProviderOrderID,
+			ProviderPaymentID: attempt.ProviderPaymentID,
+			Amount:            attempt.Amount,
+			Status:            paymentCaptured,
+			Signature:         attempt.Signature,
+			SignatureValid:    attempt.SignatureValid,
+			CreatedAt:         now,
+			UpdatedAt:         now,
+			CapturedAt:        now,
+		})
+	}
+	if err != nil {
+		slog.Error("failed to record captured payment", "order", order.ID, "payment", attempt.ProviderPaymentID, "error", err)
+	}
+}
+
+// failPayment records a gateway payment that failed or could not be
+// verified. Each failed payment ID is recorded once per order.
+func failPayment(ctx context.Context, order model.Order, attempt gatewayAttempt, code, reason string) {
+	paymentColl := mongodb.GetCollection("smartcanteen", "payments")
+
+	now := time.Now().Unix()
+	filter := bson.M{
+		"orderId":           order.ID,
+		"providerOrderId":   attempt.ProviderOrderID,
+		"providerPaymentId": attempt.ProviderPaymentID,
+		"status":            bson.M{"$ne": paymentCaptured},
+	}
+	if attempt.ProviderPaymentID == "" {
+		// Without a payment ID there is nothing to deduplicate on
+		filter = bson.M{"_id": primitive.NewObjectID()}
+	} else {
+		// A payment already captured stays captured
+		captured, err := paymentColl.CountDocuments(ctx, bson.M{"providerPaymentId": attempt.ProviderPaymentID, "status": paymentCaptured})
+		if err == nil && captured > 0 {
+			return
+		}
+	}
+	_, err := paymentColl.UpdateOne(ctx, filter, bson.M{
+		"$set": bson.M{
+			"status":         paymentFailed,
+			"signature":      attempt.Signature,
+			"signatureValid": attempt.SignatureValid,
+			"failureCode":    code,
+			"failureReason":  reason,
+			"failedAt":       now,
+			"updatedAt":      now,
+		},
+		"$setOnInsert": bson.M{
+			"orderId":           order.ID,
+			"userId":            order.CustomerID,
+			"provider":          "razorpay",
+			"providerOrderId":   attempt.ProviderOrderID,
+			"providerPaymentId": attempt.ProviderPaymentID,
+			"amount":            attempt.Amount,
+			"createdAt":         now,
+		},
+	}, options.Update().SetUpsert(true))
+	if err != nil {
+		slog.Error("failed to record failed payment", "order", order.ID, "payment", attempt.ProviderPaymentID, "error", err)
+	}
+}
+
+// recordDirectPayment records a payment taken without a gateway: from the
+// wallet, at the counter or entirely in points.
+func recordDirectPayment(ctx context.Context, oid primitive.ObjectID, order model.Order) {
+	paymentColl := mongodb.GetCollection("smartcanteen", "payments")
+
+	amount := amountDue(order)
+	if order.PaymentMethod == "points" {
+		amount = order.PointsValue
+	}
+	now := time.Now().Unix()
+	_, err := paymentColl.InsertOne(ctx, model.Payment{
+		OrderID:           oid.Hex(),
+		UserID:            order.CustomerID,
+		Provider:          order.PaymentMethod,
+		ProviderPaymentID: order.CardReference,
+		Amount:            amount,
+		Status:            paymentCaptured,
+		CreatedAt:         now,
+		UpdatedAt:         now,
+		CapturedAt:        now,
+	})
+	if err != nil {
+		slog.Error("failed to record payment", "order", oid.Hex(), "error", err)
+	}
+}
+
+// refundPayments marks an order's captured payments refunded.
+func refundPayments(ctx context.Context, order model.Order, refundID string) {
+	paymentColl := mongodb.GetCollection("smartcanteen", "payments")
+
+	now := time.Now().Unix()
+	set := bson.M{"status": paymentRefunded, "refundedAt": now, "updatedAt": now}
+	if refundID != "" {
+		set["refundId"] = refundID
+	}
+	_, err := paymentColl.UpdateMany(ctx, bson.M{"orderId": order.ID, "status": paymentCaptured}, bson.M{"$set": set})
+	if err != nil {
+		slog.Error("failed to record refunded payment", "order", order.ID, "error", err)
+	}
+}
+
+// CompleteGatewayPayment records a captured gateway payment and completes its
+// order. It is for commands outside the HTTP handlers, such as payment
+// reconciliation, so the signature is not known.
+func CompleteGatewayPayment(ctx context.Context, oid primitive.ObjectID, providerOrderID, providerPaymentID string) (bool, error) {
+	orderColl := mongodb.GetCollection("smartcanteen", "orders")
+
+	var order model.Order
+	if err := orderColl.FindOne(ctx, bson.M{"_id": oid}).Decode(&order); err != nil {
+		return false, err
+	}
+
+	completed, err := completeOrder(ctx, oid, bson.M{
+		"razorpay_payment_id": providerPaymentID,
+		"razorpay_order_id":   providerOrderID,
+	})
+	if err != nil {
+		return false, err
+	}
+	capturePayment(ctx, order, gatewayAttempt{
+		ProviderOrderID:   providerOrderID,
+		ProviderPaymentID: providerPaymentID,
+		Amount:            amountDue(order),
+	})
+	return completed, nil
+}
+
+// callerOwnsOrder reports whether the caller placed the order, themselves or
+// as the device that took it.
+func callerOwnsOrder(c *gin.Context, order model.Order) bool {
+	if c.GetString("role") == middlewares.RoleDevice {
+		return order.DeviceID != "" && order.DeviceID == c.GetString("device_id")
+	}
+	return order.CustomerID != "" && order.CustomerID == c.GetString("user_id")
+}
+
+// PaymentFailed records a payment the checkout reported as failed, with the
+// gateway's error, so the customer can retry and staff can see why.
+func PaymentFailed(c *gin.Context) {
+	var body struct {
+		OrderID           string `json:"orderID" binding:"required"`
+		RazorpayOrderID   string `json:"razorpay_order_id"`
+		RazorpayPaymentID string `json:"razorpay_payment_id"`
+		Error             struct {
+			Code        string `json:"code"`
+			Description string `json:"description"`
+			Reason      string `json:"reason"`
+		} `json:"error"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	oid, err := primitive.ObjectIDFromHex(body.OrderID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
+
+	orderColl := mongodb.GetCollection("smartcanteen", "orders")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var order model.Order
+	err = orderColl.FindOne(ctx, bson.M{"_id": oid}).Decode(&order)
+	if err == mongo.ErrNoDocuments || (err == nil && !callerOwnsOrder(c, order)) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch order"})
+		return
+	}
+
+	providerOrderID := body.RazorpayOrderID
+	if providerOrderID == "" {
+		providerOrderID = order.RazorpayOrderID
+	}
+	reason := body.Error.Description
+	if body.Error.Reason != "" {
+		reason += " (" + body.Error.Reason + ")"
+	}
+	amount := amountDue(order)
+	if order.SplitMode != "" {
+		// Each share of a split order has its own gateway order
+		var share model.PaymentShare
+		shareColl := mongodb.GetCollection("smartcanteen", "payment_shares")
+		if shareColl.FindOne(ctx, bson.M{"orderId": order.ID, "razorpayOrderId": providerOrderID}).Decode(&share) == nil {
+			amount = share.Amount
+		}
+	}
+	failPayment(ctx, order, gatewayAttempt{
+		ProviderOrderID:   providerOrderID,
+		ProviderPaymentID: body.RazorpayPaymentID,
+		Amount:            amount,
+	}, body.Error.Code, reason)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Payment failure recorded"})
+}
+
+// GetOrderPayments lists every payment attempt on an order, oldest first.
+func GetOrderPayments(c *gin.Context) {
+	oid, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
+
+	orderColl := mongodb.GetCollection("smartcanteen", "orders")
+	paymentColl := mongodb.GetCollection("smartcanteen", "payments")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var order model.Order
+	err = orderColl.FindOne(ctx, bson.M{"_id": oid}).Decode(&order)
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch order"})
+		return
+	}
+
+	cursor, err := paymentColl.Find(ctx,
+		bson.M{"orderId": order.ID},
+		options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}}),
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch payments"})
+		return
+	}
+	payments := []model.Payment{}
+	if err := cursor.All(ctx, &payments); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch payments"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"order": gin.H{
+			"id":            order.ID,
+			"status":        order.Status,
+			"isPaid":        order.IsPaid,
+			"total":         order.Total,
+			"amountDue":     amountDue(order),
+			"paymentMethod": order.PaymentMethod,
+			"refundedAt":    order.RefundedAt,
+		},
+		"payments": payments,
+	})
+}
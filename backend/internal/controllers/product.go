@@ -1,8 +1,10 @@
 package controllers
 
 import (
+	"backend/internal/middleware"
 	"backend/internal/model"
 	mongodb "backend/internal/mogodb"
+	"backend/internal/tax"
 	"context"
 	"log/slog"
 	"net/http"
@@ -11,23 +13,27 @@ import (
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 func AddProduct(c *gin.Context) {
 	var input model.Product
-	if err := c.ShouldBindJSON(&input); err != nil {
+	if err := c.ShouldBindJSON(&input); err != nil || input.Price.Amount <= 0 {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
 		return
 	}
+	if !tax.ValidRate(input.Tax.Rate) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Tax rate must be a GST slab"})
+		return
+	}
+	if input.LoyaltyBonus < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Loyalty bonus cannot be negative"})
+		return
+	}
 
 	// Attach creator info (from JWT context)
 	createdBy, _ := c.Get("username")
 	input.CreatedBy, _ = createdBy.(string)
-	role, _ := c.Get("role")
-	if role != "admin" {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Only admins can add products"})
-		return
-	}
 
 	// Insert into MongoDB
 	collection := mongodb.GetCollection("smartcanteen", "products")
@@ -36,12 +42,15 @@ func AddProduct(c *gin.Context) {
 	defer cancel()
 
 	product := bson.M{
-		"name":        input.Name,
-		"description": input.Description,
-		"price":       input.Price,
-		"quantity":    input.Quantity,
-		"createdBy":   input.CreatedBy,
-		"createdAt":   time.Now(),
+		"name":         input.Name,
+		"description":  input.Description,
+		"price":        input.Price,
+		"tax":          input.Tax,
+		"category":     input.Category,
+		"loyaltyBonus": input.LoyaltyBonus,
+		"quantity":     input.Quantity,
+		"createdBy":    input.CreatedBy,
+		"createdAt":    time.Now(),
 	}
 
 	result, err := collection.InsertOne(ctx, product)
@@ -57,17 +66,26 @@ func AddProduct(c *gin.Context) {
 }
 
 func GetProducts(c *gin.Context) {
+	// Customers and kiosks only see what can be ordered right now
 	role, _ := c.Get("role")
-	if role != "admin" && role != "user" {
-		c.JSON(http.StatusForbidden, gin.H{"error": "No permission to see food products"})
-		return
-	}
 
 	collection := mongodb.GetCollection("smartcanteen", "products")
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
+	if role == "user" || role == middlewares.RoleDevice {
+		menu, err := findMenu(ctx, menuDate(time.Now()))
+		if err == nil {
+			getMenuProducts(c, ctx, menu)
+			return
+		}
+		if err != mongo.ErrNoDocuments {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch menu"})
+			return
+		}
+	}
+
 	var filter bson.M
 	if role == "user" {
 		filter = bson.M{"quantity": bson.M{"$gt": 0}}
@@ -91,6 +109,50 @@ func GetProducts(c *gin.Context) {
 	c.JSON(http.StatusOK, products)
 }
 
+// getMenuProducts lists the products on today's menu, with today's remaining
+// portions reported as the available quantity.
+func getMenuProducts(c *gin.Context, ctx context.Context, menu model.DailyMenu) {
+	collection := mongodb.GetCollection("smartcanteen", "products")
+
+	remaining := map[string]int{}
+	var ids []primitive.ObjectID
+	for _, item := range menu.Items {
+		if item.Remaining <= 0 {
+			continue
+		}
+		pid, err := primitive.ObjectIDFromHex(item.ProductID)
+		if err != nil {
+			continue
+		}
+		remaining[item.ProductID] = item.Remaining
+		ids = append(ids, pid)
+	}
+
+	products := []model.Product{}
+	if len(ids) == 0 {
+		c.JSON(http.StatusOK, products)
+		return
+	}
+
+	cursor, err := collection.Find(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch products"})
+		return
+	}
+	defer cursor.Close(ctx)
+
+	if err := cursor.All(ctx, &products); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decode products"})
+		return
+	}
+
+	for i := range products {
+		products[i].Quantity = remaining[products[i].ID]
+	}
+
+	c.JSON(http.StatusOK, products)
+}
+
 func EditProduct(c *gin.Context) {
 	id := c.Param("id")
 
@@ -102,11 +164,19 @@ func EditProduct(c *gin.Context) {
 	}
 
 	var input model.Product
-	if err := c.ShouldBindJSON(&input); err != nil {
+	if err := c.ShouldBindJSON(&input); err != nil || input.Price.Amount <= 0 {
 		slog.Error("invalid input")
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
 		return
 	}
+	if !tax.ValidRate(input.Tax.Rate) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Tax rate must be a GST slab"})
+		return
+	}
+	if input.LoyaltyBonus < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Loyalty bonus cannot be negative"})
+		return
+	}
 
 	collection := mongodb.GetCollection("smartcanteen", "products")
 
@@ -115,11 +185,14 @@ func EditProduct(c *gin.Context) {
 
 	update := bson.M{
 		"$set": bson.M{
-			"name":        input.Name,
-			"description": input.Description,
-			"price":       input.Price,
-			"quantity":    input.Quantity,
-			"updatedAt":   time.Now(),
+			"name":         input.Name,
+			"description":  input.Description,
+			"price":        input.Price,
+			"tax":          input.Tax,
+			"category":     input.Category,
+			"loyaltyBonus": input.LoyaltyBonus,
+			"quantity":     input.Quantity,
+			"updatedAt":    time.Now(),
 		},
 	}
 
@@ -0,0 +1,233 @@
+package controllers
+
+import (
+	"backend/internal/model"
+	mongodb "backend/internal/mogodb"
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var phonePattern = regexp.MustCompile(`^\+?[0-9]{7,15}$`)
+
+// profileView is what users see of their own account.
+func profileView(user model.User) gin.H {
+	return gin.H{
+		"id":            user.ID.Hex(),
+		"username":      user.Username,
+		"email":         user.Email,
+		"emailVerified": user.EmailVerified,
+		"phone":         user.Phone,
+		"notifications": user.Notifications,
+		"role":          user.Role,
+		"memberId":      user.MemberID,
+		"department":    user.Department,
+		"totpEnabled":   user.TOTPEnabled,
+	}
+}
+
+func GetProfile(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	user, ok := currentUser(c, ctx)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, profileView(user))
+}
+
+// UpdateProfile changes only the fields present in the body. A new email has
+// to be verified again, and a new username comes back with a fresh token so
+// the username claim matches.
+func UpdateProfile(c *gin.Context) {
+	var input struct {
+		Username      *string                     `json:"username"`
+		Email         *string                     `json:"email"`
+		Phone         *string                     `json:"phone"`
+		Notifications *model.NotificationSettings `json:"notifications"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	userColl := mongodb.GetCollection("smartcanteen", "users")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	user, ok := currentUser(c, ctx)
+	if !ok {
+		return
+	}
+
+	update := bson.M{}
+	renamed := false
+	emailChanged := false
+
+	if input.Username != nil {
+		username := strings.TrimSpace(*input.Username)
+		if username == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Username cannot be empty"})
+			return
+		}
+		if username != user.Username {
+			count, err := userColl.CountDocuments(ctx, bson.M{"username": username, "_id": bson.M{"$ne": user.ID}})
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update profile"})
+				return
+			}
+			if count > 0 {
+				c.JSON(http.StatusConflict, gin.H{"error": "Username already exists"})
+				return
+			}
+			update["username"] = username
+			user.Username = username
+			renamed = true
+		}
+	}
+
+	if input.Email != nil {
+		email := normalizeEmail(*input.Email)
+		if !strings.Contains(email, "@") {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid email address"})
+			return
+		}
+		if email != user.Email {
+			if !emailDomainAllowed(email) {
+				c.JSON(http.StatusForbidden, gin.H{"error": "Please use your institutional email address"})
+				return
+			}
+			count, err := userColl.CountDocuments(ctx, bson.M{"email": email, "_id": bson.M{"$ne": user.ID}})
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update profile"})
+				return
+			}
+			if count > 0 {
+				c.JSON(http.StatusConflict, gin.H{"error": "Email already registered"})
+				return
+			}
+			update["email"] = email
+			update["emailVerified"] = false
+			user.Email = email
+			user.EmailVerified = false
+			emailChanged = true
+		}
+	}
+
+	if input.Phone != nil {
+		phone := strings.ReplaceAll(strings.TrimSpace(*input.Phone), " ", "")
+		if phone != "" && !phonePattern.MatchString(phone) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid phone number"})
+			return
+		}
+		update["phone"] = phone
+		user.Phone = phone
+	}
+
+	if input.Notifications != nil {
+		update["notifications"] = *input.Notifications
+		user.Notifications = *input.Notifications
+	}
+
+	if len(update) > 0 {
+		_, err := userColl.UpdateByID(ctx, user.ID, bson.M{"$set": update})
+		if mongo.IsDuplicateKeyError(err) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Username or email already in use"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update profile"})
+			return
+		}
+	}
+
+	response := gin.H{
+		"message": "Profile updated successfully",
+		"profile": profileView(user),
+	}
+
+	if emailChanged {
+		if err := issueEmailVerification(ctx, user); err != nil {
+			slog.Error("failed to send verification email", "error", err)
+		}
+		response["message"] = "Profile updated, please verify your new email address"
+	}
+
+	if renamed {
+		token, err := signAccessToken(user, c.GetString("session_id"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+			return
+		}
+		response["token"] = token
+		response["expires_in"] = int(accessTokenTTL.Seconds())
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// ChangePassword needs the current password. Every other session is logged
+// out; the one making the change stays signed in.
+func ChangePassword(c *gin.Context) {
+	var input struct {
+		CurrentPassword string `json:"current_password" binding:"required"`
+		NewPassword     string `json:"new_password" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	if len(input.NewPassword) < minPasswordLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Password must be at least %d characters", minPasswordLength)})
+		return
+	}
+
+	userColl := mongodb.GetCollection("smartcanteen", "users")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	user, ok := currentUser(c, ctx)
+	if !ok {
+		return
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(input.CurrentPassword)); err != nil {
+		writeAuthEvent(ctx, c, "password_change_failure", user.ID.Hex(), user.Email, "invalid_credentials")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Current password is incorrect"})
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(input.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change password"})
+		return
+	}
+
+	_, err = userColl.UpdateByID(ctx, user.ID, bson.M{"$set": bson.M{"password": string(hashedPassword)}})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change password"})
+		return
+	}
+
+	if err := revokeOtherSessions(ctx, user.ID.Hex(), c.GetString("session_id"), "password_changed"); err != nil {
+		slog.Error("failed to revoke sessions after password change", "error", err)
+	}
+
+	writeAuthEvent(ctx, c, "password_changed", user.ID.Hex(), user.Email, "")
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password changed successfully"})
+}
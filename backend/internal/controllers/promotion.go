@@ -0,0 +1,417 @@
+package controllers
+
+import (
+	"backend/internal/model"
+	mongodb "backend/internal/mogodb"
+	"backend/internal/promo"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// couponError is a coupon the customer can't use, with the reason to show
+// them.
+type couponError struct {
+	error
+}
+
+func normalizeCouponCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+func promoLine(product model.Product, quantity int) promo.Line {
+	return promo.Line{
+		ProductID: product.ID,
+		Category:  product.Category,
+		UnitPrice: product.Price,
+		Quantity:  quantity,
+	}
+}
+
+// promoCustomer gathers what promotion rules need to know about a customer.
+// An empty userID is an anonymous customer.
+func promoCustomer(ctx context.Context, userID string) (promo.Customer, error) {
+	customer := promo.Customer{UserID: userID, Uses: map[string]int{}}
+	if userID == "" {
+		return customer, nil
+	}
+
+	orderColl := mongodb.GetCollection("smartcanteen", "orders")
+	redemptionColl := mongodb.GetCollection("smartcanteen", "promo_redemptions")
+
+	paid, err := orderColl.CountDocuments(ctx, bson.M{"customerId": userID, "isPaid": true})
+	if err != nil {
+		return customer, err
+	}
+	customer.FirstOrder = paid == 0
+
+	cursor, err := redemptionColl.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"userId": userID}}},
+		{{Key: "$group", Value: bson.M{"_id": "$promotionId", "uses": bson.M{"$sum": 1}}}},
+	})
+	if err != nil {
+		return customer, err
+	}
+	defer cursor.Close(ctx)
+
+	var uses []struct {
+		PromotionID string `bson:"_id"`
+		Uses        int    `bson:"uses"`
+	}
+	if err := cursor.All(ctx, &uses); err != nil {
+		return customer, err
+	}
+	for _, u := range uses {
+		customer.Uses[u.PromotionID] = u.Uses
+	}
+	return customer, nil
+}
+
+// cartCoupon is the coupon code the user has applied to their cart, if any.
+func cartCoupon(ctx context.Context, userID string) string {
+	couponColl := mongodb.GetCollection("smartcanteen", "cart_coupons")
+
+	var coupon struct {
+		Code string `bson:"code"`
+	}
+	if err := couponColl.FindOne(ctx, bson.M{"_id": userID}).Decode(&coupon); err != nil {
+		return ""
+	}
+	return coupon.Code
+}
+
+// applyPromotions works out the discounts on lines: every automatic
+// promotion the customer qualifies for, then the coupon, if given. A coupon
+// that can't be used is reported as a couponError.
+func applyPromotions(ctx context.Context, userID, code string, lines []promo.Line) (promo.Result, error) {
+	promoColl := mongodb.GetCollection("smartcanteen", "promotions")
+
+	customer, err := promoCustomer(ctx, userID)
+	if err != nil {
+		return promo.Result{}, err
+	}
+
+	cursor, err := promoColl.Find(ctx, bson.M{"active": true, "code": bson.M{"$exists": false}})
+	if err != nil {
+		return promo.Result{}, err
+	}
+	var promotions []model.Promotion
+	if err := cursor.All(ctx, &promotions); err != nil {
+		return promo.Result{}, err
+	}
+
+	now := time.Now()
+	if code != "" {
+		var coupon model.Promotion
+		err := promoColl.FindOne(ctx, bson.M{"code": normalizeCouponCode(code)}).Decode(&coupon)
+		if err == mongo.ErrNoDocuments {
+			return promo.Result{}, couponError{errors.New("this coupon code is not valid")}
+		}
+		if err != nil {
+			return promo.Result{}, err
+		}
+		if err := promo.Eligible(coupon, lines, customer, now); err != nil {
+			return promo.Result{}, couponError{err}
+		}
+		promotions = append(promotions, coupon)
+	}
+
+	return promo.Apply(promotions, lines, customer, now), nil
+}
+
+// errPromotionUsedUp is a promotion that reached one of its limits between
+// pricing an order and placing it.
+var errPromotionUsedUp = errors.New("promotion has reached its limit")
+
+// takePromotions counts the promotions a newly saved order used against
+// their limits, so pending orders can't use more than the limits allow
+// between them. It takes all of them or none.
+func takePromotions(ctx context.Context, order model.Order) error {
+	promoColl := mongodb.GetCollection("smartcanteen", "promotions")
+	redemptionColl := mongodb.GetCollection("smartcanteen", "promo_redemptions")
+
+	for i, discount := range order.Discounts {
+		var promotion model.Promotion
+		pid, _ := primitive.ObjectIDFromHex(discount.PromotionID)
+		err := promoColl.FindOneAndUpdate(ctx, bson.M{
+			"_id": pid,
+			"$expr": bson.M{"$or": bson.A{
+				bson.M{"$lte": bson.A{bson.M{"$ifNull": bson.A{"$totalLimit", 0}}, 0}},
+				bson.M{"$lt": bson.A{"$redemptions", "$totalLimit"}},
+			}},
+		}, bson.M{"$inc": bson.M{"redemptions": 1}}).Decode(&promotion)
+		if err == mongo.ErrNoDocuments {
+			err = errPromotionUsedUp
+		}
+		if err == nil {
+			// A customer has one first order, so the unique index on
+			// first-order redemptions lets only one order have it
+			_, err = redemptionColl.InsertOne(ctx, model.PromoRedemption{
+				PromotionID: discount.PromotionID,
+				UserID:      order.CustomerID,
+				OrderID:     order.ID,
+				Amount:      discount.Amount,
+				FirstOrder:  promotion.FirstOrderOnly,
+				CreatedAt:   time.Now().Unix(),
+			})
+			if mongo.IsDuplicateKeyError(err) {
+				err = errPromotionUsedUp
+			}
+			if err != nil {
+				promoColl.UpdateByID(ctx, pid, bson.M{"$inc": bson.M{"redemptions": -1}})
+			}
+		}
+		if err == nil && promotion.PerUserLimit > 0 {
+			// Counted after the redemption is written, so of two orders
+			// racing for the last use neither gets more than the limit
+			var uses int64
+			uses, err = redemptionColl.CountDocuments(ctx, bson.M{"userId": order.CustomerID, "promotionId": discount.PromotionID})
+			if err == nil && uses > int64(promotion.PerUserLimit) {
+				err = errPromotionUsedUp
+			}
+			if err != nil {
+				order.Discounts = order.Discounts[:i+1]
+				releasePromotions(ctx, order)
+				return err
+			}
+		}
+		if err != nil {
+			order.Discounts = order.Discounts[:i]
+			releasePromotions(ctx, order)
+			return err
+		}
+	}
+	return nil
+}
+
+// releasePromotions gives back the uses an order took of its promotions.
+func releasePromotions(ctx context.Context, order model.Order) {
+	promoColl := mongodb.GetCollection("smartcanteen", "promotions")
+	redemptionColl := mongodb.GetCollection("smartcanteen", "promo_redemptions")
+
+	for _, discount := range order.Discounts {
+		res, err := redemptionColl.DeleteOne(ctx, bson.M{"orderId": order.ID, "promotionId": discount.PromotionID})
+		if err != nil {
+			slog.Error("failed to release promotion redemption", "order", order.ID, "promotion", discount.PromotionID, "error", err)
+			continue
+		}
+		if res.DeletedCount == 0 {
+			continue
+		}
+
+		pid, _ := primitive.ObjectIDFromHex(discount.PromotionID)
+		if _, err := promoColl.UpdateByID(ctx, pid, bson.M{"$inc": bson.M{"redemptions": -1}}); err != nil {
+			slog.Error("failed to release promotion use", "promotion", discount.PromotionID, "error", err)
+		}
+	}
+}
+
+// cartPromoLines reads the user's cart as promotion lines.
+func cartPromoLines(ctx context.Context, uid primitive.ObjectID) ([]promo.Line, error) {
+	cartColl := mongodb.GetCollection("smartcanteen", "addtocart")
+	productColl := mongodb.GetCollection("smartcanteen", "products")
+
+	cursor, err := cartColl.Find(ctx, bson.M{"user_id": uid})
+	if err != nil {
+		return nil, err
+	}
+	var cartItems []struct {
+		ProductID primitive.ObjectID `bson:"product_id"`
+		Quantity  int                `bson:"quantity"`
+	}
+	if err := cursor.All(ctx, &cartItems); err != nil {
+		return nil, err
+	}
+
+	var lines []promo.Line
+	for _, item := range cartItems {
+		var product model.Product
+		if err := productColl.FindOne(ctx, bson.M{"_id": item.ProductID}).Decode(&product); err != nil {
+			continue
+		}
+		lines = append(lines, promoLine(product, item.Quantity))
+	}
+	return lines, nil
+}
+
+// ApplyCartCoupon checks a coupon code against the caller's cart and keeps
+// it for when they order.
+func ApplyCartCoupon(c *gin.Context) {
+	userID, ok := cartOwner(c)
+	if !ok {
+		return
+	}
+	uid, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID format"})
+		return
+	}
+
+	var input struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Coupon code is required"})
+		return
+	}
+	code := normalizeCouponCode(input.Code)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	lines, err := cartPromoLines(ctx, uid)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch cart"})
+		return
+	}
+
+	result, err := applyPromotions(ctx, userID, code, lines)
+	var invalid couponError
+	if errors.As(err, &invalid) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": invalid.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check coupon"})
+		return
+	}
+
+	couponColl := mongodb.GetCollection("smartcanteen", "cart_coupons")
+	_, err = couponColl.ReplaceOne(ctx, bson.M{"_id": userID}, bson.M{
+		"code":      code,
+		"appliedAt": time.Now().Unix(),
+	}, options.Replace().SetUpsert(true))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to apply coupon"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":       "Coupon applied",
+		"code":          code,
+		"discounts":     result.Applied,
+		"discountTotal": result.Total,
+	})
+}
+
+// RemoveCartCoupon takes the coupon off the caller's cart.
+func RemoveCartCoupon(c *gin.Context) {
+	userID, ok := cartOwner(c)
+	if !ok {
+		return
+	}
+
+	couponColl := mongodb.GetCollection("smartcanteen", "cart_coupons")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := couponColl.DeleteOne(ctx, bson.M{"_id": userID}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove coupon"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Coupon removed"})
+}
+
+// CreatePromotion adds a promotion. Without a code it applies automatically
+// to every order it qualifies for.
+func CreatePromotion(c *gin.Context) {
+	var input model.Promotion
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+	if err := promo.Validate(input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	input.ID = ""
+	input.Code = normalizeCouponCode(input.Code)
+	input.Redemptions = 0
+	input.Active = true
+	input.CreatedBy = c.GetString("username")
+	input.CreatedAt = time.Now().Unix()
+
+	promoColl := mongodb.GetCollection("smartcanteen", "promotions")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := promoColl.InsertOne(ctx, input)
+	if mongo.IsDuplicateKeyError(err) {
+		c.JSON(http.StatusConflict, gin.H{"error": "A promotion with this code already exists"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create promotion"})
+		return
+	}
+	input.ID = res.InsertedID.(primitive.ObjectID).Hex()
+
+	writeAudit(ctx, c, "promotion_create", "promotion", input.ID, map[string]interface{}{
+		"name": input.Name,
+		"code": input.Code,
+		"type": input.Type,
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Promotion created", "promotion": input})
+}
+
+// ListPromotions lists every promotion, newest first.
+func ListPromotions(c *gin.Context) {
+	promoColl := mongodb.GetCollection("smartcanteen", "promotions")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cursor, err := promoColl.Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"createdAt": -1}))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch promotions"})
+		return
+	}
+
+	promotions := []model.Promotion{}
+	if err := cursor.All(ctx, &promotions); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse promotions"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"promotions": promotions})
+}
+
+// DeactivatePromotion stops a promotion applying. It is kept, since paid
+// orders refer to it.
+func DeactivatePromotion(c *gin.Context) {
+	pid, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid promotion ID"})
+		return
+	}
+
+	promoColl := mongodb.GetCollection("smartcanteen", "promotions")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := promoColl.UpdateByID(ctx, pid, bson.M{"$set": bson.M{"active": false}})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to deactivate promotion"})
+		return
+	}
+	if res.MatchedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Promotion not found"})
+		return
+	}
+
+	writeAudit(ctx, c, "promotion_deactivate", "promotion", pid.Hex(), nil)
+	c.JSON(http.StatusOK, gin.H{"message": "Promotion deactivated"})
+}
@@ -0,0 +1,73 @@
+package controllers
+
+import (
+	"backend/internal/model"
+	mongodb "backend/internal/mogodb"
+	"backend/internal/receipt"
+	"context"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// invoiceSeller is the business named on receipts, from INVOICE_SELLER_NAME,
+// INVOICE_SELLER_ADDRESS and INVOICE_GSTIN.
+func invoiceSeller() receipt.Seller {
+	name := os.Getenv("INVOICE_SELLER_NAME")
+	if name == "" {
+		name = "Smart Canteen"
+	}
+	return receipt.Seller{
+		Name:    name,
+		Address: os.Getenv("INVOICE_SELLER_ADDRESS"),
+		GSTIN:   os.Getenv("INVOICE_GSTIN"),
+	}
+}
+
+// GetOrderReceipt serves the receipt for one of the caller's own orders.
+func GetOrderReceipt(c *gin.Context) {
+	sendReceipt(c, bson.M{"customerId": c.GetString("user_id")})
+}
+
+// GetOrderReceiptAdmin serves the receipt for any order.
+func GetOrderReceiptAdmin(c *gin.Context) {
+	sendReceipt(c, bson.M{})
+}
+
+func sendReceipt(c *gin.Context, filter bson.M) {
+	oid, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
+	filter["_id"] = oid
+
+	orderColl := mongodb.GetCollection("smartcanteen", "orders")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var order model.Order
+	err = orderColl.FindOne(ctx, filter).Decode(&order)
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch order"})
+		return
+	}
+	if !order.IsPaid {
+		c.JSON(http.StatusConflict, gin.H{"error": "Receipts are only available for paid orders"})
+		return
+	}
+
+	name := "receipt-" + order.ID + ".pdf"
+	c.Header("Content-Disposition", `inline; filename="`+name+`"`)
+	c.Data(http.StatusOK, "application/pdf", receipt.Render(order, invoiceSeller()))
+}
@@ -0,0 +1,127 @@
+package controllers
+
+import (
+	"backend/internal/model"
+	mongodb "backend/internal/mogodb"
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// refundPayment returns the money paid for an order the way it was paid.
+// Cash comes out of the refunding cashier's drawer; card payments taken on
+// the terminal are refunded there. Points are handled separately by
+// reverseLoyalty. It returns the gateway's refund ID, if there is one.
+func refundPayment(ctx context.Context, order model.Order, refunderID string) (string, error) {
+	amount := amountDue(order).Amount
+	if amount == 0 {
+		return "", nil
+	}
+
+	switch order.PaymentMethod {
+	case "wallet":
+		return "", creditWallet(ctx, order.CustomerID, amount, accountSales, "order_refund", order.ID)
+	case "cash":
+		shift, err := openShift(ctx, refunderID)
+		if err != nil {
+			return "", err
+		}
+		return "", addToShift(ctx, shift.ID, "cashRefunds", amount)
+	case "card":
+		return "", nil
+	default:
+		if order.SplitMode != "" {
+			return "", refundSplit(ctx, order)
+		}
+		refund, err := razorpayClient().Payment.Refund(order.RazorpayPaymentID, int(amount), map[string]interface{}{
+			"receipt": order.ID,
+		}, nil)
+		if err != nil {
+			return "", err
+		}
+		refundID, _ := refund["id"].(string)
+		return refundID, nil
+	}
+}
+
+// RefundOrder refunds a paid order in full, reverses the loyalty points it
+// earned and spent and gives back any meals it took from a plan.
+func RefundOrder(c *gin.Context) {
+	oid, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
+
+	orderColl := mongodb.GetCollection("smartcanteen", "orders")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Marking the order refunded first means a second request can't refund
+	// it again while the first is still talking to the gateway
+	var order model.Order
+	err = orderColl.FindOneAndUpdate(
+		ctx,
+		bson.M{"_id": oid, "isPaid": true, "refundedAt": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{
+			"status":     "Refunded",
+			"refundedAt": time.Now().Unix(),
+			"refundedBy": c.GetString("username"),
+		}},
+	).Decode(&order)
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusConflict, gin.H{"error": "Order is not paid or has already been refunded"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch order"})
+		return
+	}
+
+	refundID, err := refundPayment(ctx, order, c.GetString("user_id"))
+	if err != nil {
+		slog.Error("failed to refund order", "order", order.ID, "error", err)
+		_, undoErr := orderColl.UpdateByID(ctx, oid, bson.M{
+			"$set":   bson.M{"status": order.Status},
+			"$unset": bson.M{"refundedAt": "", "refundedBy": ""},
+		})
+		if undoErr != nil {
+			slog.Error("failed to undo refund status", "order", order.ID, "error", undoErr)
+		}
+		if err == errNoOpenShift {
+			c.JSON(http.StatusConflict, gin.H{"error": "Open a shift to refund cash from your drawer"})
+			return
+		}
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to refund payment"})
+		return
+	}
+
+	// A split order's shares are refunded one by one later, and refundShare
+	// marks each share's payment when its refund is made
+	if order.SplitMode == "" {
+		refundPayments(ctx, order, refundID)
+	}
+	reverseLoyalty(ctx, order)
+	reverseMeals(ctx, order)
+
+	writeAudit(ctx, c, "order_refund", "order", order.ID, map[string]interface{}{
+		"amount":         amountDue(order),
+		"paymentMethod":  order.PaymentMethod,
+		"pointsEarned":   order.PointsEarned,
+		"pointsRedeemed": order.PointsRedeemed,
+		"mealsCovered":   order.MealsCovered,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Order refunded",
+		"orderID": order.ID,
+		"amount":  amountDue(order),
+	})
+}
@@ -0,0 +1,97 @@
+package controllers
+
+import (
+	"backend/internal/model"
+	mongodb "backend/internal/mogodb"
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// dayRange returns the unix start and end of the given YYYY-MM-DD day in
+// local time. An empty date means today.
+func dayRange(date string) (int64, int64, error) {
+	day := time.Now()
+	if date != "" {
+		parsed, err := time.ParseInLocation("2006-01-02", date, time.Local)
+		if err != nil {
+			return 0, 0, err
+		}
+		day = parsed
+	}
+
+	start := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.Local)
+	end := start.AddDate(0, 0, 1)
+	return start.Unix(), end.Unix(), nil
+}
+
+func GetDailyReport(c *gin.Context) {
+	start, end, err := dayRange(c.Query("date"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid date, expected YYYY-MM-DD"})
+		return
+	}
+
+	orderColl := mongodb.GetCollection("smartcanteen", "orders")
+	wastageColl := mongodb.GetCollection("smartcanteen", "wastage")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	period := bson.M{"$gte": start, "$lt": end}
+
+	cursor, err := orderColl.Find(ctx, bson.M{"createdAt": period, "isPaid": true})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch orders"})
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var orders []model.Order
+	if err := cursor.All(ctx, &orders); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse orders"})
+		return
+	}
+
+	sales := model.Paise(0)
+	gst := model.Paise(0)
+	for _, order := range orders {
+		sales = sales.Add(order.Total)
+		gst = gst.Add(order.TaxTotal)
+	}
+
+	wastageCursor, err := wastageColl.Find(ctx, bson.M{"createdAt": period})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch wastage"})
+		return
+	}
+	defer wastageCursor.Close(ctx)
+
+	var wastage []model.Wastage
+	if err := wastageCursor.All(ctx, &wastage); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse wastage"})
+		return
+	}
+
+	// Wastage is valued at selling price, so it is what the waste would
+	// have sold for rather than a loss against sales
+	wastageValue := model.Paise(0)
+	byReason := map[string]model.Money{}
+	for _, entry := range wastage {
+		wastageValue = wastageValue.Add(entry.Value)
+		byReason[entry.Reason] = byReason[entry.Reason].Add(entry.Value)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"date":            time.Unix(start, 0).Format("2006-01-02"),
+		"ordersPaid":      len(orders),
+		"sales":           sales,
+		"gstCollected":    gst,
+		"wastageEntries":  len(wastage),
+		"wastageValue":    wastageValue,
+		"wastageByReason": byReason,
+	})
+}
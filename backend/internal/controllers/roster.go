@@ -0,0 +1,190 @@
+package controllers
+
+import (
+	"backend/internal/model"
+	mongodb "backend/internal/mogodb"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var errNotOnRoster = errors.New("not on roster")
+
+// allowedEmailDomains comes from ALLOWED_EMAIL_DOMAINS, a comma separated
+// list. An empty list allows every domain.
+func allowedEmailDomains() []string {
+	var domains []string
+	for _, d := range strings.Split(os.Getenv("ALLOWED_EMAIL_DOMAINS"), ",") {
+		d = strings.ToLower(strings.TrimSpace(d))
+		if d != "" {
+			domains = append(domains, d)
+		}
+	}
+	return domains
+}
+
+// emailDomainAllowed accepts the listed domains and their subdomains, so
+// "college.edu" also admits "cs.college.edu".
+func emailDomainAllowed(email string) bool {
+	domains := allowedEmailDomains()
+	if len(domains) == 0 {
+		return true
+	}
+
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+	domain := strings.ToLower(email[at+1:])
+	for _, d := range domains {
+		if domain == d || strings.HasSuffix(domain, "."+d) {
+			return true
+		}
+	}
+	return false
+}
+
+func rosterRequired() bool {
+	return os.Getenv("SIGNUP_REQUIRE_ROSTER") == "true"
+}
+
+// claimRosterEntry ties a roster entry to a new account, so each student or
+// employee ID can only register once.
+func claimRosterEntry(ctx context.Context, memberID, email, userID string) (model.RosterEntry, error) {
+	rosterColl := mongodb.GetCollection("smartcanteen", "roster")
+
+	var entry model.RosterEntry
+	err := rosterColl.FindOneAndUpdate(
+		ctx,
+		bson.M{"memberId": memberID, "claimedBy": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"claimedBy": userID}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&entry)
+	if err == mongo.ErrNoDocuments {
+		return entry, errNotOnRoster
+	}
+	if err != nil {
+		return entry, err
+	}
+
+	// A roster row with an email only admits that address
+	if entry.Email != "" && !strings.EqualFold(entry.Email, email) {
+		releaseRosterEntry(ctx, memberID)
+		return entry, errNotOnRoster
+	}
+	return entry, nil
+}
+
+func releaseRosterEntry(ctx context.Context, memberID string) {
+	rosterColl := mongodb.GetCollection("smartcanteen", "roster")
+	rosterColl.UpdateOne(ctx, bson.M{"memberId": memberID}, bson.M{"$unset": bson.M{"claimedBy": ""}})
+}
+
+// ImportRoster upserts roster rows from a CSV upload (multipart field "file")
+// or a text/csv body. The header row names the columns: memberId is required,
+// name, department, kind and email are optional.
+func ImportRoster(c *gin.Context) {
+	var reader io.Reader = c.Request.Body
+	if file, err := c.FormFile("file"); err == nil {
+		f, err := file.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read upload"})
+			return
+		}
+		defer f.Close()
+		reader = f
+	}
+
+	r := csv.NewReader(reader)
+	r.TrimLeadingSpace = true
+
+	header, err := r.Read()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "CSV header row is required"})
+		return
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.TrimSpace(name)] = i
+	}
+	if _, ok := columns["memberId"]; !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "CSV must have a memberId column"})
+		return
+	}
+	field := func(row []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(row) {
+			return strings.TrimSpace(row[i])
+		}
+		return ""
+	}
+
+	rosterColl := mongodb.GetCollection("smartcanteen", "roster")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	imported := 0
+	var rowErrors []string
+	for line := 2; ; line++ {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			rowErrors = append(rowErrors, fmt.Sprintf("line %d: %v", line, err))
+			continue
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read upload"})
+			return
+		}
+
+		memberID := field(row, "memberId")
+		if memberID == "" {
+			rowErrors = append(rowErrors, fmt.Sprintf("line %d: missing memberId", line))
+			continue
+		}
+
+		_, err = rosterColl.UpdateOne(
+			ctx,
+			bson.M{"memberId": memberID},
+			bson.M{"$set": bson.M{
+				"memberId":   memberID,
+				"name":       field(row, "name"),
+				"department": field(row, "department"),
+				"kind":       field(row, "kind"),
+				"email":      strings.ToLower(field(row, "email")),
+				"importedAt": time.Now().Unix(),
+			}},
+			options.Update().SetUpsert(true),
+		)
+		if err != nil {
+			rowErrors = append(rowErrors, fmt.Sprintf("line %d: %v", line, err))
+			continue
+		}
+		imported++
+	}
+
+	writeAudit(ctx, c, "roster.imported", "roster", "", map[string]interface{}{
+		"imported": imported,
+		"errors":   len(rowErrors),
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "Roster imported",
+		"imported": imported,
+		"errors":   rowErrors,
+	})
+}
@@ -0,0 +1,236 @@
+package controllers
+
+import (
+	"backend/internal/auth"
+	"backend/internal/model"
+	mongodb "backend/internal/mogodb"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	accessTokenTTL  = 15 * time.Minute
+	refreshTokenTTL = 30 * 24 * time.Hour
+)
+
+// randomToken returns a hex encoded random secret suitable for handing to
+// clients. Only its hash is ever stored.
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func signAccessToken(user model.User, sessionID string) (string, error) {
+	return auth.Keys.Sign(jwt.MapClaims{
+		"username": user.Username,
+		"role":     user.Role,
+		"user_id":  user.ID.Hex(),
+		"sid":      sessionID,
+		"exp":      time.Now().Add(accessTokenTTL).Unix(),
+	})
+}
+
+// startSession records a new server-side session for the user and returns
+// its access token and refresh token.
+func startSession(ctx context.Context, c *gin.Context, user model.User) (string, string, error) {
+	refreshToken, err := randomToken()
+	if err != nil {
+		return "", "", err
+	}
+
+	now := time.Now()
+	session := model.Session{
+		UserID:      user.ID.Hex(),
+		RefreshHash: hashToken(refreshToken),
+		UserAgent:   c.Request.UserAgent(),
+		IP:          c.ClientIP(),
+		CreatedAt:   now.Unix(),
+		LastUsedAt:  now.Unix(),
+		ExpiresAt:   now.Add(refreshTokenTTL).Unix(),
+	}
+
+	sessionColl := mongodb.GetCollection("smartcanteen", "sessions")
+	res, err := sessionColl.InsertOne(ctx, session)
+	if err != nil {
+		return "", "", err
+	}
+
+	accessToken, err := signAccessToken(user, res.InsertedID.(primitive.ObjectID).Hex())
+	if err != nil {
+		return "", "", err
+	}
+	return accessToken, refreshToken, nil
+}
+
+// revokeUserSessions ends every active session of a user, e.g. after a
+// password change.
+func revokeUserSessions(ctx context.Context, userID string, reason string) error {
+	sessionColl := mongodb.GetCollection("smartcanteen", "sessions")
+	_, err := sessionColl.UpdateMany(
+		ctx,
+		bson.M{"userId": userID, "revoked": false},
+		bson.M{"$set": bson.M{
+			"revoked":       true,
+			"revokedReason": reason,
+			"revokedAt":     time.Now().Unix(),
+		}},
+	)
+	return err
+}
+
+// revokeOtherSessions is revokeUserSessions sparing the caller's own session.
+func revokeOtherSessions(ctx context.Context, userID string, keepSessionID string, reason string) error {
+	filter := bson.M{"userId": userID, "revoked": false}
+	if oid, err := primitive.ObjectIDFromHex(keepSessionID); err == nil {
+		filter["_id"] = bson.M{"$ne": oid}
+	}
+
+	sessionColl := mongodb.GetCollection("smartcanteen", "sessions")
+	_, err := sessionColl.UpdateMany(
+		ctx,
+		filter,
+		bson.M{"$set": bson.M{
+			"revoked":       true,
+			"revokedReason": reason,
+			"revokedAt":     time.Now().Unix(),
+		}},
+	)
+	return err
+}
+
+func RefreshToken(c *gin.Context) {
+	var input struct {
+		RefreshToken string `json:"refresh_token" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	sessionColl := mongodb.GetCollection("smartcanteen", "sessions")
+	userColl := mongodb.GetCollection("smartcanteen", "users")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	newRefreshToken, err := randomToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	now := time.Now().Unix()
+	presented := hashToken(input.RefreshToken)
+
+	// Rotate: the presented refresh token is only ever accepted once
+	var session model.Session
+	err = sessionColl.FindOneAndUpdate(
+		ctx,
+		bson.M{"refreshHash": presented, "revoked": false, "expiresAt": bson.M{"$gt": now}},
+		bson.M{"$set": bson.M{
+			"refreshHash":  hashToken(newRefreshToken),
+			"previousHash": presented,
+			"lastUsedAt":   now,
+		}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&session)
+	if err == mongo.ErrNoDocuments {
+		// A rotated-out token coming back means it was copied; end that session
+		res, _ := sessionColl.UpdateOne(
+			ctx,
+			bson.M{"previousHash": presented, "revoked": false},
+			bson.M{"$set": bson.M{"revoked": true, "revokedReason": "refresh_token_reuse", "revokedAt": now}},
+		)
+		if res != nil && res.ModifiedCount > 0 {
+			slog.Warn("refresh token reuse detected, session revoked")
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refresh session"})
+		return
+	}
+
+	uid, err := primitive.ObjectIDFromHex(session.UserID)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+		return
+	}
+
+	var user model.User
+	if err := userColl.FindOne(ctx, bson.M{"_id": uid}).Decode(&user); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+		return
+	}
+	if user.Disabled {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Account disabled"})
+		return
+	}
+	// Sessions are revoked when a reset is forced, but should that have
+	// failed they still can't outlive it
+	if user.PasswordResetRequired {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Password reset required, please check your email"})
+		return
+	}
+
+	accessToken, err := signAccessToken(user, session.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"token":         accessToken,
+		"refresh_token": newRefreshToken,
+		"expires_in":    int(accessTokenTTL.Seconds()),
+	})
+}
+
+func Logout(c *gin.Context) {
+	sessionID, _ := c.Get("session_id")
+	sid, _ := sessionID.(string)
+
+	oid, err := primitive.ObjectIDFromHex(sid)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session"})
+		return
+	}
+
+	sessionColl := mongodb.GetCollection("smartcanteen", "sessions")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err = sessionColl.UpdateByID(ctx, oid, bson.M{"$set": bson.M{
+		"revoked":       true,
+		"revokedReason": "logout",
+		"revokedAt":     time.Now().Unix(),
+	}})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log out"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out successfully"})
+}
@@ -4,20 +4,41 @@ import (
 	"backend/internal/model"
 	database "backend/internal/mogodb"
 	"context"
+	"log/slog"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// normalizeEmail is the form emails are stored and looked up in, so
+// accounts can't be duplicated or missed by case or stray spaces.
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
 func Signup(c *gin.Context) {
 	var input model.User
 	if err := c.ShouldBindJSON(&input); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
 		return
 	}
+	input.Email = normalizeEmail(input.Email)
+
+	if !emailDomainAllowed(input.Email) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Please sign up with your institutional email address"})
+		return
+	}
+	input.MemberID = strings.TrimSpace(input.MemberID)
+	if rosterRequired() && input.MemberID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Student or employee ID is required"})
+		return
+	}
 
 	userCollection := database.GetCollection("smartcanteen", "users")
 
@@ -29,22 +50,64 @@ func Signup(c *gin.Context) {
 		c.JSON(http.StatusConflict, gin.H{"error": "Username already exists"})
 		return
 	}
+	count, _ = userCollection.CountDocuments(ctx, bson.M{"email": input.Email})
+	if count > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Email already registered"})
+		return
+	}
 
 	// Hash password
 	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte(input.Password), bcrypt.DefaultCost)
 
 	// Save user
 	newUser := model.User{
-		Username: input.Username,
-		Email:    input.Email,
-		Password: string(hashedPassword),
-		Role:     "user", // default role
+		ID:            primitive.NewObjectID(),
+		Username:      input.Username,
+		Email:         input.Email,
+		Password:      string(hashedPassword),
+		Role:          "user", // default role
+		EmailVerified: false,
+		MemberID:      input.MemberID,
+	}
+
+	// Check the ID against the roster and take it for this account
+	claimed := false
+	if input.MemberID != "" {
+		entry, err := claimRosterEntry(ctx, input.MemberID, input.Email, newUser.ID.Hex())
+		switch {
+		case err == nil:
+			claimed = true
+			newUser.Department = entry.Department
+		case err == errNotOnRoster && rosterRequired():
+			c.JSON(http.StatusForbidden, gin.H{"error": "Student or employee ID not recognised or already registered"})
+			return
+		case err == errNotOnRoster:
+			// An ID that isn't on the roster, or belongs to someone else,
+			// isn't kept, so it can't stand in for a verified one
+			newUser.MemberID = ""
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error saving user"})
+			return
+		}
 	}
+
 	_, err := userCollection.InsertOne(ctx, newUser)
+	if err != nil && claimed {
+		releaseRosterEntry(ctx, input.MemberID)
+	}
+	if mongo.IsDuplicateKeyError(err) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Email already registered"})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error saving user"})
 		return
 	}
 
-	c.JSON(http.StatusCreated, gin.H{"message": "User registered successfully"})
+	// The account exists either way; the link can be resent if this fails
+	if err := issueEmailVerification(ctx, newUser); err != nil {
+		slog.Error("failed to send verification email", "error", err)
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"message": "User registered successfully, please check your email to verify your account"})
 }
@@ -0,0 +1,478 @@
+package controllers
+
+import (
+	"backend/internal/model"
+	mongodb "backend/internal/mogodb"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Payment share statuses. A share paid on a split that then expires becomes
+// refund_due until the refund goes through.
+const (
+	sharePending   = "pending"
+	sharePaid      = "paid"
+	shareExpired   = "expired"
+	shareRefundDue = "refund_due"
+	shareRefunded  = "refunded"
+)
+
+// Split limits. Razorpay won't take less than a rupee.
+const (
+	maxShares      = 10
+	minShareAmount = 100
+)
+
+// splitTTL is how long payers have to pay every share, from
+// SPLIT_PAYMENT_TTL_MINUTES.
+func splitTTL() time.Duration {
+	minutes, err := strconv.Atoi(os.Getenv("SPLIT_PAYMENT_TTL_MINUTES"))
+	if err != nil || minutes <= 0 {
+		minutes = 30
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+// splitRequest asks for an order's payment to be split, either into Count
+// equal shares or into Shares that each cover some of the order's items,
+// given by their position in the cart.
+type splitRequest struct {
+	Mode   string `json:"mode"`
+	Count  int    `json:"count"`
+	Shares []struct {
+		Label string `json:"label"`
+		Items []int  `json:"items"`
+	} `json:"shares"`
+}
+
+// validate checks the split against an order of itemCount items, before any
+// pricing is done.
+func (s splitRequest) validate(itemCount int) error {
+	switch s.Mode {
+	case "equal":
+		if s.Count < 2 || s.Count > maxShares {
+			return fmt.Errorf("an order can be split between 2 and %d ways", maxShares)
+		}
+	case "items":
+		if len(s.Shares) < 2 || len(s.Shares) > maxShares {
+			return fmt.Errorf("an order can be split between 2 and %d ways", maxShares)
+		}
+		assigned := make([]bool, itemCount)
+		for _, share := range s.Shares {
+			if len(share.Items) == 0 {
+				return errors.New("every share needs at least one item")
+			}
+			for _, i := range share.Items {
+				if i < 0 || i >= itemCount {
+					return fmt.Errorf("item %d is not in the order", i)
+				}
+				if assigned[i] {
+					return fmt.Errorf("item %d is in more than one share", i)
+				}
+				assigned[i] = true
+			}
+		}
+		for i, ok := range assigned {
+			if !ok {
+				return fmt.Errorf("item %d is not in any share", i)
+			}
+		}
+	default:
+		return errors.New("split mode must be equal or items")
+	}
+	return nil
+}
+
+// shares divides what is due on a priced order. Equal shares differ by at
+// most a paisa, the first ones taking the remainder.
+func (s splitRequest) shares(order model.Order) ([]model.PaymentShare, error) {
+	var shares []model.PaymentShare
+
+	if s.Mode == "equal" {
+		due := amountDue(order).Amount
+		each, rest := due/int64(s.Count), due%int64(s.Count)
+		for i := 0; i < s.Count; i++ {
+			amount := each
+			if int64(i) < rest {
+				amount++
+			}
+			shares = append(shares, model.PaymentShare{Index: i, Amount: model.Paise(amount)})
+		}
+	} else {
+		for i, share := range s.Shares {
+			amount := model.Paise(0)
+			for _, item := range share.Items {
+				amount = amount.Add(order.Items[item].Total)
+			}
+			shares = append(shares, model.PaymentShare{
+				Index:       i,
+				Label:       share.Label,
+				ItemIndexes: share.Items,
+				Amount:      amount,
+			})
+		}
+	}
+
+	for _, share := range shares {
+		if share.Amount.Amount < minShareAmount {
+			return nil, errors.New("every share must be at least ₹1")
+		}
+	}
+	return shares, nil
+}
+
+// openSplit opens a gateway order for each share of a freshly saved order.
+// If any can't be opened the order is dropped again.
+func openSplit(c *gin.Context, ctx context.Context, oid primitive.ObjectID, order model.Order, split *splitRequest) {
+	orderColl := mongodb.GetCollection("smartcanteen", "orders")
+	shareColl := mongodb.GetCollection("smartcanteen", "payment_shares")
+
+	drop := func(status int, message string) {
+		dropOrder(ctx, oid, order)
+		shareColl.DeleteMany(ctx, bson.M{"orderId": order.ID})
+		c.JSON(status, gin.H{"error": message})
+	}
+
+	shares, err := split.shares(order)
+	if err != nil {
+		drop(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	now := time.Now()
+	for i := range shares {
+		razorOrder, err := razorpayClient().Order.Create(map[string]interface{}{
+			"amount":          shares[i].Amount.Amount,
+			"currency":        "INR",
+			"receipt":         fmt.Sprintf("%s-%d", order.ID, i+1),
+			"payment_capture": 1,
+		}, nil)
+		if err != nil {
+			drop(http.StatusInternalServerError, "Failed to create Razorpay order")
+			return
+		}
+
+		shares[i].OrderID = order.ID
+		shares[i].RazorpayOrderID, _ = razorOrder["id"].(string)
+		shares[i].Status = sharePending
+		shares[i].CreatedAt = now.Unix()
+
+		res, err := shareColl.InsertOne(ctx, shares[i])
+		if err != nil {
+			drop(http.StatusInternalServerError, "Failed to create payment shares")
+			return
+		}
+		shares[i].ID = res.InsertedID.(primitive.ObjectID).Hex()
+		openGatewayPayment(ctx, order, shares[i].RazorpayOrderID, shares[i].Amount)
+	}
+
+	expiresAt := now.Add(splitTTL()).Unix()
+	_, err = orderColl.UpdateByID(ctx, oid, bson.M{"$set": bson.M{
+		"splitMode":      split.Mode,
+		"shareCount":     len(shares),
+		"splitExpiresAt": expiresAt,
+	}})
+	if err != nil {
+		drop(http.StatusInternalServerError, "Failed to create payment shares")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":   "Order created, waiting for every share to be paid",
+		"orderID":   order.ID,
+		"amount":    amountDue(order),
+		"shares":    shares,
+		"expiresAt": expiresAt,
+		"key":       os.Getenv("RAZORPAY_KEY_ID"),
+	})
+}
+
+// orderShares lists an order's shares in payment order.
+func orderShares(ctx context.Context, orderID string) ([]model.PaymentShare, error) {
+	shareColl := mongodb.GetCollection("smartcanteen", "payment_shares")
+
+	cursor, err := shareColl.Find(ctx,
+		bson.M{"orderId": orderID},
+		options.Find().SetSort(bson.D{{Key: "index", Value: 1}}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	shares := []model.PaymentShare{}
+	if err := cursor.All(ctx, &shares); err != nil {
+		return nil, err
+	}
+	return shares, nil
+}
+
+// findShare loads one of an order's shares.
+func findShare(ctx context.Context, orderID, shareID string) (model.PaymentShare, error) {
+	shareColl := mongodb.GetCollection("smartcanteen", "payment_shares")
+
+	var share model.PaymentShare
+	sid, err := primitive.ObjectIDFromHex(shareID)
+	if err != nil {
+		return share, mongo.ErrNoDocuments
+	}
+	err = shareColl.FindOne(ctx, bson.M{"_id": sid, "orderId": orderID}).Decode(&share)
+	return share, err
+}
+
+// verifyShare settles one share of a split order once its payment is
+// verified, completing the order when it was the last one outstanding.
+func verifyShare(c *gin.Context, ctx context.Context, order model.Order, shareID string, attempt gatewayAttempt) {
+	shareColl := mongodb.GetCollection("smartcanteen", "payment_shares")
+
+	share, err := findShare(ctx, order.ID, shareID)
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payment share not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch payment share"})
+		return
+	}
+	attempt.Amount = share.Amount
+
+	if !attempt.SignatureValid {
+		failPayment(ctx, order, attempt, "signature_invalid", "Payment signature did not match")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payment signature"})
+		return
+	}
+	if share.RazorpayOrderID != attempt.ProviderOrderID {
+		slog.Error("payment for another gateway order", "order", order.ID, "share", shareID, "payment", attempt.ProviderPaymentID)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Payment does not match this share"})
+		return
+	}
+	capturePayment(ctx, order, attempt)
+
+	sid, _ := primitive.ObjectIDFromHex(share.ID)
+	res, err := shareColl.UpdateOne(ctx, bson.M{"_id": sid, "status": sharePending}, bson.M{"$set": bson.M{
+		"status":            sharePaid,
+		"razorpayPaymentId": attempt.ProviderPaymentID,
+		"paidBy":            c.GetString("user_id"),
+		"paidAt":            time.Now().Unix(),
+	}})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update payment share"})
+		return
+	}
+	if res.MatchedCount == 0 {
+		// The split ran out before this payment came in, so it is given back
+		res, err := shareColl.UpdateOne(ctx, bson.M{"_id": sid, "status": shareExpired}, bson.M{"$set": bson.M{
+			"status":            shareRefundDue,
+			"razorpayPaymentId": attempt.ProviderPaymentID,
+			"paidBy":            c.GetString("user_id"),
+			"paidAt":            time.Now().Unix(),
+		}})
+		if err == nil && res.MatchedCount == 1 {
+			c.JSON(http.StatusGone, gin.H{"error": "This split has expired; the payment will be refunded"})
+			return
+		}
+		if share.RazorpayPaymentID != "" && share.RazorpayPaymentID != attempt.ProviderPaymentID {
+			slog.Error("second payment for a paid share", "order", order.ID, "share", share.ID, "payment", attempt.ProviderPaymentID)
+		}
+	}
+
+	paid, err := shareColl.CountDocuments(ctx, bson.M{"orderId": order.ID, "status": sharePaid})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count payment shares"})
+		return
+	}
+
+	isPaid := order.IsPaid
+	if !isPaid && int(paid) == order.ShareCount {
+		oid, _ := primitive.ObjectIDFromHex(order.ID)
+		_, err := completeOrder(ctx, oid, nil)
+		if err == mongo.ErrNoDocuments {
+			// Completed by the payer of another share, or expired meanwhile
+			var current model.Order
+			err = mongodb.GetCollection("smartcanteen", "orders").FindOne(ctx, bson.M{"_id": oid}).Decode(&current)
+			isPaid = current.IsPaid
+		} else {
+			isPaid = err == nil
+		}
+		if err != nil {
+			slog.Error("failed to complete split order", "order", order.ID, "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update order status"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "Payment verified",
+		"sharesPaid": paid,
+		"shareCount": order.ShareCount,
+		"isPaid":     isPaid,
+	})
+}
+
+// GetOrderShares shows the shares of a split order, so each payer can find
+// theirs and pay it.
+func GetOrderShares(c *gin.Context) {
+	oid, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
+
+	orderColl := mongodb.GetCollection("smartcanteen", "orders")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var order model.Order
+	err = orderColl.FindOne(ctx, bson.M{"_id": oid, "splitMode": bson.M{"$exists": true}}).Decode(&order)
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Split order not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch order"})
+		return
+	}
+
+	shares, err := orderShares(ctx, order.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch payment shares"})
+		return
+	}
+
+	var items []gin.H
+	for i, item := range order.Items {
+		items = append(items, gin.H{"index": i, "name": item.Name, "quantity": item.Quantity, "total": item.Total})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"orderID":   order.ID,
+		"status":    order.Status,
+		"isPaid":    order.IsPaid,
+		"total":     order.Total,
+		"items":     items,
+		"shares":    shares,
+		"expiresAt": order.SplitExpiresAt,
+		"key":       os.Getenv("RAZORPAY_KEY_ID"),
+	})
+}
+
+// refundShare gives back a share paid on a split that expired.
+func refundShare(ctx context.Context, share model.PaymentShare) error {
+	shareColl := mongodb.GetCollection("smartcanteen", "payment_shares")
+	paymentColl := mongodb.GetCollection("smartcanteen", "payments")
+
+	refund, err := razorpayClient().Payment.Refund(share.RazorpayPaymentID, int(share.Amount.Amount), map[string]interface{}{
+		"receipt": fmt.Sprintf("%s-%d", share.OrderID, share.Index+1),
+	}, nil)
+	if err != nil {
+		return err
+	}
+	refundID, _ := refund["id"].(string)
+	now := time.Now().Unix()
+
+	sid, _ := primitive.ObjectIDFromHex(share.ID)
+	_, err = shareColl.UpdateOne(ctx, bson.M{"_id": sid, "status": shareRefundDue}, bson.M{"$set": bson.M{
+		"status":     shareRefunded,
+		"refundId":   refundID,
+		"refundedAt": now,
+	}})
+	if err != nil {
+		return err
+	}
+	_, err = paymentColl.UpdateOne(ctx, bson.M{
+		"orderId":           share.OrderID,
+		"providerPaymentId": share.RazorpayPaymentID,
+		"status":            paymentCaptured,
+	}, bson.M{"$set": bson.M{
+		"status":     paymentRefunded,
+		"refundId":   refundID,
+		"refundedAt": now,
+		"updatedAt":  now,
+	}})
+	return err
+}
+
+// refundSplit queues every paid share of a refunded split order to be given
+// back; expireSplits makes the refunds, retrying any that fail.
+func refundSplit(ctx context.Context, order model.Order) error {
+	shareColl := mongodb.GetCollection("smartcanteen", "payment_shares")
+
+	_, err := shareColl.UpdateMany(ctx,
+		bson.M{"orderId": order.ID, "status": sharePaid},
+		bson.M{"$set": bson.M{"status": shareRefundDue}},
+	)
+	return err
+}
+
+// expireSplits gives up on split orders that weren't fully paid in time:
+// the order is marked expired, its unpaid shares closed and its paid ones
+// refunded. Refunds that fail are retried on the next run.
+func expireSplits(ctx context.Context) {
+	orderColl := mongodb.GetCollection("smartcanteen", "orders")
+	shareColl := mongodb.GetCollection("smartcanteen", "payment_shares")
+
+	for {
+		var order model.Order
+		err := orderColl.FindOneAndUpdate(ctx, bson.M{
+			"splitMode":      bson.M{"$exists": true},
+			"isPaid":         false,
+			"status":         "Pending",
+			"splitExpiresAt": bson.M{"$lte": time.Now().Unix()},
+		}, bson.M{"$set": bson.M{"status": "Expired", "updatedAt": time.Now().Unix()}}).Decode(&order)
+		if err != nil {
+			if err != mongo.ErrNoDocuments {
+				slog.Error("failed to expire split orders", "error", err)
+			}
+			break
+		}
+
+		_, err = shareColl.UpdateMany(ctx, bson.M{"orderId": order.ID, "status": sharePending}, bson.M{"$set": bson.M{"status": shareExpired}})
+		if err == nil {
+			_, err = shareColl.UpdateMany(ctx, bson.M{"orderId": order.ID, "status": sharePaid}, bson.M{"$set": bson.M{"status": shareRefundDue}})
+		}
+		if err != nil {
+			slog.Error("failed to expire payment shares", "order", order.ID, "error", err)
+		}
+		releaseHolds(ctx, order)
+	}
+
+	cursor, err := shareColl.Find(ctx, bson.M{"status": shareRefundDue})
+	if err != nil {
+		slog.Error("failed to fetch shares to refund", "error", err)
+		return
+	}
+	var due []model.PaymentShare
+	if err := cursor.All(ctx, &due); err != nil {
+		slog.Error("failed to fetch shares to refund", "error", err)
+		return
+	}
+	for _, share := range due {
+		if err := refundShare(ctx, share); err != nil {
+			slog.Error("failed to refund payment share", "order", share.OrderID, "share", share.ID, "error", err)
+		}
+	}
+}
+
+// ExpireSplitPayments runs expireSplits every interval. It never returns,
+// so start it in its own goroutine.
+func ExpireSplitPayments(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		expireSplits(ctx)
+		cancel()
+	}
+}
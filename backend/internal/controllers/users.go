@@ -0,0 +1,285 @@
+package controllers
+
+import (
+	"backend/internal/middleware"
+	"backend/internal/model"
+	mongodb "backend/internal/mogodb"
+	"context"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// userView is what admins see of an account; the password hash never leaves
+// the server.
+func userView(user model.User) gin.H {
+	return gin.H{
+		"id":                    user.ID.Hex(),
+		"username":              user.Username,
+		"email":                 user.Email,
+		"role":                  user.Role,
+		"emailVerified":         user.EmailVerified,
+		"memberId":              user.MemberID,
+		"department":            user.Department,
+		"disabled":              user.Disabled,
+		"passwordResetRequired": user.PasswordResetRequired,
+	}
+}
+
+// targetUser loads the user named by the :id path parameter, writing the
+// error response itself when it can't.
+func targetUser(c *gin.Context, ctx context.Context) (model.User, bool) {
+	var user model.User
+
+	uid, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return user, false
+	}
+
+	userColl := mongodb.GetCollection("smartcanteen", "users")
+	err = userColl.FindOne(ctx, bson.M{"_id": uid}).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return user, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user"})
+		return user, false
+	}
+	return user, true
+}
+
+// currentUser loads the caller's own account, writing the error response
+// itself when it can't.
+func currentUser(c *gin.Context, ctx context.Context) (model.User, bool) {
+	var user model.User
+
+	uid, err := primitive.ObjectIDFromHex(c.GetString("user_id"))
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return user, false
+	}
+
+	userColl := mongodb.GetCollection("smartcanteen", "users")
+	if err := userColl.FindOne(ctx, bson.M{"_id": uid}).Decode(&user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user details"})
+		return user, false
+	}
+	return user, true
+}
+
+func ListUsers(c *gin.Context) {
+	filter := bson.M{}
+	if q := c.Query("q"); q != "" {
+		pattern := primitive.Regex{Pattern: regexp.QuoteMeta(q), Options: "i"}
+		filter["$or"] = bson.A{
+			bson.M{"username": pattern},
+			bson.M{"email": pattern},
+			bson.M{"memberId": pattern},
+		}
+	}
+	if role := c.Query("role"); role != "" {
+		filter["role"] = role
+	}
+	if disabled := c.Query("disabled"); disabled != "" {
+		filter["disabled"] = disabled == "true"
+	}
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if page < 1 {
+		page = 1
+	}
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	userColl := mongodb.GetCollection("smartcanteen", "users")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	total, err := userColl.CountDocuments(ctx, filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch users"})
+		return
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "username", Value: 1}}).
+		SetSkip(int64((page - 1) * limit)).
+		SetLimit(int64(limit))
+	cursor, err := userColl.Find(ctx, filter, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch users"})
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var users []model.User
+	if err := cursor.All(ctx, &users); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse users"})
+		return
+	}
+
+	response := []gin.H{}
+	for _, user := range users {
+		response = append(response, userView(user))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"users": response,
+		"total": total,
+		"page":  page,
+		"limit": limit,
+	})
+}
+
+func ChangeUserRole(c *gin.Context) {
+	var input struct {
+		Role string `json:"role" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+	if !middlewares.IsRole(input.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown role"})
+		return
+	}
+	if c.Param("id") == c.GetString("user_id") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You cannot change your own role"})
+		return
+	}
+
+	userColl := mongodb.GetCollection("smartcanteen", "users")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	user, ok := targetUser(c, ctx)
+	if !ok {
+		return
+	}
+
+	_, err := userColl.UpdateByID(ctx, user.ID, bson.M{"$set": bson.M{"role": input.Role}})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update role"})
+		return
+	}
+
+	// Tokens carry the role, so existing sessions have to log in again
+	if err := revokeUserSessions(ctx, user.ID.Hex(), "role_changed"); err != nil {
+		slog.Error("failed to revoke sessions after role change", "error", err)
+	}
+
+	writeAudit(ctx, c, "user.role_changed", "user", user.ID.Hex(), map[string]interface{}{
+		"from": user.Role,
+		"to":   input.Role,
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Role updated successfully"})
+}
+
+func DisableUser(c *gin.Context) {
+	setUserDisabled(c, true)
+}
+
+func EnableUser(c *gin.Context) {
+	setUserDisabled(c, false)
+}
+
+func setUserDisabled(c *gin.Context, disabled bool) {
+	if disabled && c.Param("id") == c.GetString("user_id") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You cannot disable your own account"})
+		return
+	}
+
+	userColl := mongodb.GetCollection("smartcanteen", "users")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	user, ok := targetUser(c, ctx)
+	if !ok {
+		return
+	}
+
+	_, err := userColl.UpdateByID(ctx, user.ID, bson.M{"$set": bson.M{"disabled": disabled}})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
+		return
+	}
+
+	action := "user.enabled"
+	message := "User enabled successfully"
+	if disabled {
+		action = "user.disabled"
+		message = "User disabled successfully"
+		if err := revokeUserSessions(ctx, user.ID.Hex(), "account_disabled"); err != nil {
+			slog.Error("failed to revoke sessions of disabled user", "error", err)
+		}
+	}
+
+	writeAudit(ctx, c, action, "user", user.ID.Hex(), nil)
+
+	c.JSON(http.StatusOK, gin.H{"message": message})
+}
+
+func ForcePasswordReset(c *gin.Context) {
+	userColl := mongodb.GetCollection("smartcanteen", "users")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	user, ok := targetUser(c, ctx)
+	if !ok {
+		return
+	}
+
+	_, err := userColl.UpdateByID(ctx, user.ID, bson.M{"$set": bson.M{"passwordResetRequired": true}})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
+		return
+	}
+
+	if err := revokeUserSessions(ctx, user.ID.Hex(), "password_reset_forced"); err != nil {
+		slog.Error("failed to revoke sessions for forced reset", "error", err)
+	}
+
+	writeAudit(ctx, c, "user.password_reset_forced", "user", user.ID.Hex(), nil)
+
+	if err := issuePasswordReset(ctx, user); err != nil {
+		slog.Error("failed to send forced password reset", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Password reset required, but the email could not be sent"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password reset email sent"})
+}
+
+func UnlockUser(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	user, ok := targetUser(c, ctx)
+	if !ok {
+		return
+	}
+
+	clearLoginFailures(ctx, accountAttemptKey(user.Email))
+
+	writeAudit(ctx, c, "user.unlocked", "user", user.ID.Hex(), nil)
+	writeAuthEvent(ctx, c, "unlock", user.ID.Hex(), user.Email, "admin:"+c.GetString("username"))
+
+	c.JSON(http.StatusOK, gin.H{"message": "User unlocked successfully"})
+}
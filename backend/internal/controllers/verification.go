@@ -0,0 +1,190 @@
+package controllers
+
+import (
+	"backend/internal/mail"
+	"backend/internal/model"
+	mongodb "backend/internal/mogodb"
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	emailVerificationTTL = 24 * time.Hour
+	// Resend limits per account
+	verificationResendInterval = time.Minute
+	verificationDailyLimit     = 5
+)
+
+// issueEmailVerification mails the user a link confirming they own their
+// current email address.
+func issueEmailVerification(ctx context.Context, user model.User) error {
+	verifyColl := mongodb.GetCollection("smartcanteen", "email_verifications")
+
+	token, err := randomToken()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	_, err = verifyColl.InsertOne(ctx, model.EmailVerification{
+		UserID:    user.ID.Hex(),
+		Email:     user.Email,
+		TokenHash: hashToken(token),
+		CreatedAt: now.Unix(),
+		ExpiresAt: now.Add(emailVerificationTTL).Unix(),
+	})
+	if err != nil {
+		return err
+	}
+
+	link := appBaseURL() + "/verify-email?token=" + url.QueryEscape(token)
+	return mail.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: "Verify your Smart Canteen email",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nPlease confirm your email address by opening the link below. It expires in %d hours.\n\n%s\n",
+			user.Username, int(emailVerificationTTL.Hours()), link,
+		),
+	})
+}
+
+// emailUnverified reports whether the user still has to confirm their email.
+// Accounts created before verification existed have no emailVerified field
+// and are treated as verified.
+func emailUnverified(ctx context.Context, uid primitive.ObjectID) (bool, error) {
+	userColl := mongodb.GetCollection("smartcanteen", "users")
+	count, err := userColl.CountDocuments(ctx, bson.M{"_id": uid, "emailVerified": false})
+	return count > 0, err
+}
+
+func VerifyEmail(c *gin.Context) {
+	var input struct {
+		Token string `json:"token" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	verifyColl := mongodb.GetCollection("smartcanteen", "email_verifications")
+	userColl := mongodb.GetCollection("smartcanteen", "users")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now().Unix()
+
+	var verification model.EmailVerification
+	err := verifyColl.FindOneAndUpdate(
+		ctx,
+		bson.M{"tokenHash": hashToken(input.Token), "usedAt": 0, "expiresAt": bson.M{"$gt": now}},
+		bson.M{"$set": bson.M{"usedAt": now}},
+	).Decode(&verification)
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Verification link is invalid or has expired"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify email"})
+		return
+	}
+
+	uid, err := primitive.ObjectIDFromHex(verification.UserID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Verification link is invalid or has expired"})
+		return
+	}
+
+	// The link only counts for the address it was sent to
+	res, err := userColl.UpdateOne(
+		ctx,
+		bson.M{"_id": uid, "email": verification.Email},
+		bson.M{"$set": bson.M{"emailVerified": true}},
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify email"})
+		return
+	}
+	if res.MatchedCount == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Verification link is invalid or has expired"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Email verified successfully"})
+}
+
+func ResendVerification(c *gin.Context) {
+	var input struct {
+		Email string `json:"email" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	verifyColl := mongodb.GetCollection("smartcanteen", "email_verifications")
+	userColl := mongodb.GetCollection("smartcanteen", "users")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	generic := gin.H{"message": "If that account needs verifying, a new link has been sent"}
+
+	var user model.User
+	err := userColl.FindOne(ctx, bson.M{"email": normalizeEmail(input.Email), "emailVerified": false}).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusOK, generic)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to look up account"})
+		return
+	}
+
+	now := time.Now()
+	userID := user.ID.Hex()
+
+	recent, err := verifyColl.CountDocuments(ctx, bson.M{
+		"userId":    userID,
+		"createdAt": bson.M{"$gt": now.Add(-verificationResendInterval).Unix()},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send verification email"})
+		return
+	}
+	today, err := verifyColl.CountDocuments(ctx, bson.M{
+		"userId":    userID,
+		"createdAt": bson.M{"$gt": now.Add(-24 * time.Hour).Unix()},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send verification email"})
+		return
+	}
+	if today >= verificationDailyLimit {
+		c.Header("Retry-After", fmt.Sprint(int((24 * time.Hour).Seconds())))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many verification emails, please try again tomorrow"})
+		return
+	}
+	if recent > 0 {
+		c.Header("Retry-After", fmt.Sprint(int(verificationResendInterval.Seconds())))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Please wait before requesting another email"})
+		return
+	}
+
+	if err := issueEmailVerification(ctx, user); err != nil {
+		slog.Error("failed to send verification email", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send verification email"})
+		return
+	}
+
+	c.JSON(http.StatusOK, generic)
+}
@@ -0,0 +1,291 @@
+package controllers
+
+import (
+	"backend/internal/model"
+	mongodb "backend/internal/mogodb"
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Top-up limits, in paise
+const (
+	minWalletTopup = 10 * 100
+	maxWalletTopup = 5000 * 100
+)
+
+func validTopupAmount(amount int64) bool {
+	return amount >= minWalletTopup && amount <= maxWalletTopup
+}
+
+// walletBalance returns the stored balance; users without a wallet have zero.
+func walletBalance(ctx context.Context, userID string) (int64, error) {
+	walletColl := mongodb.GetCollection("smartcanteen", "wallets")
+
+	var wallet model.Wallet
+	err := walletColl.FindOne(ctx, bson.M{"userId": userID}).Decode(&wallet)
+	if err == mongo.ErrNoDocuments {
+		return 0, nil
+	}
+	return wallet.Balance, err
+}
+
+// walletEntries returns the latest postings on a user's wallet.
+func walletEntries(ctx context.Context, userID string) ([]model.LedgerEntry, error) {
+	ledgerColl := mongodb.GetCollection("smartcanteen", "ledger_entries")
+
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}}).SetLimit(50)
+	cursor, err := ledgerColl.Find(ctx, bson.M{"account": walletAccount(userID)}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	entries := []model.LedgerEntry{}
+	err = cursor.All(ctx, &entries)
+	return entries, err
+}
+
+func GetWallet(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	balance, err := walletBalance(ctx, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch wallet"})
+		return
+	}
+	entries, err := walletEntries(ctx, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch wallet"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"balance": model.Paise(balance),
+		"entries": entries,
+	})
+}
+
+// CreateWalletTopup opens a Razorpay order for a top-up. The wallet is
+// credited once VerifyWalletTopup confirms the payment.
+func CreateWalletTopup(c *gin.Context) {
+	var input struct {
+		Amount model.Money `json:"amount"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	amount := input.Amount.Amount
+	if !validTopupAmount(amount) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Top-up must be between ₹%d and ₹%d", minWalletTopup/100, maxWalletTopup/100)})
+		return
+	}
+
+	userID := c.GetString("user_id")
+	topupColl := mongodb.GetCollection("smartcanteen", "wallet_topups")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	razorOrder, err := razorpayClient().Order.Create(map[string]interface{}{
+		"amount":          amount,
+		"currency":        "INR",
+		"receipt":         "wallet-" + userID,
+		"payment_capture": 1,
+	}, nil)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create Razorpay order"})
+		return
+	}
+	razorpayOrderID, _ := razorOrder["id"].(string)
+
+	_, err = topupColl.InsertOne(ctx, model.WalletTopup{
+		UserID:          userID,
+		Amount:          amount,
+		RazorpayOrderID: razorpayOrderID,
+		Status:          "created",
+		CreatedAt:       time.Now().Unix(),
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create top-up"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":         "Top-up created",
+		"razorpayOrderID": razorpayOrderID,
+		"amount":          razorOrder["amount"],
+		"currency":        razorOrder["currency"],
+		"key":             os.Getenv("RAZORPAY_KEY_ID"),
+	})
+}
+
+func VerifyWalletTopup(c *gin.Context) {
+	var body struct {
+		RazorpayPaymentID string `json:"razorpay_payment_id" binding:"required"`
+		RazorpayOrderID   string `json:"razorpay_order_id" binding:"required"`
+		RazorpaySignature string `json:"razorpay_signature" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	if !validRazorpaySignature(body.RazorpayOrderID, body.RazorpayPaymentID, body.RazorpaySignature) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payment signature"})
+		return
+	}
+
+	userID := c.GetString("user_id")
+	topupColl := mongodb.GetCollection("smartcanteen", "wallet_topups")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Claiming the top-up first means a payment is only ever credited once
+	var topup model.WalletTopup
+	err := topupColl.FindOneAndUpdate(
+		ctx,
+		bson.M{"razorpayOrderId": body.RazorpayOrderID, "userId": userID, "status": "created"},
+		bson.M{"$set": bson.M{
+			"status":            "paid",
+			"razorpayPaymentId": body.RazorpayPaymentID,
+			"paidAt":            time.Now().Unix(),
+		}},
+	).Decode(&topup)
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Top-up not found or already credited"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify top-up"})
+		return
+	}
+
+	err = creditWallet(ctx, userID, topup.Amount, accountRazorpay, "topup", body.RazorpayPaymentID)
+	if err != nil {
+		slog.Error("failed to credit wallet top-up", "topup", body.RazorpayOrderID, "error", err)
+		topupColl.UpdateOne(ctx, bson.M{"razorpayOrderId": body.RazorpayOrderID}, bson.M{"$set": bson.M{"status": "created"}})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to credit wallet"})
+		return
+	}
+
+	balance, _ := walletBalance(ctx, userID)
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Wallet topped up",
+		"balance": model.Paise(balance),
+	})
+}
+
+// CounterTopup credits cash handed over at the counter to a user's wallet.
+func CounterTopup(c *gin.Context) {
+	var input struct {
+		Amount model.Money `json:"amount"`
+		Note   string      `json:"note"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	amount := input.Amount.Amount
+	if !validTopupAmount(amount) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Top-up must be between ₹%d and ₹%d", minWalletTopup/100, maxWalletTopup/100)})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	user, ok := targetUser(c, ctx)
+	if !ok {
+		return
+	}
+	userID := user.ID.Hex()
+
+	// The cash goes into the cashier's drawer, so it has to be counted on
+	// their open shift
+	shift, err := openShift(ctx, c.GetString("user_id"))
+	if err == errNoOpenShift {
+		c.JSON(http.StatusConflict, gin.H{"error": "Open a shift to take cash at the counter"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch shift"})
+		return
+	}
+
+	reference := primitive.NewObjectID().Hex()
+	if err := creditWallet(ctx, userID, amount, accountCash, "counter_topup", reference); err != nil {
+		slog.Error("failed to credit counter top-up", "user", userID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to credit wallet"})
+		return
+	}
+
+	if err := addToShift(ctx, shift.ID, "cashTopups", amount); err != nil {
+		slog.Error("failed to add top-up to shift", "user", userID, "shift", shift.ID, "error", err)
+	}
+
+	writeAudit(ctx, c, "wallet.counter_topup", "user", userID, map[string]interface{}{
+		"amount":    amount,
+		"reference": reference,
+		"note":      input.Note,
+	})
+
+	balance, _ := walletBalance(ctx, userID)
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Wallet topped up",
+		"balance": model.Paise(balance),
+	})
+}
+
+// GetUserWallet shows staff a user's wallet, with the balance rebuilt from
+// the ledger next to the stored one so drift is easy to spot.
+func GetUserWallet(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	user, ok := targetUser(c, ctx)
+	if !ok {
+		return
+	}
+	userID := user.ID.Hex()
+
+	balance, err := walletBalance(ctx, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch wallet"})
+		return
+	}
+	ledger, err := ledgerBalance(ctx, walletAccount(userID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch ledger"})
+		return
+	}
+	entries, err := walletEntries(ctx, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch ledger"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"user":          userView(user),
+		"balance":       model.Paise(balance),
+		"ledgerBalance": model.Paise(ledger),
+		"consistent":    balance == ledger,
+		"entries":       entries,
+	})
+}
@@ -0,0 +1,132 @@
+package controllers
+
+import (
+	"backend/internal/model"
+	mongodb "backend/internal/mogodb"
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Reason codes accepted when recording wastage
+var wastageReasons = map[string]bool{
+	"unsold":         true,
+	"expired":        true,
+	"spoiled":        true,
+	"damaged":        true,
+	"overproduction": true,
+	"other":          true,
+}
+
+func RecordWastage(c *gin.Context) {
+	var input model.Wastage
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	if input.Quantity < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Quantity must be at least 1"})
+		return
+	}
+	if !wastageReasons[input.Reason] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid reason code"})
+		return
+	}
+
+	pid, err := primitive.ObjectIDFromHex(input.ProductID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+		return
+	}
+
+	productColl := mongodb.GetCollection("smartcanteen", "products")
+	wastageColl := mongodb.GetCollection("smartcanteen", "wastage")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Only decrement when enough stock is left, so stock never goes negative
+	var product model.Product
+	err = productColl.FindOneAndUpdate(
+		ctx,
+		bson.M{"_id": pid, "quantity": bson.M{"$gte": input.Quantity}},
+		bson.M{"$inc": bson.M{"quantity": -input.Quantity}},
+		options.FindOneAndUpdate().SetReturnDocument(options.Before),
+	).Decode(&product)
+	if err == mongo.ErrNoDocuments {
+		count, _ := productColl.CountDocuments(ctx, bson.M{"_id": pid})
+		if count == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Quantity exceeds available stock"})
+		return
+	}
+	if err != nil {
+		slog.Error("failed to decrement stock for wastage", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update product stock"})
+		return
+	}
+
+	if err := wasteMenuPortions(ctx, input.ProductID, input.Quantity); err != nil {
+		slog.Error("failed to take wasted portions off the menu", "product", input.ProductID, "error", err)
+	}
+
+	recordedBy, _ := c.Get("username")
+	input.RecordedBy, _ = recordedBy.(string)
+	input.ID = ""
+	input.ProductName = product.Name
+	input.UnitValue = product.Price
+	input.Value = product.Price.Mul(input.Quantity)
+	input.CreatedAt = time.Now().Unix()
+
+	result, err := wastageColl.InsertOne(ctx, input)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record wastage"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Wastage recorded successfully",
+		"id":      result.InsertedID.(primitive.ObjectID).Hex(),
+		"value":   input.Value,
+	})
+}
+
+func GetWastage(c *gin.Context) {
+	start, end, err := dayRange(c.Query("date"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid date, expected YYYY-MM-DD"})
+		return
+	}
+
+	wastageColl := mongodb.GetCollection("smartcanteen", "wastage")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cursor, err := wastageColl.Find(ctx, bson.M{"createdAt": bson.M{"$gte": start, "$lt": end}})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch wastage"})
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var entries []model.Wastage
+	if err := cursor.All(ctx, &entries); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse wastage"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"wastage": entries,
+	})
+}
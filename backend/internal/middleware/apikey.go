@@ -0,0 +1,79 @@
+package middlewares
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+
+	database "backend/internal/mogodb"
+)
+
+// apiKeyFromRequest returns the device key sent as "Authorization: ApiKey
+// <key>" or in the X-API-Key header, if any.
+func apiKeyFromRequest(c *gin.Context) string {
+	if key := c.GetHeader("X-API-Key"); key != "" {
+		return key
+	}
+	if authHeader := c.GetHeader("Authorization"); strings.HasPrefix(authHeader, "ApiKey ") {
+		return strings.TrimPrefix(authHeader, "ApiKey ")
+	}
+	return ""
+}
+
+// authenticateDevice accepts a live API key and sets the device identity on
+// the context: role "device", device_id, device_name, device_kind and the
+// key's scopes.
+func authenticateDevice(c *gin.Context, key string) bool {
+	apiKeyColl := database.GetCollection("smartcanteen", "api_keys")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	sum := sha256.Sum256([]byte(key))
+
+	var device struct {
+		ID     string   `bson:"_id"`
+		Name   string   `bson:"name"`
+		Kind   string   `bson:"kind"`
+		Scopes []string `bson:"scopes"`
+	}
+	err := apiKeyColl.FindOneAndUpdate(
+		ctx,
+		bson.M{"keyHash": hex.EncodeToString(sum[:]), "revoked": false},
+		bson.M{"$set": bson.M{"lastUsedAt": time.Now().Unix()}},
+	).Decode(&device)
+	if err != nil {
+		return false
+	}
+
+	scopes := make([]Permission, 0, len(device.Scopes))
+	for _, s := range device.Scopes {
+		scopes = append(scopes, Permission(s))
+	}
+
+	c.Set("role", RoleDevice)
+	c.Set("username", device.Name)
+	c.Set("device_id", device.ID)
+	c.Set("device_name", device.Name)
+	c.Set("device_kind", device.Kind)
+	c.Set("scopes", scopes)
+	return true
+}
+
+// deviceAuth handles requests that carry an API key instead of a JWT.
+func deviceAuth(c *gin.Context, key string) {
+	if !authenticateDevice(c, key) {
+		slog.Error("invalid api key")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid API key"})
+		c.Abort()
+		return
+	}
+	c.Next()
+}
@@ -1,19 +1,29 @@
 package middlewares
 
 import (
+	"context"
 	"log/slog"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
-)
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 
-var jwtSecret = []byte("supersecretkey")
+	"backend/internal/auth"
+	database "backend/internal/mogodb"
+)
 
-// AuthMiddleware verifies JWT token
+// AuthMiddleware verifies JWT token, or the API key of a device
 func AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
+		if key := apiKeyFromRequest(c); key != "" {
+			deviceAuth(c, key)
+			return
+		}
+
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
 			slog.Error("authorization error")
@@ -24,12 +34,7 @@ func AuthMiddleware() gin.HandlerFunc {
 
 		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
 
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, jwt.ErrInvalidKeyType
-			}
-			return jwtSecret, nil
-		})
+		token, err := auth.Keys.Parse(tokenString)
 
 		if err != nil || !token.Valid {
 			slog.Error("invalid token")
@@ -46,6 +51,26 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
+		sid, _ := claims["sid"].(string)
+		uid, _ := claims["user_id"].(string)
+		status := sessionStatus(sid)
+		if status == "" {
+			// Disabling an account revokes its sessions, but the account is
+			// checked too in case that didn't happen
+			status = accountStatus(uid)
+		}
+		if status != "" {
+			slog.Error("session not active", "reason", status)
+			if status == "account_disabled" {
+				c.JSON(http.StatusForbidden, gin.H{"error": "Account disabled"})
+			} else {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Session expired, please log in again"})
+			}
+			c.Abort()
+			return
+		}
+		c.Set("session_id", sid)
+
 		if userID, exists := claims["user_id"]; exists {
 			if uid, ok := userID.(string); ok {
 				c.Set("user_id", uid)
@@ -61,3 +86,61 @@ func AuthMiddleware() gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+// accountStatus checks the account behind an access token. It returns ""
+// while the account can be used, "account_disabled" once an admin has
+// disabled it, or "unknown".
+func accountStatus(userID string) string {
+	oid, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return "unknown"
+	}
+
+	userColl := database.GetCollection("smartcanteen", "users")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var user struct {
+		Disabled bool `bson:"disabled"`
+	}
+	if err := userColl.FindOne(ctx, bson.M{"_id": oid}).Decode(&user); err != nil {
+		return "unknown"
+	}
+	if user.Disabled {
+		return "account_disabled"
+	}
+	return ""
+}
+
+// sessionStatus checks the session behind an access token. It returns ""
+// while the session is live, or the reason it no longer is: "expired",
+// "unknown", or the revokedReason recorded by logout, a password change or
+// an admin disabling the account.
+func sessionStatus(sid string) string {
+	oid, err := primitive.ObjectIDFromHex(sid)
+	if err != nil {
+		return "unknown"
+	}
+
+	sessionColl := database.GetCollection("smartcanteen", "sessions")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var session struct {
+		Revoked       bool   `bson:"revoked"`
+		RevokedReason string `bson:"revokedReason"`
+		ExpiresAt     int64  `bson:"expiresAt"`
+	}
+	if err := sessionColl.FindOne(ctx, bson.M{"_id": oid}).Decode(&session); err != nil {
+		return "unknown"
+	}
+	if session.Revoked {
+		return session.RevokedReason
+	}
+	if session.ExpiresAt <= time.Now().Unix() {
+		return "expired"
+	}
+	return ""
+}
@@ -0,0 +1,134 @@
+package middlewares
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	RoleAdmin   = "admin"
+	RoleKitchen = "kitchen"
+	RoleCashier = "cashier"
+	// Customers have always been stored with the "user" role
+	RoleCustomer = "user"
+	// Kiosks and POS terminals calling with an API key. It is never stored
+	// on a user; what a device may do comes from its key's scopes.
+	RoleDevice = "device"
+)
+
+// Permission names one action a role may be allowed to perform.
+type Permission string
+
+const (
+	PermProductsRead     Permission = "products:read"
+	PermProductsWrite    Permission = "products:write"
+	PermCartWrite        Permission = "cart:write"
+	PermOrdersCreate     Permission = "orders:create"
+	PermOrdersReadOwn    Permission = "orders:read_own"
+	PermOrdersReadAll    Permission = "orders:read_all"
+	PermOrdersDeliver    Permission = "orders:deliver"
+	PermOrdersRefund     Permission = "orders:refund"
+	PermMenuWrite        Permission = "menu:write"
+	PermWastageRecord    Permission = "wastage:record"
+	PermReportsRead      Permission = "reports:read"
+	PermUsersManage      Permission = "users:manage"
+	PermWalletUse        Permission = "wallet:use"
+	PermWalletManage     Permission = "wallet:manage"
+	PermPromotionsManage Permission = "promotions:manage"
+	PermCounterSell      Permission = "counter:sell"
+	PermMealPlansUse     Permission = "meal_plans:use"
+	PermMealPlansManage  Permission = "meal_plans:manage"
+)
+
+var rolePermissions = map[string][]Permission{
+	RoleAdmin: {
+		PermProductsRead, PermProductsWrite,
+		PermOrdersReadAll, PermOrdersDeliver, PermOrdersRefund,
+		PermMenuWrite, PermWastageRecord, PermReportsRead,
+		PermUsersManage, PermWalletManage,
+		PermPromotionsManage, PermCounterSell,
+		PermMealPlansManage,
+	},
+	RoleKitchen: {
+		PermProductsRead,
+		PermOrdersReadAll, PermOrdersDeliver,
+		PermMenuWrite, PermWastageRecord,
+	},
+	RoleCashier: {
+		PermProductsRead,
+		PermOrdersReadAll,
+		PermWalletManage, PermCounterSell,
+	},
+	RoleCustomer: {
+		PermProductsRead, PermCartWrite,
+		PermOrdersCreate, PermOrdersReadOwn,
+		PermWalletUse, PermMealPlansUse,
+	},
+}
+
+// deviceScopes are the permissions an API key may be granted.
+var deviceScopes = []Permission{
+	PermProductsRead, PermOrdersCreate, PermOrdersReadAll, PermOrdersDeliver,
+}
+
+// IsDeviceScope reports whether scope may be granted to a device.
+func IsDeviceScope(scope string) bool {
+	for _, p := range deviceScopes {
+		if string(p) == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// IsRole reports whether role is one of the known roles.
+func IsRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// HasPermission reports whether the role grants the permission.
+func HasPermission(role string, perm Permission) bool {
+	for _, p := range rolePermissions[role] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// callerHasPermission checks the caller's role, or for a device its scopes.
+func callerHasPermission(c *gin.Context, perm Permission) bool {
+	if c.GetString("role") != RoleDevice {
+		return HasPermission(c.GetString("role"), perm)
+	}
+	scopes, _ := c.Get("scopes")
+	granted, _ := scopes.([]Permission)
+	for _, p := range granted {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// RequirePermission lets the request through only when the caller's role, or
+// a device's scopes, grant every listed permission. It must run after
+// AuthMiddleware.
+func RequirePermission(perms ...Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role := c.GetString("role")
+		for _, perm := range perms {
+			if !callerHasPermission(c, perm) {
+				slog.Error("permission denied", "role", role, "permission", perm, "path", c.FullPath())
+				c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+				c.Abort()
+				return
+			}
+		}
+
+		c.Next()
+	}
+}
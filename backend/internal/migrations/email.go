@@ -0,0 +1,54 @@
+package migrations
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// NormalizeEmails lower-cases and trims the emails users signed up with, so
+// lookups by the normalised form find them. An email that would then clash
+// with another account's is left alone and logged for someone to resolve.
+// It returns how many users changed.
+func NormalizeEmails(ctx context.Context, db *mongo.Database) (int, error) {
+	users := db.Collection("users")
+
+	cursor, err := users.Find(ctx, bson.M{"$expr": bson.M{"$ne": bson.A{
+		"$email",
+		bson.M{"$toLower": bson.M{"$trim": bson.M{"input": "$email"}}},
+	}}})
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	changed := 0
+	for cursor.Next(ctx) {
+		var user struct {
+			ID    interface{} `bson:"_id"`
+			Email string      `bson:"email"`
+		}
+		if err := cursor.Decode(&user); err != nil {
+			return changed, err
+		}
+
+		email := strings.ToLower(strings.TrimSpace(user.Email))
+		taken, err := users.CountDocuments(ctx, bson.M{"email": email})
+		if err != nil {
+			return changed, err
+		}
+		if taken > 0 {
+			log.Printf("⚠️ Not normalising %q: %q is already registered", user.Email, email)
+			continue
+		}
+
+		if _, err := users.UpdateByID(ctx, user.ID, bson.M{"$set": bson.M{"email": email}}); err != nil {
+			return changed, err
+		}
+		changed++
+	}
+	return changed, cursor.Err()
+}
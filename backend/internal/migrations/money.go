@@ -0,0 +1,123 @@
+// Package migrations holds one-off data migrations, run with cmd/migrate.
+package migrations
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"backend/internal/model"
+)
+
+// moneyFields lists, per collection, the top-level fields that used to hold
+// rupee amounts as numbers. Order items are handled separately.
+var moneyFields = map[string][]string{
+	"products": {"price"},
+	"orders":   {"total"},
+	"wastage":  {"unitCost", "cost"},
+}
+
+// numeric matches legacy amounts: anything stored as a number rather than
+// an {amount, currency} document.
+var numeric = bson.M{"$type": bson.A{"double", "int", "long"}}
+
+// ConvertMoney rewrites rupee amounts stored as numbers into Money documents
+// holding integer paise. Documents already converted are left alone, so it
+// is safe to run more than once. It returns how many documents changed.
+func ConvertMoney(ctx context.Context, db *mongo.Database) (int, error) {
+	changed := 0
+
+	for collName, fields := range moneyFields {
+		coll := db.Collection(collName)
+
+		or := bson.A{}
+		for _, field := range fields {
+			or = append(or, bson.M{field: numeric})
+		}
+		if collName == "orders" {
+			or = append(or, bson.M{"items.price": numeric}, bson.M{"items.total": numeric})
+		}
+
+		cursor, err := coll.Find(ctx, bson.M{"$or": or})
+		if err != nil {
+			return changed, fmt.Errorf("%s: %w", collName, err)
+		}
+
+		for cursor.Next(ctx) {
+			var doc bson.M
+			if err := cursor.Decode(&doc); err != nil {
+				cursor.Close(ctx)
+				return changed, fmt.Errorf("%s: %w", collName, err)
+			}
+
+			set := bson.M{}
+			for _, field := range fields {
+				if m, ok := toMoney(doc[field]); ok {
+					set[field] = m
+				}
+			}
+			if items, ok := doc["items"].(bson.A); ok && collName == "orders" {
+				set["items"] = convertItems(items)
+			}
+			if len(set) == 0 {
+				continue
+			}
+
+			if _, err := coll.UpdateByID(ctx, doc["_id"], bson.M{"$set": set}); err != nil {
+				cursor.Close(ctx)
+				return changed, fmt.Errorf("%s %v: %w", collName, doc["_id"], err)
+			}
+			changed++
+		}
+		err = cursor.Err()
+		cursor.Close(ctx)
+		if err != nil {
+			return changed, fmt.Errorf("%s: %w", collName, err)
+		}
+
+		log.Printf("converted money fields in %s", collName)
+	}
+
+	return changed, nil
+}
+
+func convertItems(items bson.A) bson.A {
+	converted := bson.A{}
+	for _, raw := range items {
+		item, ok := raw.(bson.M)
+		if !ok {
+			converted = append(converted, raw)
+			continue
+		}
+		for _, field := range []string{"price", "total"} {
+			if m, ok := toMoney(item[field]); ok {
+				item[field] = m
+			}
+		}
+		converted = append(converted, item)
+	}
+	return converted
+}
+
+// toMoney converts a legacy number through the same rules Money uses when it
+// reads one, so migrated and unmigrated documents agree to the paisa.
+func toMoney(v interface{}) (model.Money, bool) {
+	switch v.(type) {
+	case float64, int32, int64:
+	default:
+		return model.Money{}, false
+	}
+
+	t, data, err := bson.MarshalValue(v)
+	if err != nil {
+		return model.Money{}, false
+	}
+	var m model.Money
+	if err := m.UnmarshalBSONValue(t, data); err != nil {
+		return model.Money{}, false
+	}
+	return m, true
+}
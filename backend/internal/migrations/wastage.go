@@ -0,0 +1,27 @@
+package migrations
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// RenameWastageValue renames the unitCost and cost of wastage entries to
+// unitValue and value, since they were always the selling price. Run it
+// after ConvertMoney, which still looks for the old names. It returns how
+// many entries changed.
+func RenameWastageValue(ctx context.Context, db *mongo.Database) (int, error) {
+	res, err := db.Collection("wastage").UpdateMany(
+		ctx,
+		bson.M{"$or": bson.A{
+			bson.M{"unitCost": bson.M{"$exists": true}},
+			bson.M{"cost": bson.M{"$exists": true}},
+		}},
+		bson.M{"$rename": bson.M{"unitCost": "unitValue", "cost": "value"}},
+	)
+	if err != nil {
+		return 0, err
+	}
+	return int(res.ModifiedCount), nil
+}
@@ -3,50 +3,498 @@ package model
 import "go.mongodb.org/mongo-driver/bson/primitive"
 
 type User struct {
-	ID       primitive.ObjectID `json:"id" bson:"_id"`
-	Username string `json:"username" bson:"username"`
-	Email    string `json:"email" bson:"email"`
-	Password string `json:"password" bson:"password"`
-	Role     string `json:"role" bson:"role"`
+	ID                    primitive.ObjectID `json:"id" bson:"_id"`
+	Username              string             `json:"username" bson:"username"`
+	Email                 string             `json:"email" bson:"email"`
+	Password              string             `json:"password" bson:"password"`
+	Role                  string             `json:"role" bson:"role"`
+	EmailVerified         bool               `json:"emailVerified" bson:"emailVerified"`
+	MemberID              string             `json:"memberId" bson:"memberId,omitempty"`
+	Department            string             `json:"department" bson:"department,omitempty"`
+	Disabled              bool               `json:"disabled" bson:"disabled"`
+	PasswordResetRequired bool               `json:"passwordResetRequired" bson:"passwordResetRequired"`
+	TOTPEnabled           bool               `json:"totpEnabled" bson:"totpEnabled"`
+	TOTPSecret            string             `json:"-" bson:"totpSecret,omitempty"`
+	TOTPPendingSecret     string             `json:"-" bson:"totpPendingSecret,omitempty"`
+	TOTPLastStep          int64              `json:"-" bson:"totpLastStep,omitempty"`
+	RecoveryCodes         []string           `json:"-" bson:"recoveryCodes,omitempty"`
+	// An admin without 2FA can only enrol with a code another admin issued
+	MFAEnrolmentHash      string               `json:"-" bson:"mfaEnrolmentHash,omitempty"`
+	MFAEnrolmentExpiresAt int64                `json:"-" bson:"mfaEnrolmentExpiresAt,omitempty"`
+	Phone                 string               `json:"phone" bson:"phone,omitempty"`
+	Notifications         NotificationSettings `json:"notifications" bson:"notifications"`
+}
+
+// NotificationSettings are the messages a user has opted into.
+type NotificationSettings struct {
+	OrderUpdates bool `json:"orderUpdates" bson:"orderUpdates"`
+	Promotions   bool `json:"promotions" bson:"promotions"`
+	SMS          bool `json:"sms" bson:"sms"`
 }
 
 type LoginInput struct {
 	Email    string `json:"email" binding:"required"`
 	Password string `json:"password" binding:"required"`
+	// Set by an admin enrolling in 2FA as part of logging in
+	EnrolmentCode string `json:"enrolment_code"`
 }
 
 type Product struct {
-	ID          string  `bson:"_id,omitempty" json:"id,omitempty"`
-	Name        string  `bson:"name" json:"name" binding:"required"`
-	Description string  `bson:"description" json:"description"`
-	Price       float64 `bson:"price" json:"price" binding:"required"`
-	Quantity    int     `bson:"quantity" json:"quantity" binding:"required"`
-	CreatedBy   string  `bson:"createdBy" json:"createdBy"`
+	ID          string   `bson:"_id,omitempty" json:"id,omitempty"`
+	Name        string   `bson:"name" json:"name" binding:"required"`
+	Description string   `bson:"description" json:"description"`
+	Price       Money    `bson:"price" json:"price"`
+	Quantity    int      `bson:"quantity" json:"quantity" binding:"required"`
+	CreatedBy   string   `bson:"createdBy" json:"createdBy"`
+	Tax         TaxClass `bson:"tax" json:"tax"`
+	Category    string   `bson:"category,omitempty" json:"category,omitempty"`
+	// Extra loyalty points earned per unit bought
+	LoyaltyBonus int64 `bson:"loyaltyBonus,omitempty" json:"loyaltyBonus,omitempty"`
+}
+
+// TaxClass is how a product is taxed under GST. Rate is in basis points, so
+// 500 means 5%. Inclusive prices already contain the tax.
+type TaxClass struct {
+	Rate      int64  `bson:"rate" json:"rate"`
+	HSN       string `bson:"hsn" json:"hsn"`
+	Inclusive bool   `bson:"inclusive" json:"inclusive"`
 }
 
 type AddtoCart struct {
-	Quantity    int     `json:"quantity"`
+	Quantity int `json:"quantity"`
 }
 
 type OrderItem struct {
-	ProductID   string  `bson:"productId" json:"productId" binding:"required"`
-	Name        string  `bson:"name" json:"name"`                   
-	Price       float64 `bson:"price" json:"price" binding:"required"`
-	Quantity    int     `bson:"quantity" json:"quantity" binding:"required"`
-	Total       float64 `bson:"total" json:"total"`                
+	ProductID string `bson:"productId" json:"productId" binding:"required"`
+	Name      string `bson:"name" json:"name"`
+	Price     Money  `bson:"price" json:"price"`
+	Quantity  int    `bson:"quantity" json:"quantity" binding:"required"`
+	Total     Money  `bson:"total" json:"total"`
+	// Tax as charged when the order was placed
+	HSN          string `bson:"hsn,omitempty" json:"hsn,omitempty"`
+	TaxRate      int64  `bson:"taxRate" json:"taxRate"`
+	TaxInclusive bool   `bson:"taxInclusive" json:"taxInclusive"`
+	Taxable      Money  `bson:"taxable" json:"taxable"`
+	CGST         Money  `bson:"cgst" json:"cgst"`
+	SGST         Money  `bson:"sgst" json:"sgst"`
+	Discount     Money  `bson:"discount" json:"discount"`
+	LoyaltyBonus int64  `bson:"loyaltyBonus,omitempty" json:"loyaltyBonus,omitempty"`
 }
 
-
 type Order struct {
-	ID           string       `bson:"_id,omitempty" json:"id,omitempty"`
-	CustomerID   string       `bson:"customerId" json:"customerId" binding:"required"`
-	CustomerName string       `bson:"customerName" json:"customerName"`
-	CustomerEmail string      `bson:"customerEmail" json:"customerEmail"`
-	Items        []OrderItem  `bson:"items" json:"items" binding:"required"`
-	Total        float64      `bson:"total" json:"total"`                
-	Status       string       `bson:"status" json:"status"`              
-	PaymentMethod string      `bson:"paymentMethod" json:"paymentMethod"`
-	IsPaid       bool         `bson:"isPaid" json:"isPaid"`
-	CreatedAt    int64        `bson:"createdAt" json:"createdAt"`   
-	Delivered bool `bson:"delivered" json:"delivered"`    
+	ID                string            `bson:"_id,omitempty" json:"id,omitempty"`
+	CustomerID        string            `bson:"customerId" json:"customerId" binding:"required"`
+	CustomerName      string            `bson:"customerName" json:"customerName"`
+	CustomerEmail     string            `bson:"customerEmail" json:"customerEmail"`
+	Items             []OrderItem       `bson:"items" json:"items" binding:"required"`
+	Total             Money             `bson:"total" json:"total"`
+	Status            string            `bson:"status" json:"status"`
+	PaymentMethod     string            `bson:"paymentMethod" json:"paymentMethod"`
+	IsPaid            bool              `bson:"isPaid" json:"isPaid"`
+	CreatedAt         int64             `bson:"createdAt" json:"createdAt"`
+	Delivered         bool              `bson:"delivered" json:"delivered"`
+	DeviceID          string            `bson:"deviceId,omitempty" json:"deviceId,omitempty"`
+	DeviceName        string            `bson:"deviceName,omitempty" json:"deviceName,omitempty"`
+	Taxable           Money             `bson:"taxable" json:"taxable"`
+	CGST              Money             `bson:"cgst" json:"cgst"`
+	SGST              Money             `bson:"sgst" json:"sgst"`
+	TaxTotal          Money             `bson:"taxTotal" json:"taxTotal"`
+	InvoiceNumber     string            `bson:"invoiceNumber,omitempty" json:"invoiceNumber,omitempty"`
+	InvoicedAt        int64             `bson:"invoicedAt,omitempty" json:"invoicedAt,omitempty"`
+	RazorpayOrderID   string            `bson:"razorpay_order_id,omitempty" json:"razorpayOrderId,omitempty"`
+	RazorpayPaymentID string            `bson:"razorpay_payment_id,omitempty" json:"razorpayPaymentId,omitempty"`
+	Discounts         []AppliedDiscount `bson:"discounts,omitempty" json:"discounts,omitempty"`
+	DiscountTotal     Money             `bson:"discountTotal" json:"discountTotal"`
+	// Loyalty points put towards the order, and what they were worth
+	PointsRedeemed int64  `bson:"pointsRedeemed,omitempty" json:"pointsRedeemed,omitempty"`
+	PointsValue    Money  `bson:"pointsValue" json:"pointsValue"`
+	PointsEarned   int64  `bson:"pointsEarned,omitempty" json:"pointsEarned,omitempty"`
+	RefundedAt     int64  `bson:"refundedAt,omitempty" json:"refundedAt,omitempty"`
+	RefundedBy     string `bson:"refundedBy,omitempty" json:"refundedBy,omitempty"`
+	// Set on orders taken at the counter
+	ShiftID       string `bson:"shiftId,omitempty" json:"shiftId,omitempty"`
+	CashierID     string `bson:"cashierId,omitempty" json:"cashierId,omitempty"`
+	CashierName   string `bson:"cashierName,omitempty" json:"cashierName,omitempty"`
+	CashTendered  Money  `bson:"cashTendered" json:"cashTendered"`
+	CardReference string `bson:"cardReference,omitempty" json:"cardReference,omitempty"`
+	// Set when the payment is split into shares; the order is paid once
+	// every share is
+	SplitMode      string `bson:"splitMode,omitempty" json:"splitMode,omitempty"`
+	ShareCount     int    `bson:"shareCount,omitempty" json:"shareCount,omitempty"`
+	SplitExpiresAt int64  `bson:"splitExpiresAt,omitempty" json:"splitExpiresAt,omitempty"`
+	// Set when a meal plan covers some of the items
+	MealSubscriptionID string `bson:"mealSubscriptionId,omitempty" json:"mealSubscriptionId,omitempty"`
+	MealsCovered       int    `bson:"mealsCovered,omitempty" json:"mealsCovered,omitempty"`
+	MealValue          Money  `bson:"mealValue" json:"mealValue"`
+	// The day the meals count against
+	MealDay string `bson:"mealDay,omitempty" json:"mealDay,omitempty"`
+	// The daily menu the portions were taken from, when one was published
+	MenuDate string `bson:"menuDate,omitempty" json:"menuDate,omitempty"`
+}
+
+type Wastage struct {
+	ID          string `bson:"_id,omitempty" json:"id,omitempty"`
+	ProductID   string `bson:"productId" json:"productId" binding:"required"`
+	ProductName string `bson:"productName" json:"productName"`
+	Quantity    int    `bson:"quantity" json:"quantity" binding:"required"`
+	Reason      string `bson:"reason" json:"reason" binding:"required"`
+	Note        string `bson:"note" json:"note"`
+	// What was wasted is valued at its selling price, not what it cost
+	UnitValue  Money  `bson:"unitValue" json:"unitValue"`
+	Value      Money  `bson:"value" json:"value"`
+	RecordedBy string `bson:"recordedBy" json:"recordedBy"`
+	CreatedAt  int64  `bson:"createdAt" json:"createdAt"`
+}
+
+type MenuItem struct {
+	ProductID string `bson:"productId" json:"productId" binding:"required"`
+	Name      string `bson:"name" json:"name"`
+	Prepared  int    `bson:"prepared" json:"prepared" binding:"required"`
+	Remaining int    `bson:"remaining" json:"remaining"`
+}
+
+type DailyMenu struct {
+	ID        string     `bson:"_id,omitempty" json:"id,omitempty"`
+	Date      string     `bson:"date" json:"date"`
+	Items     []MenuItem `bson:"items" json:"items" binding:"required"`
+	UpdatedBy string     `bson:"updatedBy" json:"updatedBy"`
+	UpdatedAt int64      `bson:"updatedAt" json:"updatedAt"`
+}
+
+type Session struct {
+	ID            string `bson:"_id,omitempty" json:"id,omitempty"`
+	UserID        string `bson:"userId" json:"userId"`
+	RefreshHash   string `bson:"refreshHash" json:"-"`
+	PreviousHash  string `bson:"previousHash,omitempty" json:"-"`
+	UserAgent     string `bson:"userAgent" json:"userAgent"`
+	IP            string `bson:"ip" json:"ip"`
+	Revoked       bool   `bson:"revoked" json:"revoked"`
+	RevokedReason string `bson:"revokedReason,omitempty" json:"revokedReason,omitempty"`
+	RevokedAt     int64  `bson:"revokedAt,omitempty" json:"revokedAt,omitempty"`
+	CreatedAt     int64  `bson:"createdAt" json:"createdAt"`
+	LastUsedAt    int64  `bson:"lastUsedAt" json:"lastUsedAt"`
+	ExpiresAt     int64  `bson:"expiresAt" json:"expiresAt"`
+}
+
+type PasswordReset struct {
+	ID        string `bson:"_id,omitempty" json:"id,omitempty"`
+	UserID    string `bson:"userId" json:"userId"`
+	TokenHash string `bson:"tokenHash" json:"-"`
+	UsedAt    int64  `bson:"usedAt" json:"usedAt"`
+	CreatedAt int64  `bson:"createdAt" json:"createdAt"`
+	ExpiresAt int64  `bson:"expiresAt" json:"expiresAt"`
+}
+
+type EmailVerification struct {
+	ID        string `bson:"_id,omitempty" json:"id,omitempty"`
+	UserID    string `bson:"userId" json:"userId"`
+	Email     string `bson:"email" json:"email"`
+	TokenHash string `bson:"tokenHash" json:"-"`
+	UsedAt    int64  `bson:"usedAt" json:"usedAt"`
+	CreatedAt int64  `bson:"createdAt" json:"createdAt"`
+	ExpiresAt int64  `bson:"expiresAt" json:"expiresAt"`
+}
+
+// RosterEntry is a student or employee allowed to sign up.
+type RosterEntry struct {
+	ID         string `bson:"_id,omitempty" json:"id,omitempty"`
+	MemberID   string `bson:"memberId" json:"memberId"`
+	Name       string `bson:"name" json:"name"`
+	Department string `bson:"department" json:"department"`
+	Kind       string `bson:"kind" json:"kind"`
+	Email      string `bson:"email,omitempty" json:"email,omitempty"`
+	ClaimedBy  string `bson:"claimedBy,omitempty" json:"claimedBy,omitempty"`
+	ImportedAt int64  `bson:"importedAt" json:"importedAt"`
+}
+
+type AuditLog struct {
+	ID         string                 `bson:"_id,omitempty" json:"id,omitempty"`
+	ActorID    string                 `bson:"actorId" json:"actorId"`
+	ActorName  string                 `bson:"actorName" json:"actorName"`
+	Action     string                 `bson:"action" json:"action"`
+	TargetType string                 `bson:"targetType" json:"targetType"`
+	TargetID   string                 `bson:"targetId" json:"targetId"`
+	Details    map[string]interface{} `bson:"details,omitempty" json:"details,omitempty"`
+	IP         string                 `bson:"ip" json:"ip"`
+	CreatedAt  int64                  `bson:"createdAt" json:"createdAt"`
+}
+
+type AuthEvent struct {
+	ID        string `bson:"_id,omitempty" json:"id,omitempty"`
+	Type      string `bson:"type" json:"type"`
+	UserID    string `bson:"userId,omitempty" json:"userId,omitempty"`
+	Email     string `bson:"email" json:"email"`
+	Reason    string `bson:"reason,omitempty" json:"reason,omitempty"`
+	IP        string `bson:"ip" json:"ip"`
+	UserAgent string `bson:"userAgent" json:"userAgent"`
+	CreatedAt int64  `bson:"createdAt" json:"createdAt"`
+}
+
+// MFAChallenge is the half-finished login handed out after the password
+// check, redeemed with a TOTP or recovery code.
+type MFAChallenge struct {
+	ID        string `bson:"_id,omitempty" json:"id,omitempty"`
+	UserID    string `bson:"userId" json:"userId"`
+	TokenHash string `bson:"tokenHash" json:"-"`
+	// Set when the login enrols in 2FA, to the enrolment code it was started with
+	EnrolmentHash string `bson:"enrolmentHash,omitempty" json:"-"`
+	Attempts      int    `bson:"attempts" json:"attempts"`
+	UsedAt        int64  `bson:"usedAt" json:"usedAt"`
+	CreatedAt     int64  `bson:"createdAt" json:"createdAt"`
+	ExpiresAt     int64  `bson:"expiresAt" json:"expiresAt"`
+}
+
+// APIKey lets a kiosk or POS terminal call the API without a person logging
+// in. Only the hash of the key is stored; Prefix helps admins tell keys apart.
+type APIKey struct {
+	ID         string   `bson:"_id,omitempty" json:"id,omitempty"`
+	Name       string   `bson:"name" json:"name"`
+	Kind       string   `bson:"kind" json:"kind"`
+	Scopes     []string `bson:"scopes" json:"scopes"`
+	KeyHash    string   `bson:"keyHash" json:"-"`
+	Prefix     string   `bson:"prefix" json:"prefix"`
+	CreatedBy  string   `bson:"createdBy" json:"createdBy"`
+	CreatedAt  int64    `bson:"createdAt" json:"createdAt"`
+	LastUsedAt int64    `bson:"lastUsedAt" json:"lastUsedAt"`
+	Revoked    bool     `bson:"revoked" json:"revoked"`
+	RevokedAt  int64    `bson:"revokedAt,omitempty" json:"revokedAt,omitempty"`
+}
+
+// Wallet is a user's prepaid balance in paise. It is kept in step with the
+// ledger, which can always rebuild it.
+type Wallet struct {
+	ID        string `bson:"_id,omitempty" json:"id,omitempty"`
+	UserID    string `bson:"userId" json:"userId"`
+	Balance   int64  `bson:"balance" json:"balance"`
+	UpdatedAt int64  `bson:"updatedAt" json:"updatedAt"`
+}
+
+// LedgerEntry is one posting of a double-entry journal. Amounts are in paise
+// and the postings sharing a JournalID always sum to zero; an account's
+// balance is the sum of its postings.
+type LedgerEntry struct {
+	ID        string `bson:"_id,omitempty" json:"id,omitempty"`
+	JournalID string `bson:"journalId" json:"journalId"`
+	Account   string `bson:"account" json:"account"`
+	Amount    int64  `bson:"amount" json:"amount"`
+	Kind      string `bson:"kind" json:"kind"`
+	Reference string `bson:"reference" json:"reference"`
+	CreatedAt int64  `bson:"createdAt" json:"createdAt"`
+}
+
+// WalletTopup is a top-up paid through Razorpay, credited once verified.
+type WalletTopup struct {
+	ID                string `bson:"_id,omitempty" json:"id,omitempty"`
+	UserID            string `bson:"userId" json:"userId"`
+	Amount            int64  `bson:"amount" json:"amount"`
+	RazorpayOrderID   string `bson:"razorpayOrderId" json:"razorpayOrderId"`
+	RazorpayPaymentID string `bson:"razorpayPaymentId,omitempty" json:"razorpayPaymentId,omitempty"`
+	Status            string `bson:"status" json:"status"`
+	CreatedAt         int64  `bson:"createdAt" json:"createdAt"`
+	PaidAt            int64  `bson:"paidAt,omitempty" json:"paidAt,omitempty"`
+}
+
+// Promotion is a discount rule, applied automatically or, when it has a
+// Code, once the customer enters that coupon. PercentOff is in basis points.
+// DailyFrom and DailyTo ("HH:MM", IST) limit it to part of each day.
+type Promotion struct {
+	ID             string   `bson:"_id,omitempty" json:"id,omitempty"`
+	Code           string   `bson:"code,omitempty" json:"code,omitempty"`
+	Name           string   `bson:"name" json:"name" binding:"required"`
+	Type           string   `bson:"type" json:"type" binding:"required"`
+	PercentOff     int64    `bson:"percentOff,omitempty" json:"percentOff,omitempty"`
+	AmountOff      Money    `bson:"amountOff" json:"amountOff"`
+	MaxDiscount    Money    `bson:"maxDiscount" json:"maxDiscount"`
+	MinOrder       Money    `bson:"minOrder" json:"minOrder"`
+	BuyQuantity    int      `bson:"buyQuantity,omitempty" json:"buyQuantity,omitempty"`
+	GetQuantity    int      `bson:"getQuantity,omitempty" json:"getQuantity,omitempty"`
+	ProductIDs     []string `bson:"productIds,omitempty" json:"productIds,omitempty"`
+	Categories     []string `bson:"categories,omitempty" json:"categories,omitempty"`
+	StartsAt       int64    `bson:"startsAt,omitempty" json:"startsAt,omitempty"`
+	EndsAt         int64    `bson:"endsAt,omitempty" json:"endsAt,omitempty"`
+	DailyFrom      string   `bson:"dailyFrom,omitempty" json:"dailyFrom,omitempty"`
+	DailyTo        string   `bson:"dailyTo,omitempty" json:"dailyTo,omitempty"`
+	FirstOrderOnly bool     `bson:"firstOrderOnly" json:"firstOrderOnly"`
+	PerUserLimit   int      `bson:"perUserLimit,omitempty" json:"perUserLimit,omitempty"`
+	TotalLimit     int      `bson:"totalLimit,omitempty" json:"totalLimit,omitempty"`
+	Redemptions    int      `bson:"redemptions" json:"redemptions"`
+	Active         bool     `bson:"active" json:"active"`
+	CreatedBy      string   `bson:"createdBy" json:"createdBy"`
+	CreatedAt      int64    `bson:"createdAt" json:"createdAt"`
+}
+
+// AppliedDiscount is a promotion as it was applied to an order.
+type AppliedDiscount struct {
+	PromotionID string `bson:"promotionId" json:"promotionId"`
+	Code        string `bson:"code,omitempty" json:"code,omitempty"`
+	Name        string `bson:"name" json:"name"`
+	Amount      Money  `bson:"amount" json:"amount"`
+}
+
+// PromoRedemption records one use of a promotion, from when the order using
+// it is placed.
+type PromoRedemption struct {
+	ID          string `bson:"_id,omitempty" json:"id,omitempty"`
+	PromotionID string `bson:"promotionId" json:"promotionId"`
+	UserID      string `bson:"userId" json:"userId"`
+	OrderID     string `bson:"orderId" json:"orderId"`
+	Amount      Money  `bson:"amount" json:"amount"`
+	// Set when the promotion is for first orders only
+	FirstOrder bool  `bson:"firstOrder,omitempty" json:"firstOrder,omitempty"`
+	CreatedAt  int64 `bson:"createdAt" json:"createdAt"`
+}
+
+// PointLot is a batch of loyalty points earned together. Points are spent
+// from the lots that expire first, and whatever is left in a lot when it
+// expires is lost.
+type PointLot struct {
+	ID        string `bson:"_id,omitempty" json:"id,omitempty"`
+	UserID    string `bson:"userId" json:"userId"`
+	OrderID   string `bson:"orderId,omitempty" json:"orderId,omitempty"`
+	Points    int64  `bson:"points" json:"points"`
+	Remaining int64  `bson:"remaining" json:"remaining"`
+	EarnedAt  int64  `bson:"earnedAt" json:"earnedAt"`
+	ExpiresAt int64  `bson:"expiresAt" json:"expiresAt"`
+}
+
+// PointEntry is one movement in a user's points history. Points are
+// positive when earned or restored and negative when spent, expired or
+// reversed.
+type PointEntry struct {
+	ID        string `bson:"_id,omitempty" json:"id,omitempty"`
+	UserID    string `bson:"userId" json:"userId"`
+	Kind      string `bson:"kind" json:"kind"`
+	Points    int64  `bson:"points" json:"points"`
+	OrderID   string `bson:"orderId,omitempty" json:"orderId,omitempty"`
+	CreatedAt int64  `bson:"createdAt" json:"createdAt"`
+}
+
+// CashShift is one cashier's session at the till. The drawer should hold the
+// opening float plus cash taken, less cash paid out; closing the shift
+// records what was actually counted.
+type CashShift struct {
+	ID           string `bson:"_id,omitempty" json:"id,omitempty"`
+	CashierID    string `bson:"cashierId" json:"cashierId"`
+	CashierName  string `bson:"cashierName" json:"cashierName"`
+	Status       string `bson:"status" json:"status"`
+	OpeningFloat Money  `bson:"openingFloat" json:"openingFloat"`
+	CashSales    Money  `bson:"cashSales" json:"cashSales"`
+	CardSales    Money  `bson:"cardSales" json:"cardSales"`
+	CashTopups   Money  `bson:"cashTopups" json:"cashTopups"`
+	CashRefunds  Money  `bson:"cashRefunds" json:"cashRefunds"`
+	Orders       int    `bson:"orders" json:"orders"`
+	Expected     Money  `bson:"expected" json:"expected"`
+	Counted      Money  `bson:"counted" json:"counted"`
+	Variance     Money  `bson:"variance" json:"variance"`
+	Note         string `bson:"note,omitempty" json:"note,omitempty"`
+	OpenedAt     int64  `bson:"openedAt" json:"openedAt"`
+	ClosedAt     int64  `bson:"closedAt,omitempty" json:"closedAt,omitempty"`
+}
+
+// Payment is one attempt to pay for an order, kept for disputes and
+// chargebacks. Gateway payments start as created when the gateway order is
+// opened and end captured or failed; payments taken directly, from a wallet
+// or at the counter, are recorded captured.
+type Payment struct {
+	ID                string `bson:"_id,omitempty" json:"id,omitempty"`
+	OrderID           string `bson:"orderId" json:"orderId"`
+	UserID            string `bson:"userId,omitempty" json:"userId,omitempty"`
+	Provider          string `bson:"provider" json:"provider"`
+	ProviderOrderID   string `bson:"providerOrderId,omitempty" json:"providerOrderId,omitempty"`
+	ProviderPaymentID string `bson:"providerPaymentId,omitempty" json:"providerPaymentId,omitempty"`
+	Amount            Money  `bson:"amount" json:"amount"`
+	Status            string `bson:"status" json:"status"`
+	Signature         string `bson:"signature,omitempty" json:"signature,omitempty"`
+	SignatureValid    bool   `bson:"signatureValid" json:"signatureValid"`
+	FailureCode       string `bson:"failureCode,omitempty" json:"failureCode,omitempty"`
+	FailureReason     string `bson:"failureReason,omitempty" json:"failureReason,omitempty"`
+	RefundID          string `bson:"refundId,omitempty" json:"refundId,omitempty"`
+	CreatedAt         int64  `bson:"createdAt" json:"createdAt"`
+	UpdatedAt         int64  `bson:"updatedAt" json:"updatedAt"`
+	CapturedAt        int64  `bson:"capturedAt,omitempty" json:"capturedAt,omitempty"`
+	FailedAt          int64  `bson:"failedAt,omitempty" json:"failedAt,omitempty"`
+	RefundedAt        int64  `bson:"refundedAt,omitempty" json:"refundedAt,omitempty"`
+}
+
+// PaymentShare is one payer's part of a split order, paid through its own
+// gateway order. ItemIndexes lists the order items it covers when the order
+// is split by item.
+type PaymentShare struct {
+	ID                string `bson:"_id,omitempty" json:"id,omitempty"`
+	OrderID           string `bson:"orderId" json:"orderId"`
+	Index             int    `bson:"index" json:"index"`
+	Label             string `bson:"label,omitempty" json:"label,omitempty"`
+	ItemIndexes       []int  `bson:"itemIndexes,omitempty" json:"itemIndexes,omitempty"`
+	Amount            Money  `bson:"amount" json:"amount"`
+	RazorpayOrderID   string `bson:"razorpayOrderId" json:"razorpayOrderId"`
+	RazorpayPaymentID string `bson:"razorpayPaymentId,omitempty" json:"razorpayPaymentId,omitempty"`
+	Status            string `bson:"status" json:"status"`
+	PaidBy            string `bson:"paidBy,omitempty" json:"paidBy,omitempty"`
+	CreatedAt         int64  `bson:"createdAt" json:"createdAt"`
+	PaidAt            int64  `bson:"paidAt,omitempty" json:"paidAt,omitempty"`
+	RefundID          string `bson:"refundId,omitempty" json:"refundId,omitempty"`
+	RefundedAt        int64  `bson:"refundedAt,omitempty" json:"refundedAt,omitempty"`
+}
+
+// MealPlan is a subscription customers can buy: Meals meals to be eaten
+// within DurationDays, at most MealsPerDay a day, each one unit of any of
+// ProductIDs. UnusedMeals is "expire" or "rollover"; rolled over meals carry
+// into the customer's next subscription to the same plan.
+type MealPlan struct {
+	ID           string   `bson:"_id,omitempty" json:"id,omitempty"`
+	Name         string   `bson:"name" json:"name" binding:"required"`
+	Description  string   `bson:"description,omitempty" json:"description,omitempty"`
+	Price        Money    `bson:"price" json:"price"`
+	Meals        int      `bson:"meals" json:"meals" binding:"required"`
+	MealsPerDay  int      `bson:"mealsPerDay" json:"mealsPerDay"`
+	DurationDays int      `bson:"durationDays" json:"durationDays"`
+	ProductIDs   []string `bson:"productIds" json:"productIds" binding:"required"`
+	UnusedMeals  string   `bson:"unusedMeals" json:"unusedMeals"`
+	Active       bool     `bson:"active" json:"active"`
+	CreatedBy    string   `bson:"createdBy" json:"createdBy"`
+	CreatedAt    int64    `bson:"createdAt" json:"createdAt"`
+}
+
+// MealSubscription is one purchase of a meal plan. The plan's terms are
+// copied in, so editing the plan doesn't change what was bought. MealsTotal
+// is the plan's meals plus any RolledOver from the previous subscription.
+type MealSubscription struct {
+	ID           string   `bson:"_id,omitempty" json:"id,omitempty"`
+	UserID       string   `bson:"userId" json:"userId"`
+	PlanID       string   `bson:"planId" json:"planId"`
+	PlanName     string   `bson:"planName" json:"planName"`
+	Price        Money    `bson:"price" json:"price"`
+	MealsPerDay  int      `bson:"mealsPerDay" json:"mealsPerDay"`
+	DurationDays int      `bson:"durationDays" json:"durationDays"`
+	ProductIDs   []string `bson:"productIds" json:"productIds"`
+	UnusedMeals  string   `bson:"unusedMeals" json:"unusedMeals"`
+	MealsTotal   int      `bson:"mealsTotal" json:"mealsTotal"`
+	RolledOver   int      `bson:"rolledOver" json:"rolledOver"`
+	MealsUsed    int      `bson:"mealsUsed" json:"mealsUsed"`
+	// Meals taken on UsedDay, the last day any were, for the daily limit
+	UsedDay           string `bson:"usedDay,omitempty" json:"usedDay,omitempty"`
+	UsedToday         int    `bson:"usedToday" json:"usedToday"`
+	Status            string `bson:"status" json:"status"`
+	StartsAt          int64  `bson:"startsAt,omitempty" json:"startsAt,omitempty"`
+	EndsAt            int64  `bson:"endsAt,omitempty" json:"endsAt,omitempty"`
+	RazorpayOrderID   string `bson:"razorpayOrderId" json:"razorpayOrderId"`
+	RazorpayPaymentID string `bson:"razorpayPaymentId,omitempty" json:"razorpayPaymentId,omitempty"`
+	CreatedAt         int64  `bson:"createdAt" json:"createdAt"`
+	PaidAt            int64  `bson:"paidAt,omitempty" json:"paidAt,omitempty"`
+	// Meals left over when the subscription ended
+	MealsForfeited int `bson:"mealsForfeited,omitempty" json:"mealsForfeited,omitempty"`
+}
+
+// MealRedemption records the meals a paid order took from a subscription.
+// Day is the local date, YYYY-MM-DD, for the daily limit.
+type MealRedemption struct {
+	ID             string `bson:"_id,omitempty" json:"id,omitempty"`
+	SubscriptionID string `bson:"subscriptionId" json:"subscriptionId"`
+	PlanID         string `bson:"planId" json:"planId"`
+	UserID         string `bson:"userId" json:"userId"`
+	OrderID        string `bson:"orderId" json:"orderId"`
+	Meals          int    `bson:"meals" json:"meals"`
+	Value          Money  `bson:"value" json:"value"`
+	Day            string `bson:"day" json:"day"`
+	CreatedAt      int64  `bson:"createdAt" json:"createdAt"`
 }
@@ -0,0 +1,158 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+)
+
+// DefaultCurrency is what every amount is in unless it says otherwise.
+const DefaultCurrency = "INR"
+
+var errInvalidMoney = errors.New("invalid money amount")
+
+// Money is an amount in the currency's minor unit (paise for INR), so sums
+// and Razorpay amounts are exact. In JSON it stays a plain major-unit number
+// such as 19.99; in MongoDB it is stored as {amount, currency}.
+type Money struct {
+	Amount   int64  `bson:"amount"`
+	Currency string `bson:"currency"`
+}
+
+// Paise returns an INR amount.
+func Paise(amount int64) Money {
+	return Money{Amount: amount, Currency: DefaultCurrency}
+}
+
+// ParseMoney reads a major-unit decimal such as "19.99" without going
+// through float64. More than two decimal places is an error.
+func ParseMoney(s string) (Money, error) {
+	s = strings.TrimSpace(s)
+	negative := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+
+	whole, frac, hasFrac := strings.Cut(s, ".")
+	if whole == "" && !hasFrac || len(frac) > 2 || hasFrac && frac == "" {
+		return Money{}, errInvalidMoney
+	}
+	if whole == "" {
+		whole = "0"
+	}
+	for len(frac) < 2 {
+		frac += "0"
+	}
+	for _, r := range whole + frac {
+		if r < '0' || r > '9' {
+			return Money{}, errInvalidMoney
+		}
+	}
+
+	units, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil || units > math.MaxInt64/100-1 {
+		return Money{}, errInvalidMoney
+	}
+	cents, _ := strconv.ParseInt(frac, 10, 64)
+
+	amount := units*100 + cents
+	if negative {
+		amount = -amount
+	}
+	return Paise(amount), nil
+}
+
+func (m Money) currency() string {
+	if m.Currency == "" {
+		return DefaultCurrency
+	}
+	return m.Currency
+}
+
+func (m Money) Add(o Money) Money {
+	return Money{Amount: m.Amount + o.Amount, Currency: m.currency()}
+}
+
+func (m Money) Sub(o Money) Money {
+	return Money{Amount: m.Amount - o.Amount, Currency: m.currency()}
+}
+
+// Mul returns the amount for n units, e.g. a line total.
+func (m Money) Mul(n int) Money {
+	return Money{Amount: m.Amount * int64(n), Currency: m.currency()}
+}
+
+func (m Money) IsZero() bool {
+	return m.Amount == 0
+}
+
+// String formats the amount in major units with two decimals, e.g. "19.99".
+func (m Money) String() string {
+	amount := m.Amount
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, amount/100, amount%100)
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// UnmarshalJSON accepts a number or a numeric string in major units.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if s == "null" {
+		return nil
+	}
+	if unquoted, err := strconv.Unquote(s); err == nil {
+		s = unquoted
+	}
+	parsed, err := ParseMoney(s)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+func (m Money) MarshalBSONValue() (bsontype.Type, []byte, error) {
+	return bson.MarshalValue(bson.D{
+		{Key: "amount", Value: m.Amount},
+		{Key: "currency", Value: m.currency()},
+	})
+}
+
+// UnmarshalBSONValue reads {amount, currency} documents, and also the bare
+// rupee numbers stored before amounts were kept in paise.
+func (m *Money) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+	raw := bson.RawValue{Type: t, Value: data}
+
+	switch t {
+	case bson.TypeEmbeddedDocument:
+		var doc struct {
+			Amount   int64  `bson:"amount"`
+			Currency string `bson:"currency"`
+		}
+		if err := raw.Unmarshal(&doc); err != nil {
+			return err
+		}
+		*m = Money{Amount: doc.Amount, Currency: doc.Currency}
+	case bson.TypeDouble:
+		*m = Paise(int64(math.Round(raw.Double() * 100)))
+	case bson.TypeInt32:
+		*m = Paise(int64(raw.Int32()) * 100)
+	case bson.TypeInt64:
+		*m = Paise(raw.Int64() * 100)
+	case bson.TypeNull:
+		*m = Money{}
+	default:
+		return fmt.Errorf("cannot decode %s into Money", t)
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package model
+
+import (
+	"encoding/json"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{in: "19.99", want: 1999},
+		{in: "19.9", want: 1990},
+		{in: "19", want: 1900},
+		{in: ".5", want: 50},
+		{in: "0.01", want: 1},
+		{in: " 7 ", want: 700},
+		{in: "-3.25", want: -325},
+		{in: "", wantErr: true},
+		{in: "-", wantErr: true},
+		{in: "1.", wantErr: true},
+		{in: "1.234", wantErr: true},
+		{in: "1e3", wantErr: true},
+		{in: "1.-5", wantErr: true},
+		{in: "abc", wantErr: true},
+		{in: "92233720368547758", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseMoney(tt.in)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParseMoney(%q) = %v, want an error", tt.in, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseMoney(%q): %v", tt.in, err)
+			}
+			if got.Amount != tt.want || got.Currency != DefaultCurrency {
+				t.Fatalf("ParseMoney(%q) = %+v, want %d %s", tt.in, got, tt.want, DefaultCurrency)
+			}
+		})
+	}
+}
+
+func TestMoneyJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want int64
+		out  string
+	}{
+		{name: "number", in: `19.99`, want: 1999, out: `19.99`},
+		{name: "string", in: `"12.5"`, want: 1250, out: `12.50`},
+		{name: "whole", in: `40`, want: 4000, out: `40.00`},
+		{name: "one paisa", in: `0.01`, want: 1, out: `0.01`},
+		{name: "negative", in: `-0.5`, want: -50, out: `-0.50`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var m Money
+			if err := json.Unmarshal([]byte(tt.in), &m); err != nil {
+				t.Fatalf("unmarshal %s: %v", tt.in, err)
+			}
+			if m.Amount != tt.want {
+				t.Fatalf("unmarshal %s = %d paise, want %d", tt.in, m.Amount, tt.want)
+			}
+			out, err := json.Marshal(m)
+			if err != nil {
+				t.Fatalf("marshal: %v", err)
+			}
+			if string(out) != tt.out {
+				t.Fatalf("marshal = %s, want %s", out, tt.out)
+			}
+		})
+	}
+
+	var m Money
+	if err := json.Unmarshal([]byte(`1.999`), &m); err == nil {
+		t.Fatalf("unmarshal 1.999 = %+v, want an error", m)
+	}
+}
+
+func TestMoneyBSON(t *testing.T) {
+	type doc struct {
+		Price Money `bson:"price"`
+	}
+
+	tests := []struct {
+		name  string
+		price interface{}
+		want  Money
+	}{
+		{name: "round trip", price: Paise(1999), want: Paise(1999)},
+		{name: "currency kept", price: Money{Amount: 500, Currency: "USD"}, want: Money{Amount: 500, Currency: "USD"}},
+		{name: "no currency stored as default", price: Money{Amount: 500}, want: Paise(500)},
+		{name: "legacy double", price: 19.99, want: Paise(1999)},
+		{name: "legacy int32", price: int32(40), want: Paise(4000)},
+		{name: "legacy int64", price: int64(40), want: Paise(4000)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := bson.Marshal(bson.M{"price": tt.price})
+			if err != nil {
+				t.Fatalf("marshal: %v", err)
+			}
+			var got doc
+			if err := bson.Unmarshal(data, &got); err != nil {
+				t.Fatalf("unmarshal: %v", err)
+			}
+			if got.Price != tt.want {
+				t.Fatalf("price = %+v, want %+v", got.Price, tt.want)
+			}
+		})
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"log"
 	"time"
 
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
@@ -32,3 +33,185 @@ func Connect() {
 func GetCollection(dbName, colName string) *mongo.Collection {
 	return Client.Database(dbName).Collection(colName)
 }
+
+// EnsureIndexes creates the indexes the application relies on for
+// uniqueness. Failures are logged rather than fatal so existing duplicate
+// data doesn't stop the server from starting.
+func EnsureIndexes() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	users := GetCollection("smartcanteen", "users")
+	_, err := users.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "email", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		log.Println("⚠️ Could not create unique email index:", err)
+	}
+
+	roster := GetCollection("smartcanteen", "roster")
+	_, err = roster.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "memberId", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		log.Println("⚠️ Could not create unique roster index:", err)
+	}
+
+	attempts := GetCollection("smartcanteen", "login_attempts")
+	_, err = attempts.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "key", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		log.Println("⚠️ Could not create login attempts index:", err)
+	}
+
+	apiKeys := GetCollection("smartcanteen", "api_keys")
+	_, err = apiKeys.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "keyHash", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		log.Println("⚠️ Could not create API key index:", err)
+	}
+
+	wallets := GetCollection("smartcanteen", "wallets")
+	_, err = wallets.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "userId", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		log.Println("⚠️ Could not create wallet index:", err)
+	}
+
+	topups := GetCollection("smartcanteen", "wallet_topups")
+	_, err = topups.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "razorpayOrderId", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		log.Println("⚠️ Could not create wallet top-up index:", err)
+	}
+
+	ledger := GetCollection("smartcanteen", "ledger_entries")
+	_, err = ledger.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "account", Value: 1}, {Key: "createdAt", Value: -1}},
+	})
+	if err != nil {
+		log.Println("⚠️ Could not create ledger index:", err)
+	}
+
+	orders := GetCollection("smartcanteen", "orders")
+	_, err = orders.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "invoiceNumber", Value: 1}},
+		Options: options.Index().SetUnique(true).SetSparse(true),
+	})
+	if err != nil {
+		log.Println("⚠️ Could not create invoice number index:", err)
+	}
+
+	// Automatic promotions have no code, so only coupons need be unique
+	promotions := GetCollection("smartcanteen", "promotions")
+	_, err = promotions.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "code", Value: 1}},
+		Options: options.Index().SetUnique(true).SetSparse(true),
+	})
+	if err != nil {
+		log.Println("⚠️ Could not create promotion code index:", err)
+	}
+
+	redemptions := GetCollection("smartcanteen", "promo_redemptions")
+	_, err = redemptions.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "userId", Value: 1}, {Key: "promotionId", Value: 1}},
+	})
+	if err != nil {
+		log.Println("⚠️ Could not create promotion redemption index:", err)
+	}
+	_, err = redemptions.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "userId", Value: 1}},
+		Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{
+			"firstOrder": true,
+		}),
+	})
+	if err != nil {
+		log.Println("⚠️ Could not create first order redemption index:", err)
+	}
+
+	lots := GetCollection("smartcanteen", "loyalty_lots")
+	_, err = lots.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "userId", Value: 1}, {Key: "expiresAt", Value: 1}},
+	})
+	if err != nil {
+		log.Println("⚠️ Could not create loyalty lots index:", err)
+	}
+
+	pointEntries := GetCollection("smartcanteen", "loyalty_entries")
+	_, err = pointEntries.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "userId", Value: 1}, {Key: "createdAt", Value: -1}},
+	})
+	if err != nil {
+		log.Println("⚠️ Could not create loyalty history index:", err)
+	}
+
+	// A cashier can only have one shift open at a time
+	shifts := GetCollection("smartcanteen", "cash_shifts")
+	_, err = shifts.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "cashierId", Value: 1}},
+		Options: options.Index().SetUnique(true).
+			SetPartialFilterExpression(bson.M{"status": "open"}),
+	})
+	if err != nil {
+		log.Println("⚠️ Could not create open shift index:", err)
+	}
+
+	payments := GetCollection("smartcanteen", "payments")
+	_, err = payments.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "orderId", Value: 1}, {Key: "createdAt", Value: 1}}},
+		{Keys: bson.D{{Key: "providerOrderId", Value: 1}}},
+		// Failed attempts are only reported by the checkout, so the same
+		// payment ID may turn up on several of them; a payment is captured
+		// once
+		{
+			Keys: bson.D{{Key: "providerPaymentId", Value: 1}},
+			Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{
+				"status":            "captured",
+				"providerPaymentId": bson.M{"$exists": true},
+			}),
+		},
+	})
+	if err != nil {
+		log.Println("⚠️ Could not create payment indexes:", err)
+	}
+
+	shares := GetCollection("smartcanteen", "payment_shares")
+	_, err = shares.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "orderId", Value: 1}, {Key: "index", Value: 1}}},
+		{Keys: bson.D{{Key: "razorpayOrderId", Value: 1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}}},
+	})
+	if err != nil {
+		log.Println("⚠️ Could not create payment share indexes:", err)
+	}
+
+	subscriptions := GetCollection("smartcanteen", "meal_subscriptions")
+	_, err = subscriptions.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "userId", Value: 1}, {Key: "status", Value: 1}, {Key: "endsAt", Value: 1}}},
+		{Keys: bson.D{{Key: "razorpayOrderId", Value: 1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "endsAt", Value: 1}}},
+	})
+	if err != nil {
+		log.Println("⚠️ Could not create meal subscription indexes:", err)
+	}
+
+	mealRedemptions := GetCollection("smartcanteen", "meal_redemptions")
+	_, err = mealRedemptions.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "subscriptionId", Value: 1}, {Key: "day", Value: 1}}},
+		{Keys: bson.D{{Key: "orderId", Value: 1}}},
+		{Keys: bson.D{{Key: "createdAt", Value: 1}}},
+	})
+	if err != nil {
+		log.Println("⚠️ Could not create meal redemption indexes:", err)
+	}
+}
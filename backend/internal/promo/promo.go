@@ -0,0 +1,293 @@
+// Package promo decides which promotions apply to an order and how much each
+// one takes off every line. It does no I/O; callers load the promotions and
+// the customer's history.
+package promo
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/internal/model"
+)
+
+// Promotion types
+const (
+	Percentage = "percentage"
+	Flat       = "flat"
+	BuyXGetY   = "buy_x_get_y"
+)
+
+var ist = time.FixedZone("IST", 5*60*60+30*60)
+
+// Line is one order line as promotions see it.
+type Line struct {
+	ProductID string
+	Category  string
+	UnitPrice model.Money
+	Quantity  int
+}
+
+func (l Line) gross() model.Money {
+	return l.UnitPrice.Mul(l.Quantity)
+}
+
+// Customer is what eligibility rules need to know about who is ordering.
+// Anonymous orders, e.g. from a kiosk, have no UserID.
+type Customer struct {
+	UserID string
+	// FirstOrder is true when the customer has no paid orders yet
+	FirstOrder bool
+	// Uses counts the customer's past redemptions per promotion ID
+	Uses map[string]int
+}
+
+// Result is the outcome of applying promotions to a set of lines.
+type Result struct {
+	// LineDiscounts has one entry per line, in the same order
+	LineDiscounts []model.Money
+	Applied       []model.AppliedDiscount
+	Total         model.Money
+}
+
+// Validate checks a promotion's definition before it is saved.
+func Validate(p model.Promotion) error {
+	switch p.Type {
+	case Percentage:
+		if p.PercentOff <= 0 || p.PercentOff > 10000 {
+			return errors.New("percentOff must be between 1 and 10000 basis points")
+		}
+	case Flat:
+		if p.AmountOff.Amount <= 0 {
+			return errors.New("amountOff must be positive")
+		}
+	case BuyXGetY:
+		if p.BuyQuantity <= 0 || p.GetQuantity <= 0 {
+			return errors.New("buyQuantity and getQuantity must be positive")
+		}
+	default:
+		return fmt.Errorf("unknown promotion type %q", p.Type)
+	}
+	if p.EndsAt != 0 && p.EndsAt <= p.StartsAt {
+		return errors.New("endsAt must be after startsAt")
+	}
+	if (p.DailyFrom == "") != (p.DailyTo == "") {
+		return errors.New("dailyFrom and dailyTo must be set together")
+	}
+	if p.DailyFrom != "" {
+		if _, err := clockMinutes(p.DailyFrom); err != nil {
+			return err
+		}
+		if _, err := clockMinutes(p.DailyTo); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// clockMinutes parses "HH:MM" into minutes after midnight.
+func clockMinutes(s string) (int, error) {
+	h, m, ok := strings.Cut(s, ":")
+	hours, err1 := strconv.Atoi(h)
+	minutes, err2 := strconv.Atoi(m)
+	if !ok || err1 != nil || err2 != nil || hours < 0 || hours > 23 || minutes < 0 || minutes > 59 {
+		return 0, fmt.Errorf("invalid time of day %q, expected HH:MM", s)
+	}
+	return hours*60 + minutes, nil
+}
+
+// inScope reports whether a promotion covers the line. Promotions without
+// products or categories cover everything.
+func inScope(p model.Promotion, line Line) bool {
+	if len(p.ProductIDs) == 0 && len(p.Categories) == 0 {
+		return true
+	}
+	for _, id := range p.ProductIDs {
+		if id == line.ProductID {
+			return true
+		}
+	}
+	for _, category := range p.Categories {
+		if line.Category != "" && strings.EqualFold(category, line.Category) {
+			return true
+		}
+	}
+	return false
+}
+
+// Eligible reports why a promotion can't be used right now, or nil if it
+// can.
+func Eligible(p model.Promotion, lines []Line, customer Customer, now time.Time) error {
+	if !p.Active {
+		return errors.New("this offer is not active")
+	}
+	if p.StartsAt != 0 && now.Unix() < p.StartsAt {
+		return errors.New("this offer has not started yet")
+	}
+	if p.EndsAt != 0 && now.Unix() >= p.EndsAt {
+		return errors.New("this offer has ended")
+	}
+	if p.DailyFrom != "" {
+		from, _ := clockMinutes(p.DailyFrom)
+		to, _ := clockMinutes(p.DailyTo)
+		local := now.In(ist)
+		minute := local.Hour()*60 + local.Minute()
+		if minute < from || minute >= to {
+			return fmt.Errorf("this offer is only valid from %s to %s", p.DailyFrom, p.DailyTo)
+		}
+	}
+	if p.TotalLimit > 0 && p.Redemptions >= p.TotalLimit {
+		return errors.New("this offer has been fully redeemed")
+	}
+	if p.FirstOrderOnly && (customer.UserID == "" || !customer.FirstOrder) {
+		return errors.New("this offer is only valid on your first order")
+	}
+	if p.PerUserLimit > 0 {
+		if customer.UserID == "" {
+			return errors.New("this offer needs a signed-in customer")
+		}
+		if customer.Uses[p.ID] >= p.PerUserLimit {
+			return errors.New("you have already used this offer")
+		}
+	}
+
+	scoped := model.Paise(0)
+	for _, line := range lines {
+		if inScope(p, line) {
+			scoped = scoped.Add(line.gross())
+		}
+	}
+	if scoped.IsZero() {
+		return errors.New("no items in your cart qualify for this offer")
+	}
+	if scoped.Amount < p.MinOrder.Amount {
+		return fmt.Errorf("this offer needs a minimum order of %s", p.MinOrder)
+	}
+	return nil
+}
+
+// divRound divides with halves rounded up, for non-negative operands.
+func divRound(a, b int64) int64 {
+	return (a*2 + b) / (b * 2)
+}
+
+// discounts works out what one promotion takes off each line, given what is
+// still left to pay on every line.
+func discounts(p model.Promotion, lines []Line, remaining []int64) []int64 {
+	off := make([]int64, len(lines))
+
+	switch p.Type {
+	case BuyXGetY:
+		// Every buy+get units of the same product, get of them are free, at
+		// what is left to pay on them after the promotions before
+		for i, line := range lines {
+			if !inScope(p, line) || line.Quantity == 0 {
+				continue
+			}
+			free := line.Quantity / (p.BuyQuantity + p.GetQuantity) * p.GetQuantity
+			off[i] = divRound(remaining[i]*int64(free), int64(line.Quantity))
+		}
+
+	case Percentage:
+		for i, line := range lines {
+			if inScope(p, line) {
+				off[i] = divRound(remaining[i]*p.PercentOff, 10000)
+			}
+		}
+
+	case Flat:
+		// Spread over the covered lines in proportion to what they cost
+		var base int64
+		for i, line := range lines {
+			if inScope(p, line) {
+				base += remaining[i]
+			}
+		}
+		amount := p.AmountOff.Amount
+		if amount > base {
+			amount = base
+		}
+		last := -1
+		var given int64
+		for i, line := range lines {
+			if inScope(p, line) && base > 0 {
+				off[i] = amount * remaining[i] / base
+				given += off[i]
+				last = i
+			}
+		}
+		if last >= 0 {
+			off[last] += amount - given
+		}
+	}
+
+	// A cap is taken back from the last lines first
+	if p.MaxDiscount.Amount > 0 {
+		var total int64
+		for _, v := range off {
+			total += v
+		}
+		excess := total - p.MaxDiscount.Amount
+		for i := len(off) - 1; i >= 0 && excess > 0; i-- {
+			cut := off[i]
+			if cut > excess {
+				cut = excess
+			}
+			off[i] -= cut
+			excess -= cut
+		}
+	}
+
+	for i := range off {
+		if off[i] > remaining[i] {
+			off[i] = remaining[i]
+		}
+	}
+	return off
+}
+
+// Apply runs the eligible promotions in turn, each on what the ones before it
+// left to pay, so no line ever goes below zero. Ineligible promotions are
+// skipped.
+func Apply(promotions []model.Promotion, lines []Line, customer Customer, now time.Time) Result {
+	remaining := make([]int64, len(lines))
+	for i, line := range lines {
+		remaining[i] = line.gross().Amount
+	}
+
+	result := Result{
+		LineDiscounts: make([]model.Money, len(lines)),
+		Total:         model.Paise(0),
+	}
+	for i := range result.LineDiscounts {
+		result.LineDiscounts[i] = model.Paise(0)
+	}
+
+	for _, p := range promotions {
+		if Eligible(p, lines, customer, now) != nil {
+			continue
+		}
+
+		off := discounts(p, lines, remaining)
+		var total int64
+		for i, v := range off {
+			remaining[i] -= v
+			result.LineDiscounts[i] = result.LineDiscounts[i].Add(model.Paise(v))
+			total += v
+		}
+		if total == 0 {
+			continue
+		}
+
+		result.Applied = append(result.Applied, model.AppliedDiscount{
+			PromotionID: p.ID,
+			Code:        p.Code,
+			Name:        p.Name,
+			Amount:      model.Paise(total),
+		})
+		result.Total = result.Total.Add(model.Paise(total))
+	}
+	return result
+}
@@ -0,0 +1,204 @@
+package promo
+
+import (
+	"testing"
+	"time"
+
+	"backend/internal/model"
+)
+
+var noon = time.Date(2026, 10, 19, 12, 0, 0, 0, ist)
+
+func line(id string, unitPrice int64, quantity int) Line {
+	return Line{ProductID: id, UnitPrice: model.Paise(unitPrice), Quantity: quantity}
+}
+
+func percentage(id string, basisPoints int64) model.Promotion {
+	return model.Promotion{ID: id, Name: id, Type: Percentage, PercentOff: basisPoints, Active: true}
+}
+
+func flat(id string, amount int64) model.Promotion {
+	return model.Promotion{ID: id, Name: id, Type: Flat, AmountOff: model.Paise(amount), Active: true}
+}
+
+func buyXGetY(id string, buy, get int) model.Promotion {
+	return model.Promotion{ID: id, Name: id, Type: BuyXGetY, BuyQuantity: buy, GetQuantity: get, Active: true}
+}
+
+func amounts(money []model.Money) []int64 {
+	out := make([]int64, len(money))
+	for i, m := range money {
+		out[i] = m.Amount
+	}
+	return out
+}
+
+func equal(a, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestApply(t *testing.T) {
+	capped := percentage("half", 5000)
+	capped.MaxDiscount = model.Paise(120)
+
+	scoped := flat("flat", 100)
+	scoped.ProductIDs = []string{"a", "c"}
+
+	tests := []struct {
+		name       string
+		promotions []model.Promotion
+		lines      []Line
+		want       []int64
+		applied    []int64
+	}{
+		{
+			name:       "flat discount spread in proportion to each line",
+			promotions: []model.Promotion{flat("flat", 300)},
+			lines:      []Line{line("a", 100, 1), line("b", 200, 1), line("c", 300, 1)},
+			want:       []int64{50, 100, 150},
+			applied:    []int64{300},
+		},
+		{
+			name:       "flat discount rounding remainder goes to the last line",
+			promotions: []model.Promotion{flat("flat", 100)},
+			lines:      []Line{line("a", 100, 1), line("b", 200, 1), line("c", 400, 1)},
+			want:       []int64{14, 28, 58},
+			applied:    []int64{100},
+		},
+		{
+			name:       "flat discount only spread over lines in scope",
+			promotions: []model.Promotion{scoped},
+			lines:      []Line{line("a", 100, 1), line("b", 500, 1), line("c", 300, 1)},
+			want:       []int64{25, 0, 75},
+			applied:    []int64{100},
+		},
+		{
+			name:       "flat discount worth more than the order stops at zero",
+			promotions: []model.Promotion{flat("flat", 1000)},
+			lines:      []Line{line("a", 100, 1), line("b", 200, 1)},
+			want:       []int64{100, 200},
+			applied:    []int64{300},
+		},
+		{
+			name:       "max discount taken back from the last lines first",
+			promotions: []model.Promotion{capped},
+			lines:      []Line{line("a", 200, 1), line("b", 100, 1), line("c", 60, 1)},
+			want:       []int64{100, 20, 0},
+			applied:    []int64{120},
+		},
+		{
+			name:       "buy two get one free",
+			promotions: []model.Promotion{buyXGetY("b2g1", 2, 1)},
+			lines:      []Line{line("a", 100, 7)},
+			want:       []int64{200},
+			applied:    []int64{200},
+		},
+		{
+			name:       "buy x get y after a percentage discount frees the discounted unit",
+			promotions: []model.Promotion{percentage("tenth", 1000), buyXGetY("b2g1", 2, 1)},
+			lines:      []Line{line("a", 100, 3)},
+			want:       []int64{120},
+			applied:    []int64{30, 90},
+		},
+		{
+			name:       "percentage discount after buy x get y",
+			promotions: []model.Promotion{buyXGetY("b2g1", 2, 1), percentage("tenth", 1000)},
+			lines:      []Line{line("a", 100, 3)},
+			want:       []int64{120},
+			applied:    []int64{100, 20},
+		},
+		{
+			name:       "ineligible promotions are skipped",
+			promotions: []model.Promotion{{ID: "off", Type: Percentage, PercentOff: 5000}, percentage("tenth", 1000)},
+			lines:      []Line{line("a", 100, 1)},
+			want:       []int64{10},
+			applied:    []int64{10},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := Apply(tt.promotions, tt.lines, Customer{}, noon)
+			if got := amounts(result.LineDiscounts); !equal(got, tt.want) {
+				t.Fatalf("line discounts = %v, want %v", got, tt.want)
+			}
+
+			var applied []int64
+			var total int64
+			for _, d := range result.Applied {
+				applied = append(applied, d.Amount.Amount)
+				total += d.Amount.Amount
+			}
+			if !equal(applied, tt.applied) {
+				t.Fatalf("applied = %v, want %v", applied, tt.applied)
+			}
+			if result.Total.Amount != total {
+				t.Fatalf("total = %d, want %d", result.Total.Amount, total)
+			}
+		})
+	}
+}
+
+func TestEligible(t *testing.T) {
+	lines := []Line{line("a", 100, 2)}
+	signedIn := Customer{UserID: "u1", Uses: map[string]int{}}
+	firstOrder := Customer{UserID: "u1", FirstOrder: true, Uses: map[string]int{}}
+	usedTwice := Customer{UserID: "u1", Uses: map[string]int{"p": 2}}
+
+	lunch := func(p *model.Promotion) { p.DailyFrom, p.DailyTo = "11:00", "15:00" }
+
+	tests := []struct {
+		name     string
+		change   func(*model.Promotion)
+		customer Customer
+		now      time.Time
+		ok       bool
+	}{
+		{name: "active promotion", customer: signedIn, now: noon, ok: true},
+		{name: "inactive", change: func(p *model.Promotion) { p.Active = false }, customer: signedIn, now: noon},
+		{name: "not started", change: func(p *model.Promotion) { p.StartsAt = noon.Add(time.Hour).Unix() }, customer: signedIn, now: noon},
+		{name: "ended", change: func(p *model.Promotion) { p.EndsAt = noon.Unix() }, customer: signedIn, now: noon},
+
+		{name: "daily window opens on the minute in IST", change: lunch, customer: signedIn, now: time.Date(2026, 10, 19, 5, 30, 0, 0, time.UTC), ok: true},
+		{name: "daily window before it opens in IST", change: lunch, customer: signedIn, now: time.Date(2026, 10, 19, 5, 29, 0, 0, time.UTC)},
+		{name: "daily window closes on the minute in IST", change: lunch, customer: signedIn, now: time.Date(2026, 10, 19, 9, 30, 0, 0, time.UTC)},
+		{name: "daily window uses IST not UTC", change: lunch, customer: signedIn, now: time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)},
+
+		{name: "total limit not reached", change: func(p *model.Promotion) { p.TotalLimit, p.Redemptions = 10, 9 }, customer: signedIn, now: noon, ok: true},
+		{name: "total limit reached", change: func(p *model.Promotion) { p.TotalLimit, p.Redemptions = 10, 10 }, customer: signedIn, now: noon},
+		{name: "per user limit not reached", change: func(p *model.Promotion) { p.PerUserLimit = 3 }, customer: usedTwice, now: noon, ok: true},
+		{name: "per user limit reached", change: func(p *model.Promotion) { p.PerUserLimit = 2 }, customer: usedTwice, now: noon},
+		{name: "per user limit needs a customer", change: func(p *model.Promotion) { p.PerUserLimit = 1 }, customer: Customer{}, now: noon},
+		{name: "first order", change: func(p *model.Promotion) { p.FirstOrderOnly = true }, customer: firstOrder, now: noon, ok: true},
+		{name: "not the first order", change: func(p *model.Promotion) { p.FirstOrderOnly = true }, customer: signedIn, now: noon},
+		{name: "first order needs a customer", change: func(p *model.Promotion) { p.FirstOrderOnly = true }, customer: Customer{FirstOrder: true}, now: noon},
+
+		{name: "no lines in scope", change: func(p *model.Promotion) { p.ProductIDs = []string{"b"} }, customer: signedIn, now: noon},
+		{name: "minimum order met", change: func(p *model.Promotion) { p.MinOrder = model.Paise(200) }, customer: signedIn, now: noon, ok: true},
+		{name: "minimum order not met", change: func(p *model.Promotion) { p.MinOrder = model.Paise(201) }, customer: signedIn, now: noon},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := percentage("p", 1000)
+			if tt.change != nil {
+				tt.change(&p)
+			}
+			err := Eligible(p, lines, tt.customer, tt.now)
+			if tt.ok && err != nil {
+				t.Fatalf("expected eligible, got %v", err)
+			}
+			if !tt.ok && err == nil {
+				t.Fatal("expected not eligible")
+			}
+		})
+	}
+}
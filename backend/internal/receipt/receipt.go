@@ -0,0 +1,212 @@
+// Package receipt renders order receipts and GST invoices as PDF, without
+// any external service or library.
+package receipt
+
+import (
+	"fmt"
+	"time"
+
+	"backend/internal/model"
+)
+
+// Seller is who the invoice is issued by.
+type Seller struct {
+	Name    string
+	Address string
+	GSTIN   string
+}
+
+var ist = time.FixedZone("IST", 5*60*60+30*60)
+
+const (
+	marginLeft  = 40.0
+	marginRight = pageWidth - 40.0
+	// Below this a new page is started
+	marginBottom = 110.0
+	rowHeight    = 15.0
+)
+
+// Right edges of the numeric table columns; the item name starts at the
+// left margin and the HSN code at hsnColumn.
+const (
+	hsnColumn     = 215.0
+	qtyColumn     = 285.0
+	rateColumn    = 335.0
+	taxableColumn = 390.0
+	gstColumn     = 425.0
+	cgstColumn    = 470.0
+	sgstColumn    = 515.0
+	amountColumn  = marginRight
+)
+
+// ratePercent formats a rate in basis points, e.g. 500 as "5%" and 25 as
+// "0.25%".
+func ratePercent(rate int64) string {
+	if rate%100 == 0 {
+		return fmt.Sprintf("%d%%", rate/100)
+	}
+	return fmt.Sprintf("%d.%02d%%", rate/100, rate%100)
+}
+
+// fit shortens s with "..." until it fits in width points.
+func fit(s string, size, width float64) string {
+	s = clean(s)
+	if textWidth(s, size) <= width {
+		return s
+	}
+	for len(s) > 0 && textWidth(s+"...", size) > width {
+		s = s[:len(s)-1]
+	}
+	return s + "..."
+}
+
+// taxable is the line's taxable value. Orders from before tax was recorded
+// have none, and are shown as untaxed.
+func taxable(item model.OrderItem) model.Money {
+	if item.Taxable.IsZero() {
+		return item.Total
+	}
+	return item.Taxable
+}
+
+// Render draws the receipt for an order. Orders with an invoice number are
+// titled as a tax invoice.
+func Render(order model.Order, seller Seller) []byte {
+	d := &document{}
+	d.newPage()
+
+	y := pageHeight - 50
+
+	d.text(marginLeft, y, 16, true, seller.Name)
+	title := "RECEIPT"
+	if order.InvoiceNumber != "" {
+		title = "TAX INVOICE"
+	}
+	d.textRight(marginRight, y, 14, true, title)
+	y -= 16
+	if seller.Address != "" {
+		d.text(marginLeft, y, 9, false, seller.Address)
+		y -= 12
+	}
+	if seller.GSTIN != "" {
+		d.text(marginLeft, y, 9, false, "GSTIN: "+seller.GSTIN)
+		y -= 12
+	}
+
+	y -= 10
+	d.line(marginLeft, y, marginRight, y)
+	y -= 18
+
+	issuedAt := order.InvoicedAt
+	if issuedAt == 0 {
+		issuedAt = order.CreatedAt
+	}
+	details := [][2]string{}
+	if order.InvoiceNumber != "" {
+		details = append(details, [2]string{"Invoice No", order.InvoiceNumber})
+	}
+	details = append(details,
+		[2]string{"Order ID", order.ID},
+		[2]string{"Date", time.Unix(issuedAt, 0).In(ist).Format("02 Jan 2006 15:04 MST")},
+		[2]string{"Customer", order.CustomerName},
+	)
+	if order.CustomerEmail != "" {
+		details = append(details, [2]string{"Email", order.CustomerEmail})
+	}
+	details = append(details, [2]string{"Payment", order.PaymentMethod})
+	if order.RazorpayPaymentID != "" {
+		details = append(details, [2]string{"Payment Ref", order.RazorpayPaymentID})
+	}
+	for _, row := range details {
+		d.text(marginLeft, y, 10, true, row[0])
+		d.text(marginLeft+80, y, 10, false, row[1])
+		y -= 14
+	}
+
+	y -= 10
+	header := func() {
+		d.line(marginLeft, y+12, marginRight, y+12)
+		d.text(marginLeft, y, 9, true, "Item")
+		d.text(hsnColumn, y, 9, true, "HSN")
+		d.textRight(qtyColumn, y, 9, true, "Qty")
+		d.textRight(rateColumn, y, 9, true, "Rate")
+		d.textRight(taxableColumn, y, 9, true, "Taxable")
+		d.textRight(gstColumn, y, 9, true, "GST")
+		d.textRight(cgstColumn, y, 9, true, "CGST")
+		d.textRight(sgstColumn, y, 9, true, "SGST")
+		d.textRight(amountColumn, y, 9, true, "Amount")
+		d.line(marginLeft, y-5, marginRight, y-5)
+		y -= rowHeight + 4
+	}
+	header()
+
+	for _, item := range order.Items {
+		if y < marginBottom {
+			d.newPage()
+			y = pageHeight - 50
+			header()
+		}
+		d.text(marginLeft, y, 9, false, fit(item.Name, 9, hsnColumn-marginLeft-8))
+		d.text(hsnColumn, y, 9, false, item.HSN)
+		d.textRight(qtyColumn, y, 9, false, fmt.Sprint(item.Quantity))
+		d.textRight(rateColumn, y, 9, false, item.Price.String())
+		d.textRight(taxableColumn, y, 9, false, taxable(item).String())
+		d.textRight(gstColumn, y, 9, false, ratePercent(item.TaxRate))
+		d.textRight(cgstColumn, y, 9, false, item.CGST.String())
+		d.textRight(sgstColumn, y, 9, false, item.SGST.String())
+		d.textRight(amountColumn, y, 9, false, item.Total.String())
+		y -= rowHeight
+	}
+
+	if y < marginBottom {
+		d.newPage()
+		y = pageHeight - 50
+	}
+	d.line(marginLeft, y+8, marginRight, y+8)
+	y -= 8
+
+	orderTaxable := order.Taxable
+	if orderTaxable.IsZero() {
+		orderTaxable = order.Total
+	}
+	var totals [][2]string
+	// Meals covered by a plan are part of the line discounts but shown apart
+	discount := order.DiscountTotal.Sub(order.MealValue)
+	if !discount.IsZero() {
+		totals = append(totals, [2]string{"Discount", "-" + discount.String()})
+	}
+	if order.MealsCovered > 0 {
+		totals = append(totals, [2]string{fmt.Sprintf("Meal plan (%d meals)", order.MealsCovered), "-" + order.MealValue.String()})
+	}
+	totals = append(totals,
+		[2]string{"Taxable value", orderTaxable.String()},
+		[2]string{"CGST", order.CGST.String()},
+		[2]string{"SGST", order.SGST.String()},
+	)
+	for _, row := range totals {
+		d.textRight(sgstColumn, y, 10, false, row[0])
+		d.textRight(amountColumn, y, 10, false, row[1])
+		y -= 14
+	}
+	d.textRight(sgstColumn, y, 11, true, "Total ("+orderCurrency(order)+")")
+	d.textRight(amountColumn, y, 11, true, order.Total.String())
+	if order.PointsRedeemed > 0 {
+		y -= 14
+		d.textRight(sgstColumn, y, 10, false, fmt.Sprintf("Paid with %d points", order.PointsRedeemed))
+		d.textRight(amountColumn, y, 10, false, "-"+order.PointsValue.String())
+		y -= 14
+		d.textRight(sgstColumn, y, 11, true, "Amount paid")
+		d.textRight(amountColumn, y, 11, true, order.Total.Sub(order.PointsValue).String())
+	}
+
+	d.text(marginLeft, 50, 8, false, "This is a computer generated document and needs no signature.")
+
+	return d.bytes()
+}
+
+func orderCurrency(order model.Order) string {
+	if order.Total.Currency == "" {
+		return model.DefaultCurrency
+	}
+	return order.Total.Currency
+}
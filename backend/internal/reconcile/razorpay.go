@@ -0,0 +1,65 @@
+package reconcile
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/razorpay/razorpay-go"
+)
+
+// pageSize is the most payments Razorpay returns per request.
+const pageSize = 100
+
+// Razorpay lists payments through the Razorpay API.
+type Razorpay struct {
+	Client *razorpay.Client
+}
+
+// Payments pages through every payment created between from and to.
+func (r Razorpay) Payments(ctx context.Context, from, to time.Time) ([]Payment, error) {
+	var payments []Payment
+	for skip := 0; ; skip += pageSize {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		page, err := r.Client.Payment.All(map[string]interface{}{
+			"from":  from.Unix(),
+			"to":    to.Unix(),
+			"count": pageSize,
+			"skip":  skip,
+		}, nil)
+		if err != nil {
+			return nil, fmt.Errorf("listing payments: %w", err)
+		}
+
+		items, _ := page["items"].([]interface{})
+		for _, item := range items {
+			fields, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			payments = append(payments, paymentFrom(fields))
+		}
+		if len(items) < pageSize {
+			return payments, nil
+		}
+	}
+}
+
+// paymentFrom reads a payment entity. JSON numbers arrive as float64.
+func paymentFrom(fields map[string]interface{}) Payment {
+	p := Payment{}
+	p.ID, _ = fields["id"].(string)
+	p.OrderID, _ = fields["order_id"].(string)
+	p.Currency, _ = fields["currency"].(string)
+	p.Status, _ = fields["status"].(string)
+	if amount, ok := fields["amount"].(float64); ok {
+		p.Amount = int64(amount)
+	}
+	if created, ok := fields["created_at"].(float64); ok {
+		p.CreatedAt = time.Unix(int64(created), 0)
+	}
+	return p
+}
@@ -0,0 +1,268 @@
+// Package reconcile compares the payments a gateway recorded with the orders
+// in the database and reports where they disagree. The comparison itself is
+// pure; fetching payments goes through the Gateway interface so tests can
+// use a fake one.
+package reconcile
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"backend/internal/model"
+)
+
+// Payment statuses as the gateway reports them
+const (
+	StatusCreated    = "created"
+	StatusAuthorized = "authorized"
+	StatusCaptured   = "captured"
+	StatusRefunded   = "refunded"
+	StatusFailed     = "failed"
+)
+
+// Payment is a payment as the gateway recorded it. Amount is in paise.
+type Payment struct {
+	ID        string
+	OrderID   string
+	Amount    int64
+	Currency  string
+	Status    string
+	CreatedAt time.Time
+}
+
+// Gateway lists the payments made between from and to.
+type Gateway interface {
+	Payments(ctx context.Context, from, to time.Time) ([]Payment, error)
+}
+
+// Kinds of mismatch
+const (
+	// Money was captured but the order is still unpaid
+	CapturedUnpaid = "captured_unpaid"
+	// The order is paid but the gateway has no captured payment for it
+	PaidNotCaptured = "paid_not_captured"
+	// The captured amount differs from what the order charged
+	AmountMismatch = "amount_mismatch"
+	// More than one payment was captured for the same order
+	DuplicatePayment = "duplicate_payment"
+	// The gateway refunded a payment for an order we still count as paid
+	RefundedStillPaid = "refunded_still_paid"
+	// The order was refunded here but the gateway still holds the payment
+	RefundMissing = "refund_missing"
+	// A captured payment matches no order we know of
+	UnknownPayment = "unknown_payment"
+)
+
+// What a charge was for
+const (
+	SourceOrder        = "order"
+	SourceShare        = "share"
+	SourceSubscription = "meal_subscription"
+)
+
+// Charge is something in the database that was paid, or is waiting to be
+// paid, through its own gateway order: an order, one share of a split order
+// or a meal plan subscription. Amount is in paise.
+type Charge struct {
+	Source string
+	// ID is the order, share or subscription; OrderID is the order a share
+	// belongs to
+	ID              string
+	OrderID         string
+	RazorpayOrderID string
+	Amount          int64
+	Paid            bool
+	Refunded        bool
+}
+
+// OrderCharge is what an order asked the gateway for.
+func OrderCharge(order model.Order) Charge {
+	return Charge{
+		Source:          SourceOrder,
+		ID:              order.ID,
+		OrderID:         order.ID,
+		RazorpayOrderID: order.RazorpayOrderID,
+		Amount:          charged(order),
+		Paid:            order.IsPaid,
+		Refunded:        order.RefundedAt != 0,
+	}
+}
+
+// ShareCharge is what one share of a split order asked the gateway for. A
+// share waiting to be refunded counts as refunded here, so it is reported
+// until the gateway has refunded it too.
+func ShareCharge(share model.PaymentShare) Charge {
+	return Charge{
+		Source:          SourceShare,
+		ID:              share.ID,
+		OrderID:         share.OrderID,
+		RazorpayOrderID: share.RazorpayOrderID,
+		Amount:          share.Amount.Amount,
+		Paid:            share.Status == "paid" || share.Status == "refund_due" || share.Status == "refunded",
+		Refunded:        share.Status == "refund_due" || share.Status == "refunded",
+	}
+}
+
+// SubscriptionCharge is what buying a meal plan subscription asked the
+// gateway for.
+func SubscriptionCharge(sub model.MealSubscription) Charge {
+	return Charge{
+		Source:          SourceSubscription,
+		ID:              sub.ID,
+		RazorpayOrderID: sub.RazorpayOrderID,
+		Amount:          sub.Price.Amount,
+		Paid:            sub.PaidAt != 0,
+	}
+}
+
+// Mismatch is one disagreement between the gateway and the database.
+type Mismatch struct {
+	Kind string `json:"kind"`
+	// Source and SourceID are the charge the payment was for
+	Source          string `json:"source,omitempty"`
+	SourceID        string `json:"sourceId,omitempty"`
+	OrderID         string `json:"orderId,omitempty"`
+	RazorpayOrderID string `json:"razorpayOrderId,omitempty"`
+	PaymentID       string `json:"paymentId,omitempty"`
+	// Expected is what the order charged and Actual what the gateway holds,
+	// in paise
+	Expected int64  `json:"expected"`
+	Actual   int64  `json:"actual"`
+	Detail   string `json:"detail"`
+	// AutoFix is set when the mismatch is safe to fix without a person
+	// looking at it: the order is marked paid with the captured payment.
+	// Only orders are fixed this way
+	AutoFix bool `json:"autoFix"`
+}
+
+// Report is the outcome of one reconciliation run.
+type Report struct {
+	From       time.Time  `json:"from"`
+	To         time.Time  `json:"to"`
+	Payments   int        `json:"payments"`
+	Orders     int        `json:"orders"`
+	Matched    int        `json:"matched"`
+	Mismatches []Mismatch `json:"mismatches"`
+}
+
+// charged is what the order asked the gateway for.
+func charged(order model.Order) int64 {
+	return order.Total.Sub(order.PointsValue).Amount
+}
+
+// Reconcile matches payments to orders by gateway order ID. Orders are those
+// paid through the gateway in the period, plus any order a payment refers
+// to; ignore lists gateway order IDs that belong to something other than an
+// order, such as wallet top-ups.
+func Reconcile(payments []Payment, orders []model.Order, ignore map[string]bool) Report {
+	charges := make([]Charge, 0, len(orders))
+	for _, order := range orders {
+		charges = append(charges, OrderCharge(order))
+	}
+	return ReconcileCharges(payments, charges, ignore)
+}
+
+// ReconcileCharges matches payments to charges of any kind by gateway order
+// ID, the same way Reconcile does for orders.
+func ReconcileCharges(payments []Payment, charges []Charge, ignore map[string]bool) Report {
+	report := Report{Payments: len(payments), Orders: len(charges)}
+
+	byOrder := map[string][]Payment{}
+	for _, p := range payments {
+		byOrder[p.OrderID] = append(byOrder[p.OrderID], p)
+	}
+
+	seen := map[string]bool{}
+	for _, charge := range charges {
+		if charge.RazorpayOrderID == "" {
+			continue
+		}
+		seen[charge.RazorpayOrderID] = true
+
+		var captured, refunded []Payment
+		for _, p := range byOrder[charge.RazorpayOrderID] {
+			switch p.Status {
+			case StatusCaptured:
+				captured = append(captured, p)
+			case StatusRefunded:
+				refunded = append(refunded, p)
+			}
+		}
+
+		base := Mismatch{
+			Source:          charge.Source,
+			OrderID:         charge.OrderID,
+			RazorpayOrderID: charge.RazorpayOrderID,
+			Expected:        charge.Amount,
+		}
+		if charge.Source != SourceOrder {
+			base.SourceID = charge.ID
+		}
+		found := func(kind string, p Payment, detail string, autoFix bool) {
+			m := base
+			m.Kind = kind
+			m.PaymentID = p.ID
+			m.Actual = p.Amount
+			m.Detail = detail
+			m.AutoFix = autoFix && charge.Source == SourceOrder
+			report.Mismatches = append(report.Mismatches, m)
+		}
+
+		switch {
+		case len(captured) > 1:
+			for _, p := range captured[1:] {
+				found(DuplicatePayment, p, fmt.Sprintf("%d payments captured for one %s; refund the extra ones", len(captured), charge.Source), false)
+			}
+
+		case len(captured) == 1:
+			p := captured[0]
+			if p.Amount != charge.Amount {
+				found(AmountMismatch, p, "captured amount differs from what was charged", false)
+			} else if !charge.Paid {
+				found(CapturedUnpaid, p, fmt.Sprintf("payment captured but the %s was never completed", charge.Source), !charge.Refunded)
+			} else if charge.Refunded {
+				found(RefundMissing, p, fmt.Sprintf("%s refunded here but the payment is still captured at the gateway", charge.Source), false)
+			} else {
+				report.Matched++
+			}
+
+		case len(refunded) > 0:
+			if charge.Paid && !charge.Refunded {
+				found(RefundedStillPaid, refunded[0], fmt.Sprintf("gateway refunded the payment but the %s is still paid", charge.Source), false)
+			} else {
+				report.Matched++
+			}
+
+		case charge.Paid && !charge.Refunded:
+			found(PaidNotCaptured, Payment{}, fmt.Sprintf("%s is paid but no captured payment was found", charge.Source), false)
+		}
+	}
+
+	for orderID, list := range byOrder {
+		if seen[orderID] || ignore[orderID] {
+			continue
+		}
+		for _, p := range list {
+			if p.Status == StatusCaptured {
+				report.Mismatches = append(report.Mismatches, Mismatch{
+					Kind:            UnknownPayment,
+					RazorpayOrderID: orderID,
+					PaymentID:       p.ID,
+					Actual:          p.Amount,
+					Detail:          "captured payment matches no order",
+				})
+			}
+		}
+	}
+
+	sort.SliceStable(report.Mismatches, func(i, j int) bool {
+		a, b := report.Mismatches[i], report.Mismatches[j]
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		return a.PaymentID < b.PaymentID
+	})
+	return report
+}
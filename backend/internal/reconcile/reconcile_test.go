@@ -0,0 +1,226 @@
+package reconcile
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"backend/internal/model"
+)
+
+// fakeGateway serves a fixed set of payments, filtered by time like the
+// real API.
+type fakeGateway struct {
+	payments []Payment
+}
+
+func (f fakeGateway) Payments(ctx context.Context, from, to time.Time) ([]Payment, error) {
+	var out []Payment
+	for _, p := range f.payments {
+		if !p.CreatedAt.Before(from) && p.CreatedAt.Before(to) {
+			out = append(out, p)
+		}
+	}
+	return out, nil
+}
+
+var day = time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC)
+
+func payment(id, orderID string, amount int64, status string) Payment {
+	return Payment{ID: id, OrderID: orderID, Amount: amount, Currency: "INR", Status: status, CreatedAt: day.Add(12 * time.Hour)}
+}
+
+func order(id, razorpayOrderID string, total int64, paid bool) model.Order {
+	return model.Order{ID: id, RazorpayOrderID: razorpayOrderID, Total: model.Paise(total), IsPaid: paid, PaymentMethod: "razorpay"}
+}
+
+func runReconcile(t *testing.T, payments []Payment, orders []model.Order, ignore map[string]bool) Report {
+	t.Helper()
+	gateway := fakeGateway{payments: payments}
+	fetched, err := gateway.Payments(context.Background(), day, day.Add(24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Reconcile(fetched, orders, ignore)
+}
+
+func kinds(report Report) map[string]Mismatch {
+	out := map[string]Mismatch{}
+	for _, m := range report.Mismatches {
+		out[m.Kind] = m
+	}
+	return out
+}
+
+func TestReconcileMatchesPaidOrders(t *testing.T) {
+	report := runReconcile(t,
+		[]Payment{payment("pay_1", "order_1", 12000, StatusCaptured)},
+		[]model.Order{order("o1", "order_1", 12000, true)},
+		nil,
+	)
+	if report.Matched != 1 || len(report.Mismatches) != 0 {
+		t.Fatalf("expected one clean match, got %+v", report)
+	}
+}
+
+func TestReconcileCapturedButPendingIsAutoFixable(t *testing.T) {
+	report := runReconcile(t,
+		[]Payment{payment("pay_1", "order_1", 12000, StatusCaptured)},
+		[]model.Order{order("o1", "order_1", 12000, false)},
+		nil,
+	)
+	m, ok := kinds(report)[CapturedUnpaid]
+	if !ok || len(report.Mismatches) != 1 {
+		t.Fatalf("expected a single captured_unpaid mismatch, got %+v", report.Mismatches)
+	}
+	if !m.AutoFix || m.OrderID != "o1" || m.PaymentID != "pay_1" {
+		t.Fatalf("unexpected mismatch %+v", m)
+	}
+}
+
+func TestReconcileAmountMismatchNeedsReview(t *testing.T) {
+	report := runReconcile(t,
+		[]Payment{payment("pay_1", "order_1", 10000, StatusCaptured)},
+		[]model.Order{order("o1", "order_1", 12000, false)},
+		nil,
+	)
+	m, ok := kinds(report)[AmountMismatch]
+	if !ok || m.AutoFix {
+		t.Fatalf("expected a manual amount_mismatch, got %+v", report.Mismatches)
+	}
+	if m.Expected != 12000 || m.Actual != 10000 {
+		t.Fatalf("unexpected amounts %+v", m)
+	}
+}
+
+func TestReconcileAmountAllowsForPoints(t *testing.T) {
+	o := order("o1", "order_1", 12000, true)
+	o.PointsRedeemed = 8
+	o.PointsValue = model.Paise(2000)
+
+	report := runReconcile(t,
+		[]Payment{payment("pay_1", "order_1", 10000, StatusCaptured)},
+		[]model.Order{o},
+		nil,
+	)
+	if report.Matched != 1 || len(report.Mismatches) != 0 {
+		t.Fatalf("expected points to be allowed for, got %+v", report.Mismatches)
+	}
+}
+
+func TestReconcilePaidWithoutCapture(t *testing.T) {
+	report := runReconcile(t,
+		[]Payment{payment("pay_1", "order_1", 12000, StatusFailed)},
+		[]model.Order{order("o1", "order_1", 12000, true)},
+		nil,
+	)
+	if _, ok := kinds(report)[PaidNotCaptured]; !ok {
+		t.Fatalf("expected paid_not_captured, got %+v", report.Mismatches)
+	}
+}
+
+func TestReconcileDuplicatePayments(t *testing.T) {
+	report := runReconcile(t,
+		[]Payment{
+			payment("pay_1", "order_1", 12000, StatusCaptured),
+			payment("pay_2", "order_1", 12000, StatusCaptured),
+		},
+		[]model.Order{order("o1", "order_1", 12000, true)},
+		nil,
+	)
+	m, ok := kinds(report)[DuplicatePayment]
+	if !ok || m.PaymentID != "pay_2" || m.AutoFix {
+		t.Fatalf("expected the second payment flagged as a duplicate, got %+v", report.Mismatches)
+	}
+}
+
+func TestReconcileRefunds(t *testing.T) {
+	refundedHere := order("o2", "order_2", 5000, true)
+	refundedHere.RefundedAt = day.Unix()
+
+	report := runReconcile(t,
+		[]Payment{
+			payment("pay_1", "order_1", 12000, StatusRefunded),
+			payment("pay_2", "order_2", 5000, StatusCaptured),
+		},
+		[]model.Order{order("o1", "order_1", 12000, true), refundedHere},
+		nil,
+	)
+	found := kinds(report)
+	if _, ok := found[RefundedStillPaid]; !ok {
+		t.Errorf("expected refunded_still_paid, got %+v", report.Mismatches)
+	}
+	if _, ok := found[RefundMissing]; !ok {
+		t.Errorf("expected refund_missing, got %+v", report.Mismatches)
+	}
+}
+
+func TestReconcileUnknownPaymentsSkipIgnored(t *testing.T) {
+	report := runReconcile(t,
+		[]Payment{
+			payment("pay_1", "order_topup", 50000, StatusCaptured),
+			payment("pay_2", "order_stray", 700, StatusCaptured),
+		},
+		nil,
+		map[string]bool{"order_topup": true},
+	)
+	if len(report.Mismatches) != 1 || report.Mismatches[0].Kind != UnknownPayment || report.Mismatches[0].PaymentID != "pay_2" {
+		t.Fatalf("expected only the stray payment reported, got %+v", report.Mismatches)
+	}
+}
+
+func TestFakeGatewayFiltersByPeriod(t *testing.T) {
+	late := payment("pay_late", "order_1", 12000, StatusCaptured)
+	late.CreatedAt = day.Add(30 * time.Hour)
+
+	report := runReconcile(t, []Payment{late}, []model.Order{order("o1", "order_1", 12000, false)}, nil)
+	if report.Payments != 0 || len(report.Mismatches) != 0 {
+		t.Fatalf("expected the out-of-period payment to be skipped, got %+v", report)
+	}
+}
+
+func share(id, orderID, razorpayOrderID string, amount int64, status string) model.PaymentShare {
+	return model.PaymentShare{ID: id, OrderID: orderID, RazorpayOrderID: razorpayOrderID, Amount: model.Paise(amount), Status: status}
+}
+
+func TestReconcileShares(t *testing.T) {
+	fetched, _ := fakeGateway{payments: []Payment{
+		payment("pay_1", "order_s1", 6000, StatusCaptured),
+		payment("pay_2", "order_s2", 6000, StatusCaptured),
+		payment("pay_3", "order_s3", 6000, StatusCaptured),
+	}}.Payments(context.Background(), day, day.Add(24*time.Hour))
+
+	report := ReconcileCharges(fetched, []Charge{
+		ShareCharge(share("s1", "o1", "order_s1", 6000, "paid")),
+		ShareCharge(share("s2", "o1", "order_s2", 6000, "pending")),
+		ShareCharge(share("s3", "o2", "order_s3", 6000, "refund_due")),
+	}, nil)
+
+	if report.Matched != 1 || len(report.Mismatches) != 2 {
+		t.Fatalf("expected one match and two mismatches, got %+v", report)
+	}
+	found := kinds(report)
+	unpaid, ok := found[CapturedUnpaid]
+	if !ok || unpaid.AutoFix || unpaid.Source != SourceShare || unpaid.SourceID != "s2" || unpaid.OrderID != "o1" {
+		t.Errorf("expected a manual captured_unpaid for share s2, got %+v", report.Mismatches)
+	}
+	if m, ok := found[RefundMissing]; !ok || m.SourceID != "s3" {
+		t.Errorf("expected refund_missing for share s3, got %+v", report.Mismatches)
+	}
+}
+
+func TestReconcileSubscriptions(t *testing.T) {
+	paid := model.MealSubscription{ID: "m1", RazorpayOrderID: "order_m1", Price: model.Paise(150000), PaidAt: day.Unix()}
+	short := model.MealSubscription{ID: "m2", RazorpayOrderID: "order_m2", Price: model.Paise(150000), PaidAt: day.Unix()}
+
+	fetched, _ := fakeGateway{payments: []Payment{
+		payment("pay_1", "order_m1", 150000, StatusCaptured),
+		payment("pay_2", "order_m2", 100000, StatusCaptured),
+	}}.Payments(context.Background(), day, day.Add(24*time.Hour))
+
+	report := ReconcileCharges(fetched, []Charge{SubscriptionCharge(paid), SubscriptionCharge(short)}, nil)
+	m, ok := kinds(report)[AmountMismatch]
+	if report.Matched != 1 || !ok || m.Source != SourceSubscription || m.SourceID != "m2" {
+		t.Fatalf("expected the short subscription payment flagged, got %+v", report)
+	}
+}
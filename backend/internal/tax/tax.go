@@ -0,0 +1,53 @@
+// Package tax computes GST on order lines. Rates are in basis points (500 is
+// 5%) so every calculation stays in integer paise.
+package tax
+
+import "backend/internal/model"
+
+// Rates are the GST slabs a product may be classed under.
+var Rates = []int64{0, 25, 300, 500, 1200, 1800, 2800}
+
+// ValidRate reports whether rate is one of the GST slabs.
+func ValidRate(rate int64) bool {
+	for _, r := range Rates {
+		if r == rate {
+			return true
+		}
+	}
+	return false
+}
+
+// Line is the tax on one order line. Sales in the canteen are intra-state,
+// so the tax is always split evenly into CGST and SGST.
+type Line struct {
+	Taxable model.Money
+	CGST    model.Money
+	SGST    model.Money
+	Tax     model.Money
+	Total   model.Money
+}
+
+// divRound divides with halves rounded up, for non-negative operands.
+func divRound(a, b int64) int64 {
+	return (a*2 + b) / (b * 2)
+}
+
+// Compute works out the tax on a line charged gross, after any discount.
+// Inclusive prices already contain the tax, which is backed out of the line
+// total; otherwise it is added on top.
+func Compute(gross model.Money, rate int64, inclusive bool) Line {
+	var line Line
+	if inclusive {
+		line.Total = gross
+		line.Taxable = model.Money{Amount: divRound(gross.Amount*10000, 10000+rate), Currency: gross.Currency}
+		line.Tax = gross.Sub(line.Taxable)
+	} else {
+		line.Taxable = gross
+		line.Tax = model.Money{Amount: divRound(gross.Amount*rate, 10000), Currency: gross.Currency}
+		line.Total = gross.Add(line.Tax)
+	}
+
+	line.CGST = model.Money{Amount: line.Tax.Amount / 2, Currency: gross.Currency}
+	line.SGST = line.Tax.Sub(line.CGST)
+	return line
+}
@@ -0,0 +1,60 @@
+package tax
+
+import (
+	"testing"
+
+	"backend/internal/model"
+)
+
+func TestCompute(t *testing.T) {
+	tests := []struct {
+		name      string
+		gross     int64
+		rate      int64
+		inclusive bool
+		taxable   int64
+		cgst      int64
+		sgst      int64
+		total     int64
+	}{
+		{name: "exclusive even split", gross: 10000, rate: 500, taxable: 10000, cgst: 250, sgst: 250, total: 10500},
+		{name: "exclusive half paisa rounds up", gross: 10010, rate: 500, taxable: 10010, cgst: 250, sgst: 251, total: 10511},
+		{name: "exclusive rounds to nearest", gross: 999, rate: 1800, taxable: 999, cgst: 90, sgst: 90, total: 1179},
+		{name: "exclusive odd paisa goes to sgst", gross: 200, rate: 25, taxable: 200, cgst: 0, sgst: 1, total: 201},
+		{name: "exclusive too small to tax", gross: 1, rate: 2800, taxable: 1, cgst: 0, sgst: 0, total: 1},
+		{name: "exempt", gross: 4999, rate: 0, taxable: 4999, cgst: 0, sgst: 0, total: 4999},
+		{name: "inclusive even split", gross: 10500, rate: 500, inclusive: true, taxable: 10000, cgst: 250, sgst: 250, total: 10500},
+		{name: "inclusive odd paisa goes to sgst", gross: 100, rate: 1200, inclusive: true, taxable: 89, cgst: 5, sgst: 6, total: 100},
+		{name: "inclusive zero", gross: 0, rate: 1800, inclusive: true, taxable: 0, cgst: 0, sgst: 0, total: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			line := Compute(model.Paise(tt.gross), tt.rate, tt.inclusive)
+			got := [4]int64{line.Taxable.Amount, line.CGST.Amount, line.SGST.Amount, line.Total.Amount}
+			want := [4]int64{tt.taxable, tt.cgst, tt.sgst, tt.total}
+			if got != want {
+				t.Fatalf("taxable, cgst, sgst, total = %v, want %v", got, want)
+			}
+			if line.Tax.Amount != line.CGST.Amount+line.SGST.Amount {
+				t.Fatalf("tax %d is not cgst %d + sgst %d", line.Tax.Amount, line.CGST.Amount, line.SGST.Amount)
+			}
+			if line.Taxable.Amount+line.Tax.Amount != line.Total.Amount {
+				t.Fatalf("taxable %d + tax %d is not total %d", line.Taxable.Amount, line.Tax.Amount, line.Total.Amount)
+			}
+		})
+	}
+}
+
+func TestValidRate(t *testing.T) {
+	for _, rate := range Rates {
+		if !ValidRate(rate) {
+			t.Errorf("ValidRate(%d) = false, want true", rate)
+		}
+	}
+	for _, rate := range []int64{-500, 5, 1000, 1500, 10000} {
+		if ValidRate(rate) {
+			t.Errorf("ValidRate(%d) = true, want false", rate)
+		}
+	}
+}
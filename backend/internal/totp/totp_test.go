@@ -0,0 +1,73 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+// The SHA-1 secret from RFC 6238's test vectors, "12345678901234567890"
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCodeAt(t *testing.T) {
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{unix: 59, want: "287082"},
+		{unix: 1111111109, want: "081804"},
+		{unix: 1234567890, want: "005924"},
+		{unix: 2000000000, want: "279037"},
+	}
+
+	for _, tt := range tests {
+		got, err := CodeAt(rfcSecret, Step(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatalf("CodeAt(%d): %v", tt.unix, err)
+		}
+		if got != tt.want {
+			t.Errorf("CodeAt(%d) = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	step := Step(now)
+
+	codeAt := func(s int64) string {
+		code, err := CodeAt(rfcSecret, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return code
+	}
+
+	tests := []struct {
+		name     string
+		code     string
+		lastStep int64
+		want     int64
+		ok       bool
+	}{
+		{name: "current step", code: codeAt(step), want: step, ok: true},
+		{name: "one step behind", code: codeAt(step - 1), want: step - 1, ok: true},
+		{name: "one step ahead", code: codeAt(step + 1), want: step + 1, ok: true},
+		{name: "two steps behind", code: codeAt(step - 2)},
+		{name: "two steps ahead", code: codeAt(step + 2)},
+		{name: "spaces ignored", code: " " + codeAt(step)[:3] + " " + codeAt(step)[3:] + " ", want: step, ok: true},
+		{name: "replayed", code: codeAt(step), lastStep: step},
+		{name: "earlier step after a later one was used", code: codeAt(step - 1), lastStep: step - 1},
+		{name: "later step after an earlier one was used", code: codeAt(step + 1), lastStep: step, want: step + 1, ok: true},
+		{name: "too short", code: codeAt(step)[:5]},
+		{name: "wrong code", code: "000000"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := Validate(rfcSecret, tt.code, now, tt.lastStep)
+			if ok != tt.ok || got != tt.want {
+				t.Fatalf("Validate(%q) = %d, %v, want %d, %v", tt.code, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
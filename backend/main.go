@@ -1,51 +1,52 @@
 package main
 
 import (
+	"backend/internal/auth"
 	"backend/internal/controllers"
-	"backend/internal/middleware"
+	"backend/internal/mail"
 	database "backend/internal/mogodb"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
+	"github.com/joho/godotenv"
+	"log"
+	"log/slog"
 	"time"
 )
 
 func main() {
+	if err := godotenv.Load(); err != nil {
+		slog.Error("No .env file found, falling back to system environment")
+	}
+
+	if err := auth.LoadKeys(); err != nil {
+		log.Fatal(err)
+	}
+	if err := mail.Init(); err != nil {
+		log.Fatal(err)
+	}
+
 	database.Connect()
+	database.EnsureIndexes()
+
+	// Refund split payments that weren't completed in time, and give back
+	// what other unpaid orders held
+	go controllers.ExpireSplitPayments(time.Minute)
+	go controllers.ExpireHeldOrders(time.Minute)
 
 	r := gin.Default()
 
 	// Enable CORS
 	r.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"http://localhost:5173"},
-		AllowMethods:     []string{"GET", "POST", "PUT","PATCH", "DELETE", "OPTIONS"},
+		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
 		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
 		ExposeHeaders:    []string{"Content-Length"},
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
 	}))
 
-	// Routes
-	r.POST("/signup", controllers.Signup)
-
-	r.POST("/login", controllers.Login)
-
-	r.POST("/products", middlewares.AuthMiddleware(), controllers.AddProduct)
-	r.GET("/products", middlewares.AuthMiddleware(), controllers.GetProducts)
-	r.PUT("/products/:id", middlewares.AuthMiddleware(), controllers.EditProduct)
-	r.DELETE("/products/:id", middlewares.AuthMiddleware(), controllers.DeleteProduct)
-
-	r.POST("/addtocart/:id/:user_id", middlewares.AuthMiddleware(), controllers.AddtoCart)
-	r.GET("/user/cart", middlewares.AuthMiddleware(), controllers.GetCart)
-	r.PATCH("/user/cart/:user_id/:id", middlewares.AuthMiddleware(), controllers.UpdateCart)
-	r.DELETE("/user/cart/:user_id/:id", middlewares.AuthMiddleware(), controllers.RemoveCart)
-
-	r.POST("/user/order", middlewares.AuthMiddleware(), controllers.CreateOrder)
-	r.POST("/user/payment/verify", middlewares.AuthMiddleware(), controllers.VerifyPayment)
-	r.GET("/user/order/history", middlewares.AuthMiddleware(), controllers.GetOrder)
-
-	r.GET("/admin/orders", middlewares.AuthMiddleware(), controllers.GetAllOrders)
-	r.PATCH("/admin/order/:id/deliver", middlewares.AuthMiddleware(), controllers.MarkOrderDelivered)
+	registerRoutes(r)
 
 	r.Run(":8080")
 }
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"backend/internal/controllers"
+	"backend/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+// policy is the access rule a route is registered with.
+type policy struct {
+	name     string
+	handlers []gin.HandlerFunc
+}
+
+// public routes need no token at all.
+func public() policy {
+	return policy{name: "public"}
+}
+
+// authenticated routes accept any logged-in caller.
+func authenticated() policy {
+	return policy{name: "authenticated", handlers: []gin.HandlerFunc{middlewares.AuthMiddleware()}}
+}
+
+// requires routes accept callers whose role grants every permission.
+func requires(perms ...middlewares.Permission) policy {
+	name := "requires"
+	for _, perm := range perms {
+		name += " " + string(perm)
+	}
+	return policy{name: name, handlers: []gin.HandlerFunc{
+		middlewares.AuthMiddleware(),
+		middlewares.RequirePermission(perms...),
+	}}
+}
+
+// routePolicies records the policy of every route registered through handle,
+// keyed by "METHOD path".
+var routePolicies = map[string]string{}
+
+// handle registers a route together with its access policy. Routes must not
+// be added to the engine any other way.
+func handle(r *gin.Engine, method, path string, p policy, handler gin.HandlerFunc) {
+	routePolicies[method+" "+path] = p.name
+	r.Handle(method, path, append(p.handlers, handler)...)
+}
+
+func registerRoutes(r *gin.Engine) {
+	handle(r, "POST", "/signup", public(), controllers.Signup)
+	handle(r, "POST", "/email/verify", public(), controllers.VerifyEmail)
+	handle(r, "POST", "/email/verify/resend", public(), controllers.ResendVerification)
+
+	handle(r, "POST", "/login", public(), controllers.Login)
+	handle(r, "POST", "/login/mfa", public(), controllers.LoginMFA)
+	handle(r, "POST", "/token/refresh", public(), controllers.RefreshToken)
+	handle(r, "GET", "/.well-known/jwks.json", public(), controllers.JWKS)
+	handle(r, "POST", "/logout", authenticated(), controllers.Logout)
+	handle(r, "POST", "/password/forgot", public(), controllers.ForgotPassword)
+	handle(r, "POST", "/password/reset", public(), controllers.ResetPassword)
+
+	handle(r, "GET", "/user/profile", authenticated(), controllers.GetProfile)
+	handle(r, "PATCH", "/user/profile", authenticated(), controllers.UpdateProfile)
+	handle(r, "POST", "/user/password", authenticated(), controllers.ChangePassword)
+	handle(r, "POST", "/user/2fa/setup", authenticated(), controllers.SetupTOTP)
+	handle(r, "POST", "/user/2fa/enable", authenticated(), controllers.EnableTOTP)
+	handle(r, "POST", "/user/2fa/disable", authenticated(), controllers.DisableTOTP)
+
+	handle(r, "POST", "/products", requires(middlewares.PermProductsWrite), controllers.AddProduct)
+	handle(r, "GET", "/products", requires(middlewares.PermProductsRead), controllers.GetProducts)
+	handle(r, "PUT", "/products/:id", requires(middlewares.PermProductsWrite), controllers.EditProduct)
+	handle(r, "DELETE", "/products/:id", requires(middlewares.PermProductsWrite), controllers.DeleteProduct)
+
+	handle(r, "GET", "/user/cart", requires(middlewares.PermCartWrite), controllers.GetCart)
+	handle(r, "POST", "/user/cart/items/:productId", requires(middlewares.PermCartWrite), controllers.AddCartItem)
+	handle(r, "PATCH", "/user/cart/items/:productId", requires(middlewares.PermCartWrite), controllers.UpdateCartItem)
+	handle(r, "DELETE", "/user/cart/items/:productId", requires(middlewares.PermCartWrite), controllers.RemoveCartItem)
+	handle(r, "POST", "/user/cart/coupon", requires(middlewares.PermCartWrite), controllers.ApplyCartCoupon)
+	handle(r, "DELETE", "/user/cart/coupon", requires(middlewares.PermCartWrite), controllers.RemoveCartCoupon)
+
+	// Deprecated aliases; the :user_id must match the caller
+	handle(r, "POST", "/addtocart/:id/:user_id", requires(middlewares.PermCartWrite), controllers.AddtoCart)
+	handle(r, "PATCH", "/user/cart/:user_id/:id", requires(middlewares.PermCartWrite), controllers.UpdateCart)
+	handle(r, "DELETE", "/user/cart/:user_id/:id", requires(middlewares.PermCartWrite), controllers.RemoveCart)
+
+	handle(r, "POST", "/user/order", requires(middlewares.PermOrdersCreate), controllers.CreateOrder)
+	handle(r, "POST", "/user/payment/verify", requires(middlewares.PermOrdersCreate), controllers.VerifyPayment)
+	handle(r, "POST", "/user/payment/failed", requires(middlewares.PermOrdersCreate), controllers.PaymentFailed)
+	handle(r, "GET", "/user/order/:id/shares", requires(middlewares.PermOrdersCreate), controllers.GetOrderShares)
+	handle(r, "GET", "/user/wallet", requires(middlewares.PermWalletUse), controllers.GetWallet)
+	handle(r, "POST", "/user/wallet/topup", requires(middlewares.PermWalletUse), controllers.CreateWalletTopup)
+	handle(r, "POST", "/user/wallet/topup/verify", requires(middlewares.PermWalletUse), controllers.VerifyWalletTopup)
+	handle(r, "GET", "/user/meal-plans", requires(middlewares.PermMealPlansUse), controllers.GetMealPlans)
+	handle(r, "POST", "/user/meal-plans/:id/subscribe", requires(middlewares.PermMealPlansUse), controllers.SubscribeMealPlan)
+	handle(r, "POST", "/user/meal-plans/verify", requires(middlewares.PermMealPlansUse), controllers.VerifyMealSubscription)
+	handle(r, "GET", "/user/loyalty", requires(middlewares.PermOrdersReadOwn), controllers.GetLoyalty)
+	handle(r, "GET", "/user/order/history", requires(middlewares.PermOrdersReadOwn), controllers.GetOrder)
+	handle(r, "GET", "/user/order/:id/receipt.pdf", requires(middlewares.PermOrdersReadOwn), controllers.GetOrderReceipt)
+
+	handle(r, "GET", "/admin/orders", requires(middlewares.PermOrdersReadAll), controllers.GetAllOrders)
+	handle(r, "PATCH", "/admin/order/:id/deliver", requires(middlewares.PermOrdersDeliver), controllers.MarkOrderDelivered)
+	handle(r, "GET", "/admin/order/:id/receipt.pdf", requires(middlewares.PermOrdersReadAll), controllers.GetOrderReceiptAdmin)
+	handle(r, "GET", "/admin/order/:id/payments", requires(middlewares.PermOrdersReadAll), controllers.GetOrderPayments)
+	handle(r, "POST", "/admin/order/:id/refund", requires(middlewares.PermOrdersRefund), controllers.RefundOrder)
+
+	handle(r, "POST", "/cashier/shift/open", requires(middlewares.PermCounterSell), controllers.OpenShift)
+	handle(r, "GET", "/cashier/shift", requires(middlewares.PermCounterSell), controllers.GetCurrentShift)
+	handle(r, "POST", "/cashier/shift/close", requires(middlewares.PermCounterSell), controllers.CloseShift)
+	handle(r, "POST", "/cashier/orders", requires(middlewares.PermCounterSell), controllers.CreateCounterOrder)
+	handle(r, "GET", "/admin/cash-shifts", requires(middlewares.PermReportsRead), controllers.ListCashShifts)
+
+	handle(r, "POST", "/admin/wastage", requires(middlewares.PermWastageRecord), controllers.RecordWastage)
+	handle(r, "GET", "/admin/wastage", requires(middlewares.PermReportsRead), controllers.GetWastage)
+	handle(r, "PUT", "/admin/menu/:date", requires(middlewares.PermMenuWrite), controllers.SetDailyMenu)
+	handle(r, "GET", "/admin/menu/:date", requires(middlewares.PermMenuWrite), controllers.GetDailyMenu)
+
+	handle(r, "GET", "/admin/reports/daily", requires(middlewares.PermReportsRead), controllers.GetDailyReport)
+	handle(r, "GET", "/admin/reports/meal-plans", requires(middlewares.PermReportsRead), controllers.GetMealPlanReport)
+
+	handle(r, "POST", "/admin/promotions", requires(middlewares.PermPromotionsManage), controllers.CreatePromotion)
+	handle(r, "GET", "/admin/promotions", requires(middlewares.PermPromotionsManage), controllers.ListPromotions)
+	handle(r, "DELETE", "/admin/promotions/:id", requires(middlewares.PermPromotionsManage), controllers.DeactivatePromotion)
+
+	handle(r, "POST", "/admin/meal-plans", requires(middlewares.PermMealPlansManage), controllers.CreateMealPlan)
+	handle(r, "GET", "/admin/meal-plans", requires(middlewares.PermMealPlansManage), controllers.ListMealPlans)
+	handle(r, "DELETE", "/admin/meal-plans/:id", requires(middlewares.PermMealPlansManage), controllers.DeactivateMealPlan)
+
+	handle(r, "POST", "/admin/roster/import", requires(middlewares.PermUsersManage), controllers.ImportRoster)
+
+	handle(r, "GET", "/admin/users", requires(middlewares.PermUsersManage), controllers.ListUsers)
+	handle(r, "PATCH", "/admin/users/:id/role", requires(middlewares.PermUsersManage), controllers.ChangeUserRole)
+	handle(r, "POST", "/admin/users/:id/disable", requires(middlewares.PermUsersManage), controllers.DisableUser)
+	handle(r, "POST", "/admin/users/:id/enable", requires(middlewares.PermUsersManage), controllers.EnableUser)
+	handle(r, "POST", "/admin/users/:id/force-password-reset", requires(middlewares.PermUsersManage), controllers.ForcePasswordReset)
+	handle(r, "POST", "/admin/users/:id/unlock", requires(middlewares.PermUsersManage), controllers.UnlockUser)
+	handle(r, "POST", "/admin/users/:id/mfa-enrolment", requires(middlewares.PermUsersManage), controllers.IssueMFAEnrolment)
+
+	handle(r, "GET", "/admin/users/:id/wallet", requires(middlewares.PermWalletManage), controllers.GetUserWallet)
+	handle(r, "POST", "/admin/users/:id/wallet/topup", requires(middlewares.PermWalletManage), controllers.CounterTopup)
+
+	handle(r, "POST", "/admin/devices", requires(middlewares.PermUsersManage), controllers.CreateDevice)
+	handle(r, "GET", "/admin/devices", requires(middlewares.PermUsersManage), controllers.ListDevices)
+	handle(r, "DELETE", "/admin/devices/:id", requires(middlewares.PermUsersManage), controllers.RevokeDevice)
+}
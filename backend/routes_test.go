@@ -0,0 +1,24 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestEveryRouteHasPolicy(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	registerRoutes(r)
+
+	routes := r.Routes()
+	if len(routes) == 0 {
+		t.Fatal("no routes registered")
+	}
+
+	for _, route := range routes {
+		if _, ok := routePolicies[route.Method+" "+route.Path]; !ok {
+			t.Errorf("%s %s is registered without an access policy", route.Method, route.Path)
+		}
+	}
+}
@@ -5,7 +5,6 @@ export default function UserCart() {
   const [total, setTotal] = useState(0);
   const [loading, setLoading] = useState(false);
   const token = localStorage.getItem("token");
-  const userId = localStorage.getItem("user_id"); 
 
   const fetchCart = async () => {
     try {
@@ -45,7 +44,7 @@ export default function UserCart() {
 
     try {
       const res = await fetch(
-        `http://localhost:8080/user/cart/${userId}/${productId}`,
+        `http://localhost:8080/user/cart/items/${productId}`,
         {
           method: "PATCH",
           headers: {
@@ -69,7 +68,7 @@ export default function UserCart() {
   const removeItem = async (productId) => {
     try {
       const res = await fetch(
-        `http://localhost:8080/user/cart/${userId}/${productId}`,
+        `http://localhost:8080/user/cart/items/${productId}`,
         {
           method: "DELETE",
           headers: {
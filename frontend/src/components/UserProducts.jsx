@@ -6,7 +6,6 @@ export default function UserProducts() {
   const [selectedProduct, setSelectedProduct] = useState(null);
   const [quantity, setQuantity] = useState(1);
   const token = localStorage.getItem("token");
-  const userId = localStorage.getItem("user_id");
 
   const fetchProducts = async () => {
     try {
@@ -37,7 +36,7 @@ export default function UserProducts() {
 
   const handleAddProductToCart = async () => {
     try {
-      const res = await fetch(`http://localhost:8080/addtocart/${selectedProduct.id}/${userId}`, {
+      const res = await fetch(`http://localhost:8080/user/cart/items/${selectedProduct.id}`, {
         method: "POST",
         headers: {
           "Content-Type": "application/json",